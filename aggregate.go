@@ -0,0 +1,126 @@
+package rbush
+
+// Aggregator summarizes items for Aggregate. Summaries form a monoid: Merge
+// must be associative, and a nil summary stands for no items. Summaries are
+// shared between nodes and must not be changed after they are returned.
+type Aggregator interface {
+	// Summary returns the summary of a single item.
+	Summary(item Item) interface{}
+	// Merge returns the combined summary of two non-nil summaries.
+	Merge(a, b interface{}) interface{}
+}
+
+func (tr *RBush) merge(a, b interface{}) interface{} {
+	if a == nil {
+		return b
+	}
+	return tr.aggregator.Merge(a, b)
+}
+
+// calcAggregates sets the count and summary of a node from its children.
+func (tr *RBush) calcAggregates(node *treeNode) {
+	node.count = 0
+	node.summary = nil
+	for _, ptr := range node.children {
+		if node.leaf {
+			node.count++
+			if tr.aggregator != nil {
+				node.summary = tr.merge(node.summary, tr.aggregator.Summary(ptr.(Item)))
+			}
+		} else {
+			child := ptr.(*treeNode)
+			node.count += child.count
+			if tr.aggregator != nil && child.summary != nil {
+				node.summary = tr.merge(node.summary, child.summary)
+			}
+		}
+	}
+}
+
+// addAggregates adds an inserted entry to the nodes along the path.
+func (tr *RBush) addAggregates(entry interface{}, isNode bool, path []*treeNode, level int) {
+	count := 1
+	var summary interface{}
+	if isNode {
+		node := entry.(*treeNode)
+		count, summary = node.count, node.summary
+	} else if tr.aggregator != nil {
+		summary = tr.aggregator.Summary(entry.(Item))
+	}
+	for i := level; i >= 0; i-- {
+		path[i].count += count
+		if summary != nil {
+			path[i].summary = tr.merge(path[i].summary, summary)
+		}
+	}
+}
+
+// CountIn returns the number of items that intersect bbox. On a tree with
+// aggregates, the nodes that are inside of bbox are counted without
+// visiting their items.
+func (tr *RBush) CountIn(bbox Item) int {
+	var n int
+	tr.aggregate(bbox, func(node *treeNode) {
+		n += node.count
+	}, func(item Item) {
+		n++
+	})
+	return n
+}
+
+// Aggregate returns the merged summary of the items that intersect bbox, or
+// nil when there are none. The tree must have an Aggregator.
+func (tr *RBush) Aggregate(bbox Item) interface{} {
+	if tr.aggregator == nil {
+		panic("tree has no aggregator")
+	}
+	var summary interface{}
+	tr.aggregate(bbox, func(node *treeNode) {
+		if node.summary != nil {
+			summary = tr.merge(summary, node.summary)
+		}
+	}, func(item Item) {
+		summary = tr.merge(summary, tr.aggregator.Summary(item))
+	})
+	return summary
+}
+
+func (tr *RBush) aggregate(bbox Item, addNode func(node *treeNode), addItem func(item Item)) {
+	if bbox == nil {
+		panic("bbox is nil")
+	}
+	min, max := bbox.Rect()
+	if len(min) != len(max) || len(min) != tr.dims {
+		panic("bbox dimensions does not match tree dimensions")
+	}
+	box := treeNode{min: min, max: max}
+	if !tr.aggregates {
+		search(tr.data, &box, func(item Item) bool {
+			addItem(item)
+			return true
+		})
+		return
+	}
+	var visit func(node *treeNode)
+	visit = func(node *treeNode) {
+		if box.contains(node) {
+			addNode(node)
+			return
+		}
+		for _, ptr := range node.children {
+			if node.leaf {
+				item := ptr.(Item)
+				var child treeNode
+				fillBBox(item, &child)
+				if box.intersects(&child) {
+					addItem(item)
+				}
+			} else if child := ptr.(*treeNode); box.intersects(child) {
+				visit(child)
+			}
+		}
+	}
+	if len(tr.data.children) > 0 && box.intersects(tr.data) {
+		visit(tr.data)
+	}
+}
@@ -0,0 +1,99 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+// weightSummary is the total and the largest weight of some items.
+type weightSummary struct {
+	total, max uint64
+}
+
+type weightAggregator struct{}
+
+func (weightAggregator) Summary(item rbush.Item) interface{} {
+	id := item.(*idRect).id
+	return weightSummary{id, id}
+}
+
+func (weightAggregator) Merge(a, b interface{}) interface{} {
+	x, y := a.(weightSummary), b.(weightSummary)
+	if y.max > x.max {
+		x.max = y.max
+	}
+	x.total += y.total
+	return x
+}
+
+func TestAggregate(t *testing.T) {
+	trees := map[string]*rbush.Options{
+		"default": {Aggregator: weightAggregator{}},
+		"rstar":   {Aggregator: weightAggregator{}, Insertion: rbush.InsertRStar},
+		"linear":  {Aggregator: weightAggregator{}, Split: rbush.SplitLinear},
+		"hilbert": {
+			Aggregator: weightAggregator{},
+			Hilbert:    true,
+			HilbertMin: []float64{-50, -50},
+			HilbertMax: []float64{50, 50},
+		},
+		"counts": {Aggregates: true},
+	}
+	for name, opts := range trees {
+		t.Run(name, func(t *testing.T) {
+			rand.Seed(time.Now().UnixNano())
+			var objs []rbush.Item
+			for i := 0; i < 5000; i++ {
+				min, max := makeRandom("rect", 2).Rect()
+				objs = append(objs, &idRect{min, max, uint64(rand.Intn(100))})
+			}
+			tr := rbush.NewPacked(2, objs[:2000], opts)
+			for _, obj := range objs[2000:] {
+				tr.Insert(obj)
+			}
+			for _, obj := range objs[:1000] {
+				tr.Remove(obj)
+			}
+			tr.RemoveWhere([]float64{10, 10}, []float64{20, 20}, nil)
+			tx := tr.Begin()
+			tx.Insert(&idRect{[]float64{1, 1}, []float64{2, 2}, 5})
+			tx.Commit()
+			live := itemSet(tr)
+			assert.Equal(t, len(live), tr.Count())
+
+			for i := 0; i < 100; i++ {
+				box := makeRandom("rect", 2)
+				if i%10 == 0 {
+					box = makeRect(-40, -40, 40, 40)
+				}
+				var expect weightSummary
+				var n int
+				for obj := range live {
+					if testIntersects(obj, box) {
+						id := obj.(*idRect).id
+						expect.total += id
+						if id > expect.max {
+							expect.max = id
+						}
+						n++
+					}
+				}
+				assert.Equal(t, n, tr.CountIn(box))
+				if opts.Aggregator == nil {
+					assert.Panics(t, func() { tr.Aggregate(box) })
+				} else if n == 0 {
+					assert.Nil(t, tr.Aggregate(box))
+				} else {
+					assert.Equal(t, expect, tr.Aggregate(box))
+				}
+			}
+		})
+	}
+	tr := rbush.New(2)
+	tr.Insert(makeRect(0, 0, 1, 1))
+	assert.Equal(t, 1, tr.CountIn(makeRect(0, 0, 5, 5)))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geojson"
+)
+
+// record is an item read from a data file.
+type record struct {
+	ID    string          `json:"id"`
+	Min   []float64       `json:"min"`
+	Max   []float64       `json:"max"`
+	Props json.RawMessage `json:"properties,omitempty"`
+}
+
+func (r *record) Rect() (min, max []float64) {
+	return r.Min, r.Max
+}
+
+// recordCodec stores records in snapshots as JSON.
+type recordCodec struct{}
+
+func (recordCodec) MarshalItem(item rbush.Item) ([]byte, error) {
+	return json.Marshal(item.(*record))
+}
+
+func (recordCodec) UnmarshalItem(data []byte) (rbush.Item, error) {
+	r := new(record)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	if len(r.Min) != len(r.Max) {
+		return nil, errors.New("invalid record")
+	}
+	return r, nil
+}
+
+// formatOf returns the format of a file from its extension.
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return "csv"
+	case ".geojson", ".json":
+		return "geojson"
+	case ".ndjson", ".jsonl", ".geojsonl":
+		return "ndjson"
+	}
+	return "snapshot"
+}
+
+// readRecords reads the items of a data file.
+func readRecords(r io.Reader, format string, dims int) ([]rbush.Item, error) {
+	switch format {
+	case "csv":
+		return readCSV(r, dims)
+	case "geojson":
+		return readGeoJSON(r, dims)
+	case "ndjson":
+		return readNDJSON(r, dims)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// readCSV reads rows of an id followed by a point, or by the min and max
+// corners of a box. A header row is skipped.
+func readCSV(r io.Reader, dims int) ([]rbush.Item, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	var items []rbush.Item
+	for line := 1; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) == 1 && row[0] == "" {
+			continue
+		}
+		coords, err := parseCoords(row[1:])
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rec := &record{ID: row[0]}
+		if rec.ID == "" {
+			rec.ID = strconv.Itoa(line)
+		}
+		switch len(coords) {
+		case dims:
+			rec.Min, rec.Max = coords, coords
+		case dims * 2:
+			rec.Min, rec.Max = coords[:dims], coords[dims:]
+		default:
+			return nil, fmt.Errorf("line %d: expected %d or %d coordinates, got %d",
+				line, dims, dims*2, len(coords))
+		}
+		if err := checkBox(rec.Min, rec.Max); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		items = append(items, rec)
+	}
+}
+
+// readNDJSON reads one object per line. An object is either a GeoJSON
+// feature, or has an id and a "point" or "min" and "max".
+func readNDJSON(r io.Reader, dims int) ([]rbush.Item, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	var items []rbush.Item
+	for line := 1; sc.Scan(); line++ {
+		data := strings.TrimSpace(sc.Text())
+		if data == "" {
+			continue
+		}
+		var obj struct {
+			Type     string          `json:"type"`
+			ID       json.RawMessage `json:"id"`
+			Point    []float64       `json:"point"`
+			Min      []float64       `json:"min"`
+			Max      []float64       `json:"max"`
+			Geometry json.RawMessage `json:"geometry"`
+			Props    json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal([]byte(data), &obj); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		rec := &record{ID: jsonID(obj.ID, line), Props: obj.Props}
+		switch {
+		case obj.Type == "Feature":
+			var err error
+			rec.Min, rec.Max, err = geometryBox(obj.Geometry, dims)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		case obj.Point != nil:
+			rec.Min, rec.Max = obj.Point, obj.Point
+		default:
+			rec.Min, rec.Max = obj.Min, obj.Max
+		}
+		if len(rec.Min) != dims || len(rec.Max) != dims {
+			return nil, fmt.Errorf("line %d: expected %d dimensions", line, dims)
+		}
+		if err := checkBox(rec.Min, rec.Max); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		items = append(items, rec)
+	}
+	return items, sc.Err()
+}
+
+// readGeoJSON reads a FeatureCollection, a Feature or a geometry. Each
+// feature is stored as the bounding box of its geometry.
+func readGeoJSON(r io.Reader, dims int) ([]rbush.Item, error) {
+	var items []rbush.Item
+	err := geojson.Decode(r, dims, func(f *geojson.Feature) error {
+		min, max := f.Rect()
+		if min == nil {
+			return fmt.Errorf("feature %d has no geometry", len(items))
+		}
+		items = append(items, &record{ID: jsonID(f.ID, len(items)+1), Min: min, Max: max,
+			Props: f.Properties})
+		return nil
+	})
+	return items, err
+}
+
+// jsonID returns a string or number id, or n when there is none.
+func jsonID(raw json.RawMessage, n int) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var num json.Number
+	if json.Unmarshal(raw, &num) == nil {
+		return num.String()
+	}
+	return strconv.Itoa(n)
+}
+
+// geometryBox returns the bounding box of a GeoJSON geometry.
+func geometryBox(raw json.RawMessage, dims int) (min, max []float64, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil, errors.New("feature has no geometry")
+	}
+	g, err := geojson.ParseGeometry(raw, dims)
+	if err != nil {
+		return nil, nil, err
+	}
+	if min, max = g.Rect(); min == nil {
+		return nil, nil, errors.New("geometry is empty")
+	}
+	return min, max, nil
+}
+
+// parseCoords parses numbers separated by commas or spaces.
+func parseCoords(fields []string) ([]float64, error) {
+	var coords []float64
+	for _, field := range fields {
+		for _, s := range strings.FieldsFunc(field, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, err
+			}
+			coords = append(coords, f)
+		}
+	}
+	return coords, nil
+}
+
+func checkBox(min, max []float64) error {
+	for i := range min {
+		if min[i] != min[i] || max[i] != max[i] {
+			return errors.New("coordinate is NaN")
+		}
+		if min[i] > max[i] {
+			return errors.New("min is greater than max")
+		}
+	}
+	return nil
+}
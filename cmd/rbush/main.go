@@ -0,0 +1,415 @@
+// Command rbush builds, inspects and queries R-tree indexes.
+//
+//	rbush build -o places.snap places.csv
+//	rbush search -index places.snap -box 0,0,10,10
+//	rbush knn -index places.snap -point 5,5 -k 3
+//	rbush radius -index places.snap -point 5,5 -r 2
+//	rbush stats -index places.snap
+//	rbush render -index places.snap -o places.png
+//
+// Data files are CSV, GeoJSON or NDJSON, chosen by extension or -format.
+// Any other file is read as a snapshot written by build. Queries that are
+// not given as flags are read from stdin, one per line.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/render"
+)
+
+const usage = `usage: rbush <command> [flags]
+
+commands:
+  build    load data files and write a snapshot
+  search   find the items that intersect a box
+  knn      find the items nearest to a point
+  radius   find the items within a distance of a point
+  stats    print the structure of a tree
+  render   draw a tree as SVG or PNG
+
+Run 'rbush <command> -h' for the flags of a command.
+`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, "rbush:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return flag.ErrHelp
+	}
+	c := &command{stdin: stdin}
+	c.flags = flag.NewFlagSet(args[0], flag.ContinueOnError)
+	c.flags.SetOutput(stderr)
+	out := bufio.NewWriter(stdout)
+	var err error
+	switch args[0] {
+	case "build":
+		err = c.build(args[1:], out)
+	case "search":
+		err = c.search(args[1:], out)
+	case "knn":
+		err = c.knn(args[1:], out)
+	case "radius":
+		err = c.radius(args[1:], out)
+	case "stats":
+		err = c.stats(args[1:], out)
+	case "render":
+		err = c.render(args[1:], out)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+type command struct {
+	flags *flag.FlagSet
+	stdin io.Reader
+	// options for reading data files
+	format     string
+	dims       int
+	maxEntries int
+}
+
+// dataFlags adds the flags for reading data files.
+func (c *command) dataFlags() {
+	c.flags.StringVar(&c.format, "format", "", "format of data files: csv, geojson, ndjson or snapshot (default by extension)")
+	c.flags.IntVar(&c.dims, "dims", 2, "dimensions of data files")
+	c.flags.IntVar(&c.maxEntries, "max-entries", 0, "maximum entries per node (default 9)")
+}
+
+func (c *command) options() *rbush.Options {
+	return &rbush.Options{MaxEntries: c.maxEntries}
+}
+
+// load reads a data file or a snapshot into a tree. The path "-" is stdin.
+func (c *command) load(path string) (*rbush.RBush, error) {
+	items, dims, err := c.read(path)
+	if err != nil {
+		return nil, err
+	}
+	return rbush.NewPacked(dims, items, c.options()), nil
+}
+
+// read returns the items of a data file or a snapshot, and their dimensions.
+func (c *command) read(path string) ([]rbush.Item, int, error) {
+	r := c.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	format := c.format
+	if format == "" {
+		format = formatOf(path)
+	}
+	if format != "snapshot" {
+		if c.dims <= 0 {
+			return nil, 0, errors.New("dims must be positive")
+		}
+		items, err := readRecords(bufio.NewReader(r), format, c.dims)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %v", path, err)
+		}
+		return items, c.dims, nil
+	}
+	tr, err := rbush.ReadSnapshot(r, recordCodec{}, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	var items []rbush.Item
+	tr.Scan(func(item rbush.Item) bool {
+		items = append(items, item)
+		return true
+	})
+	min, _ := tr.Bounds()
+	return items, len(min), nil
+}
+
+// index adds the -index flag, and parses the flags.
+func (c *command) index(args []string) (*rbush.RBush, error) {
+	path := c.flags.String("index", "", "snapshot or data file to query")
+	c.dataFlags()
+	if err := c.flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if *path == "" {
+		return nil, errors.New("missing -index")
+	}
+	return c.load(*path)
+}
+
+func (c *command) build(args []string, out io.Writer) error {
+	output := c.flags.String("o", "", "snapshot file to write (default stdout)")
+	c.dataFlags()
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	paths := c.flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var items []rbush.Item
+	dims := -1
+	for _, path := range paths {
+		more, n, err := c.read(path)
+		if err != nil {
+			return err
+		}
+		if dims != -1 && n != dims {
+			return fmt.Errorf("%s: has %d dimensions, expected %d", path, n, dims)
+		}
+		items, dims = append(items, more...), n
+	}
+	tr := rbush.NewPacked(dims, items, c.options())
+	if *output == "" {
+		return tr.WriteSnapshot(out, recordCodec{})
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := tr.WriteSnapshot(f, recordCodec{}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// queries calls fn with the query from a flag, or with each line of stdin.
+// The query number is -1 for a flag.
+func (c *command) queries(flagValue string, n int, fn func(q int, coords []float64) error) error {
+	parse := func(s string) ([]float64, error) {
+		coords, err := parseCoords([]string{s})
+		if err != nil {
+			return nil, err
+		}
+		if len(coords) != n {
+			return nil, fmt.Errorf("expected %d coordinates, got %d", n, len(coords))
+		}
+		return coords, nil
+	}
+	if flagValue != "" {
+		coords, err := parse(flagValue)
+		if err != nil {
+			return err
+		}
+		return fn(-1, coords)
+	}
+	sc := bufio.NewScanner(c.stdin)
+	for q := 0; sc.Scan(); q++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			q--
+			continue
+		}
+		coords, err := parse(line)
+		if err != nil {
+			return fmt.Errorf("query %d: %v", q+1, err)
+		}
+		if err := fn(q, coords); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// result is a line of query output.
+type result struct {
+	Query *int `json:"query,omitempty"`
+	*record
+	Dist *float64 `json:"dist,omitempty"`
+}
+
+func writeResult(out io.Writer, q int, item rbush.Item, dist float64) error {
+	res := result{record: item.(*record)}
+	if q >= 0 {
+		res.Query = &q
+	}
+	if dist >= 0 {
+		res.Dist = &dist
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", data)
+	return err
+}
+
+func (c *command) search(args []string, out io.Writer) error {
+	box := c.flags.String("box", "", "query box as min then max coordinates, such as 0,0,10,10")
+	tr, err := c.index(args)
+	if err != nil {
+		return err
+	}
+	dims := treeDims(tr)
+	return c.queries(*box, dims*2, func(q int, coords []float64) error {
+		query := &record{Min: coords[:dims], Max: coords[dims:]}
+		if err := checkBox(query.Min, query.Max); err != nil {
+			return err
+		}
+		var err error
+		tr.Search(query, func(item rbush.Item) bool {
+			err = writeResult(out, q, item, -1)
+			return err == nil
+		})
+		return err
+	})
+}
+
+func (c *command) knn(args []string, out io.Writer) error {
+	point := c.flags.String("point", "", "query point, such as 5,5")
+	k := c.flags.Int("k", 10, "number of items")
+	tr, err := c.index(args)
+	if err != nil {
+		return err
+	}
+	return c.queries(*point, treeDims(tr), func(q int, coords []float64) error {
+		var err error
+		n := 0
+		tr.KNN(coords, func(item rbush.Item, dist float64) bool {
+			if n == *k {
+				return false
+			}
+			n++
+			err = writeResult(out, q, item, math.Sqrt(dist))
+			return err == nil
+		})
+		return err
+	})
+}
+
+func (c *command) radius(args []string, out io.Writer) error {
+	point := c.flags.String("point", "", "query point, such as 5,5")
+	r := c.flags.Float64("r", 0, "distance from the point")
+	tr, err := c.index(args)
+	if err != nil {
+		return err
+	}
+	return c.queries(*point, treeDims(tr), func(q int, coords []float64) error {
+		var err error
+		tr.KNN(coords, func(item rbush.Item, dist float64) bool {
+			if dist > *r**r {
+				return false
+			}
+			err = writeResult(out, q, item, math.Sqrt(dist))
+			return err == nil
+		})
+		return err
+	})
+}
+
+func (c *command) stats(args []string, out io.Writer) error {
+	tr, err := c.index(args)
+	if err != nil {
+		return err
+	}
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+	stats := tr.Stats()
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "dims\t%d\t\n", treeDims(tr))
+	fmt.Fprintf(tw, "height\t%d\t\n", stats.Height)
+	fmt.Fprintf(tw, "nodes\t%d\t\n", stats.Nodes)
+	fmt.Fprintf(tw, "items\t%d\t\n", stats.Items)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "height\tnodes\tentries\tarea\toverlap\tdead space\t")
+	for _, level := range stats.Levels {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.6g\t%.6g\t%.6g\t\n", level.Height, level.Nodes,
+			level.Entries, level.Area, level.Overlap, level.DeadSpace)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "leaf items\tleaves\t")
+	for n, leaves := range stats.LeafFill {
+		if leaves > 0 {
+			fmt.Fprintf(tw, "%d\t%d\t\n", n, leaves)
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *command) render(args []string, out io.Writer) error {
+	output := c.flags.String("o", "", "image file to write, .svg or .png (default SVG to stdout)")
+	width := c.flags.Int("width", 1000, "image width")
+	height := c.flags.Int("height", 1000, "image height")
+	x := c.flags.Int("x", 0, "axis drawn horizontally")
+	y := c.flags.Int("y", 1, "axis drawn vertically")
+	box := c.flags.String("box", "", "query box to draw over the tree")
+	point := c.flags.String("point", "", "point to draw over the tree, with its -k nearest items")
+	k := c.flags.Int("k", 10, "number of nearest items to draw")
+	tr, err := c.index(args)
+	if err != nil {
+		return err
+	}
+	dims := treeDims(tr)
+	if dims > 1 && (*x < 0 || *x >= dims || *y < 0 || *y >= dims || *x == *y) {
+		return errors.New("invalid axes")
+	}
+	d := render.New(tr, &render.Options{Width: *width, Height: *height, X: *x, Y: *y})
+	if *box != "" {
+		coords, err := parseCoords([]string{*box})
+		if err != nil || len(coords) != dims*2 {
+			return errors.New("invalid -box")
+		}
+		d.Query(coords[:dims], coords[dims:])
+	}
+	if *point != "" {
+		coords, err := parseCoords([]string{*point})
+		if err != nil || len(coords) != dims {
+			return errors.New("invalid -point")
+		}
+		d.KNN(coords, *k)
+	}
+	if *output == "" {
+		return d.WriteSVG(out)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(*output)) == ".png" {
+		err = d.WritePNG(f)
+	} else {
+		err = d.WriteSVG(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func treeDims(tr *rbush.RBush) int {
+	min, _ := tr.Bounds()
+	return len(min)
+}
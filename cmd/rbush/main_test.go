@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cli runs the command and returns its output.
+func cli(t *testing.T, stdin string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+// output is a decoded line of query output.
+type output struct {
+	Query    *int
+	ID       string
+	Min, Max []float64
+	Props    json.RawMessage `json:"properties"`
+	Dist     *float64
+}
+
+// results decodes NDJSON output.
+func results(t *testing.T, out string) []output {
+	var res []output
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		var r output
+		assert.NoError(t, json.Unmarshal([]byte(line), &r))
+		res = append(res, r)
+	}
+	return res
+}
+
+func ids(res []output) []string {
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(data), 0666))
+	return path
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := writeFile(t, dir, "places.csv", `id,x,y
+a,0,0
+b,1,1
+c,5,5
+d,10,10
+`)
+	boxesPath := writeFile(t, dir, "boxes.csv", `e,2,2,3,3
+f,-4,-4,-3,-3
+`)
+	snap := filepath.Join(dir, "places.snap")
+	_, err := cli(t, "", "build", "-o", snap, csvPath, boxesPath)
+	assert.NoError(t, err)
+
+	for _, index := range []string{snap, csvPath} {
+		out, err := cli(t, "", "search", "-index", index, "-box", "0,0,1.5,1.5")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"a", "b"}, ids(results(t, out)))
+	}
+	out, err := cli(t, "", "search", "-index", snap, "-box", "2.5,2.5,6,6")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"c", "e"}, ids(results(t, out)))
+
+	out, err = cli(t, "", "knn", "-index", snap, "-point", "5,5", "-k", "2")
+	assert.NoError(t, err)
+	res := results(t, out)
+	assert.Equal(t, []string{"c", "e"}, ids(res))
+	assert.Equal(t, 0.0, *res[0].Dist)
+	assert.InDelta(t, 2.828, *res[1].Dist, 0.001)
+
+	out, err = cli(t, "", "radius", "-index", snap, "-point", "0,0", "-r", "1.5")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, ids(results(t, out)))
+
+	// queries from stdin are numbered
+	out, err = cli(t, "0 0\n\n10,10\n", "knn", "-index", snap, "-k", "1")
+	assert.NoError(t, err)
+	res = results(t, out)
+	assert.Equal(t, []string{"a", "d"}, ids(res))
+	assert.Equal(t, 0, *res[0].Query)
+	assert.Equal(t, 1, *res[1].Query)
+
+	out, err = cli(t, "", "stats", "-index", snap)
+	assert.NoError(t, err)
+	assert.Contains(t, out, "items")
+	assert.Contains(t, out, "6")
+
+	out, err = cli(t, "", "render", "-index", snap, "-box", "0,0,2,2", "-point", "5,5")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "<svg"))
+	png := filepath.Join(dir, "places.png")
+	_, err = cli(t, "", "render", "-index", snap, "-o", png, "-width", "100", "-height", "100")
+	assert.NoError(t, err)
+	data, err := os.ReadFile(png)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("\x89PNG")))
+
+	_, err = cli(t, "", "search", "-index", snap, "-box", "1,2,3")
+	assert.Error(t, err)
+	_, err = cli(t, "", "search", "-box", "0,0,1,1")
+	assert.Error(t, err)
+	_, err = cli(t, "", "nope")
+	assert.Error(t, err)
+	_, err = cli(t, "", "stats", "-index", csvPath, "-dims", "3")
+	assert.Error(t, err)
+}
+
+func TestFormats(t *testing.T) {
+	dir := t.TempDir()
+	geojson := writeFile(t, dir, "shapes.geojson", `{
+	"type": "FeatureCollection",
+	"features": [
+		{"type": "Feature", "id": "pt", "properties": {"name": "point"},
+			"geometry": {"type": "Point", "coordinates": [1, 2]}},
+		{"type": "Feature", "id": 7,
+			"geometry": {"type": "Polygon", "coordinates": [[[0, 0], [4, 0], [4, 3], [0, 0]]]}},
+		{"type": "Feature",
+			"geometry": {"type": "GeometryCollection", "geometries": [
+				{"type": "Point", "coordinates": [10, 10, 5]},
+				{"type": "LineString", "coordinates": [[11, 9], [12, 12]]}
+			]}}
+	]
+}`)
+	ndjson := writeFile(t, dir, "shapes.ndjson", `{"id": "p", "point": [1, 2]}
+{"id": "b", "min": [0, 0], "max": [4, 3]}
+{"type": "Feature", "id": "f", "geometry": {"type": "MultiPoint", "coordinates": [[10, 10], [11, 9], [12, 12]]}}
+`)
+	for _, path := range []string{geojson, ndjson} {
+		out, err := cli(t, "", "search", "-index", path, "-box", "-100,-100,100,100")
+		assert.NoError(t, err)
+		res := results(t, out)
+		assert.Equal(t, 3, len(res))
+		boxes := make(map[string][2][]float64)
+		for _, r := range res {
+			boxes[string(r.Props)] = [2][]float64{r.Min, r.Max}
+		}
+		if path == geojson {
+			assert.ElementsMatch(t, []string{"pt", "7", "3"}, ids(res))
+			assert.Equal(t, [2][]float64{{1, 2}, {1, 2}}, boxes[`{"name":"point"}`])
+		}
+		out, err = cli(t, "", "search", "-index", path, "-box", "11,11,11,11")
+		assert.NoError(t, err)
+		res = results(t, out)
+		assert.Equal(t, 1, len(res))
+		assert.Equal(t, []float64{10, 9}, res[0].Min)
+		assert.Equal(t, []float64{12, 12}, res[0].Max)
+	}
+
+	// the format flag overrides the extension, and stdin is "-"
+	out, err := cli(t, "a,1,2,3\n", "search", "-index", "-", "-format", "csv",
+		"-dims", "3", "-box", "0,0,0,5,5,5")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, ids(results(t, out)))
+
+	bad := map[string]string{
+		"bad.csv":     "a,1,2\nb,1\n",
+		"nan.csv":     "a,NaN,1\n",
+		"bad.ndjson":  `{"id": "a", "point": [1]}`,
+		"bad.geojson": `{"type": "Point", "coordinates": [1]}`,
+		"bad.snap":    "RBSNAP01",
+	}
+	for name, data := range bad {
+		_, err := cli(t, "", "stats", "-index", writeFile(t, dir, name, data))
+		assert.Error(t, err, name)
+	}
+}
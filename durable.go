@@ -0,0 +1,491 @@
+package rbush
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DurableOptions for OpenDurable.
+type DurableOptions struct {
+	// Options for the tree.
+	Options *Options
+	// Codec converts items to and from bytes. Required.
+	Codec ItemCodec
+	// SyncEvery is the number of operations that are batched in the log
+	// before it is synced to disk. Default is 1, which syncs every operation.
+	SyncEvery int
+	// CheckpointEvery is the number of operations after which a checkpoint
+	// is taken. Zero only takes a checkpoint when Checkpoint is called.
+	CheckpointEvery int
+}
+
+// Durable is an RBush that appends every Insert and Remove to a write-ahead
+// log. An operation is committed once its batch is synced to disk, with a
+// commit record after it. A checkpoint writes a snapshot of the whole tree
+// and starts a new log. When opened after a crash, the committed operations
+// of the log are replayed onto the last checkpoint.
+//
+// Items that are read back from disk are new values, so Remove matches items
+// by their encoded bytes.
+type Durable struct {
+	tr     *RBush
+	opts   DurableOptions
+	dir    string
+	wal    *os.File
+	w      *bufio.Writer
+	seq    uint64 // sequence of the last operation
+	synced uint64 // sequence of the last synced operation
+	ops    int    // operations since the last checkpoint
+	items  encodedItems
+	err    error
+}
+
+const (
+	walInsert = 1
+	walRemove = 2
+	walCommit = 4 // the operations up to the sequence are synced
+)
+
+const walHeaderSize = 17 // crc, size, seq, op
+
+// OpenDurable opens the durable tree in dir, creating it when needed.
+func OpenDurable(dir string, dims int, opts *DurableOptions) (*Durable, error) {
+	if opts == nil || opts.Codec == nil {
+		return nil, errors.New("codec is required")
+	}
+	d := &Durable{
+		opts: *opts,
+		dir:  dir,
+	}
+	if d.opts.SyncEvery <= 0 {
+		d.opts.SyncEvery = 1
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "snapshot"))
+	if err == nil {
+		d.tr, d.seq, err = readSnapshot(f, opts.Codec, opts.Options)
+		f.Close()
+		if err == nil && d.tr.dims != dims {
+			err = errors.New("snapshot dimensions does not match tree dimensions")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if d.items, err = indexEncoded(d.tr, opts.Codec); err != nil {
+			return nil, err
+		}
+	} else if os.IsNotExist(err) {
+		d.tr = NewOptions(dims, opts.Options)
+		d.items = make(encodedItems)
+	} else {
+		return nil, err
+	}
+	if err := d.replay(); err != nil {
+		return nil, err
+	}
+	d.synced = d.seq
+	return d, nil
+}
+
+// replay applies the committed operations of the log onto the tree. The
+// records after the last commit record are from a batch that was never
+// synced, and may be torn or hold garbage, so they are cut off. An invalid
+// record before a commit record fails with ErrCorruptLog, leaving the log
+// as it is.
+func (d *Durable) replay() error {
+	wal, err := os.OpenFile(filepath.Join(d.dir, "wal"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(wal)
+	var offset, committed int64
+	var pending []logRecord
+	for {
+		seq, op, data, err := readRecord(r)
+		if err == errInvalidRecord {
+			corrupt, err := commitAfter(wal, offset, d.seq)
+			if err == nil && corrupt {
+				err = ErrCorruptLog
+			}
+			if err != nil {
+				wal.Close()
+				return err
+			}
+			break
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			wal.Close()
+			return err
+		}
+		offset += int64(walHeaderSize + len(data))
+		if op != walCommit {
+			pending = append(pending, logRecord{seq, op, data})
+			continue
+		}
+		for _, rec := range pending {
+			if rec.seq > d.seq {
+				if err := d.apply(rec.op, rec.data); err != nil {
+					wal.Close()
+					return err
+				}
+				d.seq = rec.seq
+			}
+		}
+		pending = pending[:0]
+		committed = offset
+	}
+	if err := wal.Truncate(committed); err != nil {
+		wal.Close()
+		return err
+	}
+	if _, err := wal.Seek(committed, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	d.wal = wal
+	d.w = bufio.NewWriter(wal)
+	return nil
+}
+
+// commitAfter reports whether the log has a commit record for an operation
+// after seq anywhere past the invalid record at offset, which means that
+// the invalid record was committed. The record sizes after an invalid
+// record can not be trusted, so every offset is tried.
+func commitAfter(wal *os.File, offset int64, seq uint64) (bool, error) {
+	fi, err := wal.Stat()
+	if err != nil {
+		return false, err
+	}
+	rest, err := io.ReadAll(io.NewSectionReader(wal, offset+1, fi.Size()-offset-1))
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i+walHeaderSize <= len(rest); i++ {
+		h := rest[i : i+walHeaderSize]
+		if h[16] != walCommit || binary.LittleEndian.Uint32(h[4:]) != 0 ||
+			binary.LittleEndian.Uint64(h[8:]) <= seq {
+			continue
+		}
+		if crc32.ChecksumIEEE(h[8:]) == binary.LittleEndian.Uint32(h) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (d *Durable) apply(op byte, data []byte) error {
+	return applyRecord(d.tr, d.items, d.opts.Codec, op, data)
+}
+
+// applyRecord applies an insert or a removal of an encoded item onto a tree
+// and its encoded items.
+func applyRecord(tr *RBush, items encodedItems, codec ItemCodec, op byte, data []byte) error {
+	switch op {
+	case walInsert:
+		item, err := codec.UnmarshalItem(data)
+		if err != nil {
+			return err
+		}
+		tr.Insert(item)
+		items.add(string(data), item)
+	case walRemove:
+		if item := items.take(string(data), nil); item != nil {
+			tr.Remove(item)
+		}
+	default:
+		return errInvalidRecord
+	}
+	return nil
+}
+
+// remove takes an item with the encoded key out of the tree, preferring the
+// provided item.
+func (d *Durable) remove(key string, item Item) bool {
+	item = d.items.take(key, item)
+	if item == nil {
+		return false
+	}
+	d.tr.Remove(item)
+	return true
+}
+
+// encodedItems finds the items of a tree by their encoded bytes.
+type encodedItems map[string][]Item
+
+// indexEncoded returns the encoded items of a tree.
+func indexEncoded(tr *RBush, codec ItemCodec) (encodedItems, error) {
+	items := make(encodedItems)
+	var err error
+	tr.Scan(func(item Item) bool {
+		var data []byte
+		data, err = codec.MarshalItem(item)
+		items.add(string(data), item)
+		return err == nil
+	})
+	return items, err
+}
+
+func (m encodedItems) add(key string, item Item) {
+	m[key] = append(m[key], item)
+}
+
+// take removes an item with the key, preferring the provided item, and
+// returns it. It returns nil when there is no item with the key.
+func (m encodedItems) take(key string, item Item) Item {
+	items := m[key]
+	if len(items) == 0 {
+		return nil
+	}
+	i := len(items) - 1
+	for j := range items {
+		if items[j] == item {
+			i = j
+			break
+		}
+	}
+	item = items[i]
+	items[i] = items[len(items)-1]
+	items[len(items)-1] = nil
+	if len(items) == 1 {
+		delete(m, key)
+	} else {
+		m[key] = items[:len(items)-1]
+	}
+	return item
+}
+
+var errInvalidRecord = errors.New("invalid log record")
+
+// ErrCorruptLog is returned by OpenDurable when a committed record of the
+// write-ahead log is corrupt.
+var ErrCorruptLog = errors.New("corrupt write-ahead log")
+
+// writeRecord writes a log record, which is a header of the checksum, the
+// size of the data, the sequence and the operation, followed by the data.
+func writeRecord(w io.Writer, seq uint64, op byte, data []byte) error {
+	var header [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint64(header[8:], seq)
+	header[16] = op
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(data)
+	binary.LittleEndian.PutUint32(header[:], crc.Sum32())
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readRecord reads a log record. It returns io.EOF when there are no more
+// records, and errInvalidRecord for a torn or corrupt record.
+func readRecord(r io.Reader) (seq uint64, op byte, data []byte, err error) {
+	var header [walHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errInvalidRecord
+		}
+		return 0, 0, nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[4:])
+	if size > maxEncodedItem {
+		return 0, 0, nil, errInvalidRecord
+	}
+	data = make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errInvalidRecord
+		}
+		return 0, 0, nil, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(data)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[:]) {
+		return 0, 0, nil, errInvalidRecord
+	}
+	return binary.LittleEndian.Uint64(header[8:]), header[16], data, nil
+}
+
+func (d *Durable) log(op byte, data []byte) error {
+	d.seq++
+	if err := writeRecord(d.w, d.seq, op, data); err != nil {
+		d.err = err
+		return err
+	}
+	d.ops++
+	if d.seq-d.synced >= uint64(d.opts.SyncEvery) {
+		if err := d.Sync(); err != nil {
+			return err
+		}
+	}
+	if d.opts.CheckpointEvery > 0 && d.ops >= d.opts.CheckpointEvery {
+		return d.Checkpoint()
+	}
+	return nil
+}
+
+// Insert adds the item to the tree and the log.
+func (d *Durable) Insert(item Item) error {
+	if d.err != nil {
+		return d.err
+	}
+	data, err := d.opts.Codec.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxEncodedItem {
+		return errors.New("encoded item is too large")
+	}
+	d.tr.Insert(item)
+	d.items.add(string(data), item)
+	return d.log(walInsert, data)
+}
+
+// Remove removes an item with the same encoded bytes from the tree, and
+// adds the removal to the log.
+func (d *Durable) Remove(item Item) error {
+	if d.err != nil {
+		return d.err
+	}
+	data, err := d.opts.Codec.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+	if !d.remove(string(data), item) {
+		return nil
+	}
+	return d.log(walRemove, data)
+}
+
+// Sync commits all logged operations by syncing the log to disk.
+func (d *Durable) Sync() error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.synced == d.seq {
+		return nil
+	}
+	if err := writeRecord(d.w, d.seq, walCommit, nil); err != nil {
+		d.err = err
+		return err
+	}
+	if err := d.w.Flush(); err != nil {
+		d.err = err
+		return err
+	}
+	if err := d.wal.Sync(); err != nil {
+		d.err = err
+		return err
+	}
+	d.synced = d.seq
+	return nil
+}
+
+// Checkpoint writes a snapshot of the tree and starts a new log.
+func (d *Durable) Checkpoint() error {
+	if err := d.Sync(); err != nil {
+		return err
+	}
+	if err := d.checkpoint(); err != nil {
+		d.err = err
+		return err
+	}
+	d.ops = 0
+	return nil
+}
+
+func (d *Durable) checkpoint() error {
+	path := filepath.Join(d.dir, "snapshot")
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := d.tr.writeSnapshot(f, d.opts.Codec, d.seq); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := syncDir(d.dir); err != nil {
+		return err
+	}
+	// records up to the snapshot sequence are skipped when replaying, so a
+	// crash before the log is cleared is harmless
+	if err := d.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d.w.Reset(d.wal)
+	return d.wal.Sync()
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Close syncs the log and closes it.
+func (d *Durable) Close() error {
+	err := d.Sync()
+	if cerr := d.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Seq returns the sequence number of the last operation.
+func (d *Durable) Seq() uint64 {
+	return d.seq
+}
+
+// Synced returns the sequence number of the last committed operation.
+func (d *Durable) Synced() uint64 {
+	return d.synced
+}
+
+func (d *Durable) Search(bbox Item, iter func(item Item) bool) bool {
+	return d.tr.Search(bbox, iter)
+}
+
+func (d *Durable) KNN(point []float64, iter func(item Item, dist float64) bool) bool {
+	return d.tr.KNN(point, iter)
+}
+
+func (d *Durable) Scan(iter func(item Item) bool) bool {
+	return d.tr.Scan(iter)
+}
+
+func (d *Durable) Count() int {
+	return d.tr.Count()
+}
+
+func (d *Durable) Bounds() (min, max []float64) {
+	return d.tr.Bounds()
+}
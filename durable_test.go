@@ -0,0 +1,298 @@
+package rbush_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+// idRectCodec encodes an idRect as its id followed by its box.
+type idRectCodec struct{}
+
+func (idRectCodec) MarshalItem(item rbush.Item) ([]byte, error) {
+	r := item.(*idRect)
+	data := make([]byte, 8+len(r.min)*16)
+	binary.LittleEndian.PutUint64(data, r.id)
+	for i := range r.min {
+		binary.LittleEndian.PutUint64(data[8+i*16:], math.Float64bits(r.min[i]))
+		binary.LittleEndian.PutUint64(data[16+i*16:], math.Float64bits(r.max[i]))
+	}
+	return data, nil
+}
+
+func (idRectCodec) UnmarshalItem(data []byte) (rbush.Item, error) {
+	if len(data) < 8 || (len(data)-8)%16 != 0 {
+		return nil, errors.New("invalid item")
+	}
+	dims := (len(data) - 8) / 16
+	r := &idRect{
+		min: make([]float64, dims),
+		max: make([]float64, dims),
+		id:  binary.LittleEndian.Uint64(data),
+	}
+	for i := 0; i < dims; i++ {
+		r.min[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8+i*16:]))
+		r.max[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[16+i*16:]))
+	}
+	return r, nil
+}
+
+func scanIDs(tr interface {
+	Scan(iter func(item rbush.Item) bool) bool
+}) []int {
+	var ids []int
+	tr.Scan(func(item rbush.Item) bool {
+		ids = append(ids, int(item.(*idRect).id))
+		return true
+	})
+	sort.Ints(ids)
+	return ids
+}
+
+func TestSnapshot(t *testing.T) {
+	tr := rbush.New(2)
+	var ids []int
+	for i := 0; i < 10000; i++ {
+		min, max := makeRandom("rect", 2).Rect()
+		tr.Insert(&idRect{min, max, uint64(i)})
+		ids = append(ids, i)
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, tr.WriteSnapshot(&buf, idRectCodec{}))
+	tr2, err := rbush.ReadSnapshot(bytes.NewReader(buf.Bytes()), idRectCodec{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ids, scanIDs(tr2))
+	min1, max1 := tr.Bounds()
+	min2, max2 := tr2.Bounds()
+	assert.Equal(t, min1, min2)
+	assert.Equal(t, max1, max2)
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xFF
+	_, err = rbush.ReadSnapshot(bytes.NewReader(data), idRectCodec{}, nil)
+	assert.Equal(t, rbush.ErrInvalidSnapshot, err)
+	_, err = rbush.ReadSnapshot(bytes.NewReader(data[:100]), idRectCodec{}, nil)
+	assert.Equal(t, rbush.ErrInvalidSnapshot, err)
+}
+
+// durableOps returns the operations of a deterministic workload, which
+// mostly inserts new items and sometimes removes an earlier one.
+func durableOps(n int) (ops []*idRect, removes []bool) {
+	rng := rand.New(rand.NewSource(42))
+	var live []*idRect
+	for i := 0; i < n; i++ {
+		if len(live) > 0 && rng.Intn(10) < 3 {
+			j := rng.Intn(len(live))
+			ops = append(ops, live[j])
+			removes = append(removes, true)
+			live[j] = live[len(live)-1]
+			live = live[:len(live)-1]
+			continue
+		}
+		x, y := rng.Float64()*100, rng.Float64()*100
+		r := &idRect{[]float64{x, y}, []float64{x + 1, y + 1}, uint64(i)}
+		live = append(live, r)
+		ops = append(ops, r)
+		removes = append(removes, false)
+	}
+	return ops, removes
+}
+
+// durableExpect returns the sorted ids that remain after the first n ops.
+func durableExpect(ops []*idRect, removes []bool, n int) []int {
+	live := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		if removes[i] {
+			delete(live, int(ops[i].id))
+		} else {
+			live[int(ops[i].id)] = true
+		}
+	}
+	var ids []int
+	for id := range live {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestDurable(t *testing.T) {
+	dir := t.TempDir()
+	opts := &rbush.DurableOptions{Codec: idRectCodec{}, SyncEvery: 10}
+	d, err := rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	ops, removes := durableOps(1000)
+	apply := func(d *rbush.Durable, ops []*idRect, removes []bool) {
+		for i, op := range ops {
+			if removes[i] {
+				assert.NoError(t, d.Remove(op))
+			} else {
+				assert.NoError(t, d.Insert(op))
+			}
+		}
+	}
+	apply(d, ops[:500], removes[:500])
+	assert.NoError(t, d.Checkpoint())
+	apply(d, ops[500:995], removes[500:995])
+	assert.Equal(t, uint64(990), d.Synced())
+	// crash with half a batch, and a torn record, in the log
+	assert.NoError(t, d.Sync())
+	apply(d, ops[995:], removes[995:])
+	f, err := os.OpenFile(filepath.Join(dir, "wal"), os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	f.Write([]byte{1, 2, 3, 4, 5, 6, 7})
+	f.Close()
+
+	d, err = rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(995), d.Seq())
+	assert.Equal(t, durableExpect(ops, removes, 995), scanIDs(d))
+
+	// the torn record was cut off, so the log can grow again
+	apply(d, ops[995:], removes[995:])
+	assert.NoError(t, d.Close())
+	d, err = rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, durableExpect(ops, removes, 1000), scanIDs(d))
+	assert.NoError(t, d.Close())
+}
+
+func TestDurableCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	opts := &rbush.DurableOptions{Codec: idRectCodec{}}
+	d, err := rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	ops, _ := durableOps(10)
+	for _, op := range ops {
+		assert.NoError(t, d.Insert(op))
+	}
+	assert.NoError(t, d.Close())
+
+	// a bad record with records after it is not cut off
+	path := filepath.Join(dir, "wal")
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+	_, err = rbush.OpenDurable(dir, 2, opts)
+	assert.Equal(t, rbush.ErrCorruptLog, err)
+	fi, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), fi.Size())
+
+	// a bad last record is torn, and cut off
+	data[len(data)/2] ^= 0xff
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+	d, err = rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(9), d.Seq())
+	assert.NoError(t, d.Close())
+
+	// a batch that was not synced may have garbage before a valid record,
+	// and is cut off
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	size := int64(len(data))
+	item, _ := idRectCodec{}.MarshalItem(ops[0])
+	record := make([]byte, 17+len(item))
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(item)))
+	binary.LittleEndian.PutUint64(record[8:], 10)
+	record[16] = 1
+	copy(record[17:], item)
+	binary.LittleEndian.PutUint32(record, crc32.ChecksumIEEE(record[8:]))
+	data = append(append(data, make([]byte, 4096)...), record...)
+	assert.NoError(t, os.WriteFile(path, data, 0666))
+	d, err = rbush.OpenDurable(dir, 2, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(9), d.Seq())
+	assert.NoError(t, d.Close())
+	fi, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, size, fi.Size())
+}
+
+// TestDurableWriter is the writer process for TestDurableCrash. It reports
+// the sequence number of every committed batch on stdout.
+func TestDurableWriter(t *testing.T) {
+	dir := os.Getenv("RBUSH_DURABLE_DIR")
+	if dir == "" {
+		t.Skip("only runs as a TestDurableCrash writer")
+	}
+	d, err := rbush.OpenDurable(dir, 2, &rbush.DurableOptions{
+		Codec:           idRectCodec{},
+		SyncEvery:       25,
+		CheckpointEvery: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, removes := durableOps(100000)
+	for i, op := range ops {
+		if removes[i] {
+			err = d.Remove(op)
+		} else {
+			err = d.Insert(op)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (i+1)%25 == 0 {
+			fmt.Printf("committed %d\n", d.Synced())
+		}
+	}
+}
+
+func TestDurableCrash(t *testing.T) {
+	if os.Getenv("RBUSH_DURABLE_DIR") != "" {
+		return
+	}
+	ops, removes := durableOps(100000)
+	dir := t.TempDir()
+	for round := 0; round < 3; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDurableWriter$")
+		cmd.Env = append(os.Environ(), "RBUSH_DURABLE_DIR="+dir)
+		out, err := cmd.StdoutPipe()
+		assert.NoError(t, err)
+		assert.NoError(t, cmd.Start())
+		// kill the writer at some point in the middle of a batch
+		stop := uint64(rand.Intn(5000) + 2000*(round+1))
+		var committed uint64
+		s := bufio.NewScanner(out)
+		for s.Scan() {
+			var n uint64
+			if _, err := fmt.Sscanf(s.Text(), "committed %d", &n); err == nil {
+				committed = n
+				if committed >= stop {
+					break
+				}
+			}
+		}
+		assert.NoError(t, cmd.Process.Kill())
+		cmd.Wait()
+		assert.True(t, committed >= stop, "writer stopped at "+
+			strconv.FormatUint(committed, 10))
+
+		d, err := rbush.OpenDurable(dir, 2, &rbush.DurableOptions{Codec: idRectCodec{}})
+		assert.NoError(t, err)
+		assert.True(t, d.Seq() >= committed)
+		assert.Equal(t, durableExpect(ops, removes, int(d.Seq())), scanIDs(d))
+		assert.NoError(t, d.Close())
+		// the next writer starts over in an empty directory
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,227 @@
+package rbush_test
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/tidwall/rbush"
+)
+
+// fuzzReader decodes a fuzz input. It reads zeros once the input runs out.
+type fuzzReader struct {
+	data []byte
+}
+
+func (r *fuzzReader) done() bool {
+	return len(r.data) == 0
+}
+
+func (r *fuzzReader) byte() byte {
+	if len(r.data) == 0 {
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+// coord returns a value on a coarse grid, so that boxes often touch, share
+// edges, have no area or are duplicates. A few values are not finite.
+func (r *fuzzReader) coord() float64 {
+	switch b := r.byte(); b {
+	case 0xff:
+		return math.NaN()
+	case 0xfe:
+		return math.Inf(+1)
+	case 0xfd:
+		return math.Inf(-1)
+	default:
+		return float64(int8(b)) / 4
+	}
+}
+
+func (r *fuzzReader) rect(dims int) *rect {
+	min, max := make([]float64, dims), make([]float64, dims)
+	for i := 0; i < dims; i++ {
+		min[i], max[i] = r.coord(), r.coord()
+		if min[i] > max[i] {
+			min[i], max[i] = max[i], min[i]
+		}
+	}
+	return &rect{min, max}
+}
+
+func fuzzHasNaN(r *rect) bool {
+	for i := range r.min {
+		if math.IsNaN(r.min[i]) || math.IsNaN(r.max[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func fuzzOptions(b byte, dims int) *rbush.Options {
+	opts := &rbush.Options{MaxEntries: 4 + int(b>>4)%6}
+	switch b % 6 {
+	case 1:
+		opts.Insertion = rbush.InsertRStar
+	case 2:
+		opts.Split = rbush.SplitQuadratic
+	case 3:
+		opts.Split = rbush.SplitLinear
+	case 4:
+		opts.Aggregates = true
+	case 5:
+		if dims <= 3 {
+			opts.Hilbert = true
+			opts.HilbertMin = make([]float64, dims)
+			opts.HilbertMax = make([]float64, dims)
+			for i := 0; i < dims; i++ {
+				opts.HilbertMin[i], opts.HilbertMax[i] = -32, 32
+			}
+		}
+	}
+	return opts
+}
+
+// fuzzCounts counts the occurrences of each item.
+func fuzzCounts(items []rbush.Item) map[rbush.Item]int {
+	counts := make(map[rbush.Item]int)
+	for _, item := range items {
+		counts[item]++
+	}
+	return counts
+}
+
+func fuzzEqual(t *testing.T, op string, expect, got []rbush.Item) {
+	a, b := fuzzCounts(expect), fuzzCounts(got)
+	if len(a) != len(b) {
+		t.Fatalf("%s: expected %d items, got %d", op, len(expect), len(got))
+	}
+	for item, n := range a {
+		if b[item] != n {
+			t.Fatalf("%s: expected %v %d times, got %d", op, item, n, b[item])
+		}
+	}
+}
+
+// fuzzTree runs the operations in data on a tree and on a plain slice of
+// items, and checks that they agree.
+func fuzzTree(t *testing.T, data []byte) {
+	r := &fuzzReader{data: data}
+	dims := int(r.byte()%4) + 1
+	tr := rbush.NewOptions(dims, fuzzOptions(r.byte(), dims))
+	var live []rbush.Item
+	for !r.done() {
+		switch r.byte() % 8 {
+		case 0, 1, 2:
+			item := r.rect(dims)
+			if b := r.byte(); b%8 == 0 && len(live) > 0 {
+				// the same item again
+				item = live[int(b)%len(live)].(*rect)
+			}
+			if fuzzHasNaN(item) {
+				func() {
+					defer func() {
+						if recover() == nil {
+							t.Fatal("insert: accepted NaN coordinates")
+						}
+					}()
+					tr.Insert(item)
+				}()
+				break
+			}
+			tr.Insert(item)
+			live = append(live, item)
+		case 3, 4:
+			if len(live) == 0 {
+				tr.Remove(r.rect(dims))
+				break
+			}
+			i := int(r.byte()) % len(live)
+			tr.Remove(live[i])
+			live = append(live[:i], live[i+1:]...)
+		case 5:
+			box := r.rect(dims)
+			var got, expect []rbush.Item
+			tr.Search(box, func(item rbush.Item) bool {
+				got = append(got, item)
+				return true
+			})
+			for _, item := range live {
+				if testIntersects(item, box) {
+					expect = append(expect, item)
+				}
+			}
+			fuzzEqual(t, "search", expect, got)
+		case 6:
+			point := make([]float64, dims)
+			for i := range point {
+				point[i] = r.coord()
+			}
+			var dists, expect []float64
+			last := math.Inf(-1)
+			tr.KNN(point, func(item rbush.Item, dist float64) bool {
+				if dist < last {
+					t.Fatalf("knn: %v after %v", dist, last)
+				}
+				last = dist
+				dists = append(dists, dist)
+				return true
+			})
+			for _, item := range live {
+				min, max := item.Rect()
+				expect = append(expect, testBoxDist(point, min, max))
+			}
+			sort.Float64s(expect)
+			if len(dists) != len(expect) {
+				t.Fatalf("knn: expected %d items, got %d", len(expect), len(dists))
+			}
+			for i := range dists {
+				if dists[i] != expect[i] && !(dists[i] != dists[i] && expect[i] != expect[i]) {
+					t.Fatalf("knn: expected %v, got %v", expect, dists)
+				}
+			}
+		case 7:
+			box := r.rect(dims)
+			var remain []rbush.Item
+			for _, item := range live {
+				if !testIntersects(item, box) {
+					remain = append(remain, item)
+				}
+			}
+			n := tr.RemoveWhere(box.min, box.max, nil)
+			if n != len(live)-len(remain) {
+				t.Fatalf("remove where: expected %d, got %d", len(live)-len(remain), n)
+			}
+			live = remain
+		}
+		if err := tr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tr.Count() != len(live) {
+		t.Fatalf("expected %d items, got %d", len(live), tr.Count())
+	}
+	var got []rbush.Item
+	tr.Scan(func(item rbush.Item) bool {
+		got = append(got, item)
+		return true
+	})
+	fuzzEqual(t, "scan", live, got)
+}
+
+func FuzzRBush(f *testing.F) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 32; i++ {
+		data := make([]byte, 64+rng.Intn(2048))
+		for j := range data {
+			data[j] = byte(rng.Intn(32) - 16)
+		}
+		data[0], data[1] = byte(i), byte(i*7)
+		f.Add(data)
+	}
+	f.Fuzz(fuzzTree)
+}
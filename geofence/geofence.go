@@ -0,0 +1,254 @@
+// Package geofence reports when objects enter and exit registered regions.
+//
+// The regions, called fences, are kept in their own R-tree. When an object
+// moves its box probes the fence tree, and the fences it now intersects are
+// compared with the fences it intersected before.
+package geofence
+
+import (
+	"sort"
+
+	"github.com/tidwall/rbush"
+)
+
+// Kind of an Event.
+type Kind int
+
+const (
+	// Enter is reported when an object starts intersecting a fence.
+	Enter Kind = iota + 1
+	// Exit is reported when an object stops intersecting a fence.
+	Exit
+	// Inside is reported when an object that intersected a fence moved,
+	// and still intersects it.
+	Inside
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Enter:
+		return "enter"
+	case Exit:
+		return "exit"
+	case Inside:
+		return "inside"
+	}
+	return "unknown"
+}
+
+// Event is the relation of an object and a fence after a change.
+type Event struct {
+	Kind   Kind
+	Object string
+	Fence  string
+}
+
+type fence struct {
+	id       string
+	min, max []float64
+}
+
+func (f *fence) Rect() (min, max []float64) {
+	return f.min, f.max
+}
+
+type object struct {
+	id       string
+	min, max []float64
+	fences   map[string]bool
+}
+
+func (o *object) Rect() (min, max []float64) {
+	return o.min, o.max
+}
+
+// Engine holds the fences and the objects.
+type Engine struct {
+	dims       int
+	fences     *rbush.RBush
+	objects    *rbush.RBush
+	fenceByID  map[string]*fence
+	objectByID map[string]*object
+}
+
+// New returns an engine for boxes with dims dimensions.
+func New(dims int) *Engine {
+	return &Engine{
+		dims:       dims,
+		fences:     rbush.New(dims),
+		objects:    rbush.New(dims),
+		fenceByID:  make(map[string]*fence),
+		objectByID: make(map[string]*object),
+	}
+}
+
+func (e *Engine) checkBox(min, max []float64) {
+	if len(min) != len(max) || len(min) != e.dims {
+		panic("box dimensions does not match engine dimensions")
+	}
+}
+
+// SetFence adds a fence, or moves an existing fence. Returns the Enter
+// events of the objects that the fence now covers, and the Exit events of
+// the objects it no longer covers.
+func (e *Engine) SetFence(id string, min, max []float64) []Event {
+	e.checkBox(min, max)
+	var events []Event
+	f := e.fenceByID[id]
+	if f == nil {
+		f = &fence{id: id}
+		e.fenceByID[id] = f
+	} else {
+		e.fences.Remove(f)
+		// the objects that were inside of the fence before it moved
+		e.objects.Search(f, func(item rbush.Item) bool {
+			o := item.(*object)
+			if o.fences[id] && !intersects(o, min, max) {
+				delete(o.fences, id)
+				events = append(events, Event{Exit, o.id, id})
+			}
+			return true
+		})
+	}
+	f.min = append([]float64(nil), min...)
+	f.max = append([]float64(nil), max...)
+	e.fences.Insert(f)
+	e.objects.Search(f, func(item rbush.Item) bool {
+		o := item.(*object)
+		if !o.fences[id] {
+			o.fences[id] = true
+			events = append(events, Event{Enter, o.id, id})
+		}
+		return true
+	})
+	sortEvents(events)
+	return events
+}
+
+// RemoveFence removes a fence. Returns the Exit events of the objects that
+// intersected the fence.
+func (e *Engine) RemoveFence(id string) []Event {
+	f := e.fenceByID[id]
+	if f == nil {
+		return nil
+	}
+	e.fences.Remove(f)
+	delete(e.fenceByID, id)
+	var events []Event
+	e.objects.Search(f, func(item rbush.Item) bool {
+		o := item.(*object)
+		if o.fences[id] {
+			delete(o.fences, id)
+			events = append(events, Event{Exit, o.id, id})
+		}
+		return true
+	})
+	sortEvents(events)
+	return events
+}
+
+// Set adds an object, or moves an existing object. Returns an event for
+// every fence that the object entered, exited or stayed inside of.
+func (e *Engine) Set(id string, min, max []float64) []Event {
+	e.checkBox(min, max)
+	o := e.objectByID[id]
+	if o == nil {
+		o = &object{id: id, fences: make(map[string]bool)}
+		e.objectByID[id] = o
+	} else {
+		e.objects.Remove(o)
+	}
+	o.min = append([]float64(nil), min...)
+	o.max = append([]float64(nil), max...)
+	e.objects.Insert(o)
+
+	var events []Event
+	now := make(map[string]bool)
+	e.fences.Search(o, func(item rbush.Item) bool {
+		f := item.(*fence)
+		now[f.id] = true
+		if o.fences[f.id] {
+			events = append(events, Event{Inside, id, f.id})
+		} else {
+			events = append(events, Event{Enter, id, f.id})
+		}
+		return true
+	})
+	for fid := range o.fences {
+		if !now[fid] {
+			events = append(events, Event{Exit, id, fid})
+		}
+	}
+	o.fences = now
+	sortEvents(events)
+	return events
+}
+
+// Delete removes an object. Returns the Exit events of the fences that the
+// object was inside of.
+func (e *Engine) Delete(id string) []Event {
+	o := e.objectByID[id]
+	if o == nil {
+		return nil
+	}
+	e.objects.Remove(o)
+	delete(e.objectByID, id)
+	var events []Event
+	for fid := range o.fences {
+		events = append(events, Event{Exit, id, fid})
+	}
+	sortEvents(events)
+	return events
+}
+
+// Fences calls iter with the fences that the object is inside of.
+func (e *Engine) Fences(id string, iter func(fence string) bool) {
+	o := e.objectByID[id]
+	if o == nil {
+		return
+	}
+	ids := make([]string, 0, len(o.fences))
+	for fid := range o.fences {
+		ids = append(ids, fid)
+	}
+	sort.Strings(ids)
+	for _, fid := range ids {
+		if !iter(fid) {
+			return
+		}
+	}
+}
+
+// Search calls iter with the objects that intersect the box.
+func (e *Engine) Search(min, max []float64, iter func(id string, min, max []float64) bool) {
+	e.checkBox(min, max)
+	e.objects.Search(&fence{min: min, max: max}, func(item rbush.Item) bool {
+		o := item.(*object)
+		return iter(o.id, o.min, o.max)
+	})
+}
+
+func intersects(o *object, bmin, bmax []float64) bool {
+	amin, amax := o.min, o.max
+	for i := 0; i < len(amin); i++ {
+		if !(bmin[i] <= amax[i] && bmax[i] >= amin[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortEvents orders the events by kind, object and fence, so that they are
+// reported in the same order every time.
+func sortEvents(events []Event) {
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Object != b.Object {
+			return a.Object < b.Object
+		}
+		return a.Fence < b.Fence
+	})
+}
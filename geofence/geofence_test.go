@@ -0,0 +1,103 @@
+package geofence_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush/geofence"
+)
+
+func TestEngine(t *testing.T) {
+	e := geofence.New(2)
+	assert.Nil(t, e.SetFence("park", []float64{0, 0}, []float64{10, 10}))
+	e.SetFence("city", []float64{5, 5}, []float64{50, 50})
+
+	assert.Equal(t, []geofence.Event{
+		{geofence.Enter, "truck", "park"},
+	}, e.Set("truck", []float64{1, 1}, []float64{1, 1}))
+	assert.Equal(t, []geofence.Event{
+		{geofence.Enter, "truck", "city"},
+		{geofence.Inside, "truck", "park"},
+	}, e.Set("truck", []float64{6, 6}, []float64{6, 6}))
+	assert.Equal(t, []geofence.Event{
+		{geofence.Exit, "truck", "park"},
+		{geofence.Inside, "truck", "city"},
+	}, e.Set("truck", []float64{20, 20}, []float64{20, 20}))
+
+	// moving fences report the objects they pass over
+	assert.Equal(t, []geofence.Event{
+		{geofence.Enter, "truck", "park"},
+	}, e.SetFence("park", []float64{15, 15}, []float64{25, 25}))
+	assert.Equal(t, []geofence.Event{
+		{geofence.Exit, "truck", "city"},
+	}, e.RemoveFence("city"))
+	var fences []string
+	e.Fences("truck", func(fence string) bool {
+		fences = append(fences, fence)
+		return true
+	})
+	assert.Equal(t, []string{"park"}, fences)
+	assert.Equal(t, []geofence.Event{
+		{geofence.Exit, "truck", "park"},
+	}, e.Delete("truck"))
+	assert.Equal(t, "inside", geofence.Inside.String())
+}
+
+func randBox(size float64) (min, max []float64) {
+	x, y := rand.Float64()*100, rand.Float64()*100
+	return []float64{x, y}, []float64{x + rand.Float64()*size, y + rand.Float64()*size}
+}
+
+func TestEngineRandom(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	type box struct{ min, max []float64 }
+	intersects := func(a, b box) bool {
+		for i := range a.min {
+			if !(b.min[i] <= a.max[i] && b.max[i] >= a.min[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	e := geofence.New(2)
+	fences := make(map[string]box)
+	objects := make(map[string]box)
+	for i := 0; i < 100; i++ {
+		id := "f" + strconv.Itoa(i)
+		min, max := randBox(20)
+		fences[id] = box{min, max}
+		e.SetFence(id, min, max)
+	}
+	for i := 0; i < 10000; i++ {
+		id := "o" + strconv.Itoa(rand.Intn(200))
+		min, max := randBox(2)
+		prev, moved := objects[id]
+		next := box{min, max}
+		objects[id] = next
+		var expect []geofence.Event
+		for _, kind := range []geofence.Kind{geofence.Enter, geofence.Exit, geofence.Inside} {
+			for j := 0; j < 100; j++ {
+				fid := "f" + strconv.Itoa(j)
+				before := moved && intersects(prev, fences[fid])
+				after := intersects(next, fences[fid])
+				if (kind == geofence.Enter && !before && after) ||
+					(kind == geofence.Exit && before && !after) ||
+					(kind == geofence.Inside && before && after) {
+					expect = append(expect, geofence.Event{kind, id, fid})
+				}
+			}
+		}
+		events := e.Set(id, min, max)
+		assert.ElementsMatch(t, expect, events)
+	}
+	var n int
+	e.Search([]float64{0, 0}, []float64{200, 200}, func(id string, min, max []float64) bool {
+		assert.Equal(t, objects[id].min, min)
+		n++
+		return true
+	})
+	assert.Equal(t, len(objects), n)
+}
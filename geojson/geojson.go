@@ -0,0 +1,437 @@
+// Package geojson reads and writes GeoJSON features as tree items.
+//
+// Features are indexed by the bounding box of their geometry. A search on
+// the tree is the filter step of a query, and exact predicates on the
+// geometries of the results are the refine step.
+package geojson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geom"
+)
+
+// Feature is a GeoJSON feature. Its box is the bounding box of its
+// geometry, which is computed when the feature is created.
+type Feature struct {
+	// ID is the raw JSON id, or nil when there is none.
+	ID json.RawMessage
+	// Geometry is nil for an unlocated feature.
+	Geometry geom.Geometry
+	// Properties is the raw JSON properties, which are written out as
+	// they were read.
+	Properties json.RawMessage
+
+	min, max []float64
+}
+
+// NewFeature returns a feature for a geometry. The box of the feature is
+// not updated when the geometry is changed later.
+func NewFeature(g geom.Geometry) *Feature {
+	f := &Feature{Geometry: g}
+	if g != nil {
+		f.min, f.max = g.Rect()
+	}
+	return f
+}
+
+// Rect returns the bounding box of the geometry, which is nil for a
+// feature that has no geometry or an empty one.
+func (f *Feature) Rect() (min, max []float64) {
+	return f.min, f.max
+}
+
+// Geom returns the geometry of the feature.
+func (f *Feature) Geom() geom.Geometry {
+	return f.Geometry
+}
+
+// MarshalJSON returns the feature as a GeoJSON object.
+func (f *Feature) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"Feature"`)
+	if len(f.ID) > 0 {
+		buf.WriteString(`,"id":`)
+		buf.Write(f.ID)
+	}
+	buf.WriteString(`,"geometry":`)
+	if f.Geometry == nil {
+		buf.WriteString("null")
+	} else {
+		data, err := MarshalGeometry(f.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	buf.WriteString(`,"properties":`)
+	if len(f.Properties) > 0 {
+		buf.Write(f.Properties)
+	} else {
+		buf.WriteString("null")
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// ParseFeature parses a GeoJSON feature. Positions must have at least dims
+// coordinates, and the ones past dims are dropped.
+func ParseFeature(data []byte, dims int) (*Feature, error) {
+	var obj struct {
+		Type       string          `json:"type"`
+		ID         json.RawMessage `json:"id"`
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if obj.Type != "Feature" {
+		return nil, fmt.Errorf("expected Feature, got %q", obj.Type)
+	}
+	var g geom.Geometry
+	if len(obj.Geometry) > 0 && string(obj.Geometry) != "null" {
+		var err error
+		if g, err = ParseGeometry(obj.Geometry, dims); err != nil {
+			return nil, err
+		}
+	}
+	f := NewFeature(g)
+	if string(obj.ID) != "null" {
+		f.ID = obj.ID
+	}
+	if string(obj.Properties) != "null" {
+		f.Properties = obj.Properties
+	}
+	return f, nil
+}
+
+// ParseGeometry parses a GeoJSON geometry. Positions must have at least
+// dims coordinates, and the ones past dims are dropped.
+func ParseGeometry(data []byte, dims int) (geom.Geometry, error) {
+	var obj struct {
+		Type        string            `json:"type"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+		Geometries  []json.RawMessage `json:"geometries"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if obj.Type == "GeometryCollection" {
+		gc := make(geom.GeometryCollection, 0, len(obj.Geometries))
+		for _, data := range obj.Geometries {
+			g, err := ParseGeometry(data, dims)
+			if err != nil {
+				return nil, err
+			}
+			gc = append(gc, g)
+		}
+		return gc, nil
+	}
+	if len(obj.Coordinates) == 0 {
+		return nil, fmt.Errorf("%s has no coordinates", obj.Type)
+	}
+	var err error
+	switch obj.Type {
+	case "Point":
+		var c []float64
+		if err = json.Unmarshal(obj.Coordinates, &c); err == nil {
+			if len(c) == 0 {
+				return geom.Point(nil), nil
+			}
+			var p geom.Point
+			if p, err = position(c, dims); err == nil {
+				return p, nil
+			}
+		}
+	case "LineString", "MultiPoint":
+		var c [][]float64
+		if err = json.Unmarshal(obj.Coordinates, &c); err == nil {
+			var ls geom.LineString
+			if ls, err = lineString(c, dims); err == nil {
+				if obj.Type == "MultiPoint" {
+					return geom.MultiPoint(ls), nil
+				}
+				return ls, nil
+			}
+		}
+	case "Polygon", "MultiLineString":
+		var c [][][]float64
+		if err = json.Unmarshal(obj.Coordinates, &c); err == nil {
+			var poly geom.Polygon
+			if poly, err = polygon(c, dims); err == nil {
+				if obj.Type == "MultiLineString" {
+					return geom.MultiLineString(poly), nil
+				}
+				return poly, nil
+			}
+		}
+	case "MultiPolygon":
+		var c [][][][]float64
+		if err = json.Unmarshal(obj.Coordinates, &c); err == nil {
+			mp := make(geom.MultiPolygon, len(c))
+			for i := range c {
+				if mp[i], err = polygon(c[i], dims); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				return mp, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown geometry type %q", obj.Type)
+	}
+	return nil, fmt.Errorf("%s: %v", obj.Type, err)
+}
+
+func position(c []float64, dims int) (geom.Point, error) {
+	if len(c) < dims {
+		return nil, fmt.Errorf("position has %d coordinates, expected %d", len(c), dims)
+	}
+	return geom.Point(c[:dims:dims]), nil
+}
+
+func lineString(c [][]float64, dims int) (geom.LineString, error) {
+	ls := make(geom.LineString, len(c))
+	for i := range c {
+		var err error
+		if ls[i], err = position(c[i], dims); err != nil {
+			return nil, err
+		}
+	}
+	return ls, nil
+}
+
+func polygon(c [][][]float64, dims int) (geom.Polygon, error) {
+	poly := make(geom.Polygon, len(c))
+	for i := range c {
+		var err error
+		if poly[i], err = lineString(c[i], dims); err != nil {
+			return nil, err
+		}
+	}
+	return poly, nil
+}
+
+// MarshalGeometry returns a geometry as a GeoJSON object.
+func MarshalGeometry(g geom.Geometry) ([]byte, error) {
+	if gc, ok := g.(geom.GeometryCollection); ok {
+		geometries := make([]json.RawMessage, len(gc))
+		for i, g := range gc {
+			var err error
+			if geometries[i], err = MarshalGeometry(g); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(struct {
+			Type       string            `json:"type"`
+			Geometries []json.RawMessage `json:"geometries"`
+		}{gc.Type(), geometries})
+	}
+	var n int
+	switch g := g.(type) {
+	case geom.Point:
+		n = len(g)
+	case geom.LineString:
+		n = len(g)
+	case geom.Polygon:
+		n = len(g)
+	case geom.MultiPoint:
+		n = len(g)
+	case geom.MultiLineString:
+		n = len(g)
+	case geom.MultiPolygon:
+		n = len(g)
+	default:
+		return nil, fmt.Errorf("unsupported geometry %T", g)
+	}
+	var coords interface{} = g
+	if n == 0 {
+		coords = []float64{} // not null
+	}
+	return json.Marshal(struct {
+		Type        string      `json:"type"`
+		Coordinates interface{} `json:"coordinates"`
+	}{g.Type(), coords})
+}
+
+// Decode reads a FeatureCollection, a single Feature or a bare geometry
+// from r, and calls fn for each feature. The features of a collection are
+// decoded one at a time, so the whole document is never held in memory.
+// Decoding stops at the first error from fn, which is returned.
+func Decode(r io.Reader, dims int, fn func(f *Feature) error) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return errors.New("expected a GeoJSON object")
+	}
+	// every member except the features is kept, to parse a Feature or a
+	// geometry once the whole object is read
+	members := make(map[string]json.RawMessage)
+	var n int
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		if key != "features" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			members[key] = raw
+			continue
+		}
+		if tok, err := dec.Token(); err != nil {
+			return err
+		} else if tok != json.Delim('[') {
+			return errors.New("features is not an array")
+		}
+		for ; dec.More(); n++ {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return err
+			}
+			f, err := ParseFeature(raw, dims)
+			if err != nil {
+				return fmt.Errorf("feature %d: %v", n, err)
+			}
+			if err := fn(f); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	var typ string
+	json.Unmarshal(members["type"], &typ)
+	switch typ {
+	case "FeatureCollection":
+		return nil
+	case "":
+		return errors.New("object has no type")
+	}
+	if n > 0 {
+		return fmt.Errorf("%s has features", typ)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	if typ == "Feature" {
+		f, err := ParseFeature(data, dims)
+		if err != nil {
+			return err
+		}
+		return fn(f)
+	}
+	g, err := ParseGeometry(data, dims)
+	if err != nil {
+		return err
+	}
+	return fn(NewFeature(g))
+}
+
+// loadBatch is the number of features that are passed to each Load.
+const loadBatch = 4096
+
+// Load reads the features from r and bulk loads them into the tree as they
+// are decoded, in batches that are passed to the Load of the tree, and
+// returns the number of features loaded. Features that have no geometry,
+// or an empty one, cannot be indexed and are skipped. When reading fails,
+// the batches before the error stay in the tree.
+func Load(tr *rbush.RBush, r io.Reader) (int, error) {
+	min, _ := tr.Bounds()
+	var n int
+	batch := make([]rbush.Item, 0, loadBatch)
+	err := Decode(r, len(min), func(f *Feature) error {
+		if f.min == nil {
+			return nil
+		}
+		batch = append(batch, f)
+		if len(batch) == loadBatch {
+			tr.Load(batch)
+			n += len(batch)
+			batch = batch[:0]
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	tr.Load(batch)
+	return n + len(batch), nil
+}
+
+// Writer writes a FeatureCollection one feature at a time.
+type Writer struct {
+	w   *bufio.Writer
+	n   int
+	err error
+}
+
+// NewWriter returns a writer that writes a FeatureCollection to w. The
+// collection is complete once Close is called.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes an item, which is a *Feature or a geometry. A geometry is
+// written as a feature without properties.
+func (w *Writer) Write(item rbush.Item) error {
+	if w.err != nil {
+		return w.err
+	}
+	f, ok := item.(*Feature)
+	if !ok {
+		g, ok := item.(geom.Geometry)
+		if !ok {
+			return fmt.Errorf("cannot write %T as a feature", item)
+		}
+		f = &Feature{Geometry: g}
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if w.n == 0 {
+		w.w.WriteString(`{"type":"FeatureCollection","features":[` + "\n")
+	} else {
+		w.w.WriteString(",\n")
+	}
+	w.n++
+	_, w.err = w.w.Write(data)
+	return w.err
+}
+
+// Close ends the collection and flushes it. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.n == 0 {
+		w.w.WriteString(`{"type":"FeatureCollection","features":[]}` + "\n")
+	} else {
+		w.w.WriteString("\n]}\n")
+	}
+	w.err = w.w.Flush()
+	if w.err == nil {
+		w.err = errors.New("writer is closed")
+		return nil
+	}
+	return w.err
+}
@@ -0,0 +1,244 @@
+package geojson_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geojson"
+	"github.com/tidwall/rbush/geom"
+)
+
+const collection = `{
+	"type": "FeatureCollection",
+	"name": "places",
+	"features": [
+		{"type": "Feature", "id": "pt", "properties": {"name": "point", "n": 1.50},
+			"geometry": {"type": "Point", "coordinates": [1, 2, 300]}},
+		{"type": "Feature", "id": 7, "properties": null,
+			"geometry": {"type": "Polygon", "coordinates": [
+				[[0, 0], [4, 0], [4, 3], [0, 0]],
+				[[1, 0.5], [2, 0.5], [2, 1], [1, 0.5]]
+			]}},
+		{"type": "Feature", "properties": {"tags": ["a", "b"]},
+			"geometry": {"type": "GeometryCollection", "geometries": [
+				{"type": "MultiPoint", "coordinates": [[10, 10], [11, 9]]},
+				{"type": "LineString", "coordinates": [[11, 9], [12, 12]]}
+			]}},
+		{"type": "Feature", "id": "nowhere", "properties": {}, "geometry": null},
+		{"type": "Feature", "id": "lines", "properties": {},
+			"geometry": {"type": "MultiLineString", "coordinates": [[[20, 20], [21, 21]], [[25, 20], [24, 26]]]}},
+		{"type": "Feature", "id": "polys", "properties": {},
+			"geometry": {"type": "MultiPolygon", "coordinates": [
+				[[[30, 30], [31, 30], [31, 31], [30, 30]]],
+				[[[40, 40], [41, 40], [41, 41], [40, 40]]]
+			]}}
+	]
+}`
+
+func search(tr *rbush.RBush, min, max []float64) []*geojson.Feature {
+	var found []*geojson.Feature
+	tr.Search(geom.LineString{min, max}, func(item rbush.Item) bool {
+		found = append(found, item.(*geojson.Feature))
+		return true
+	})
+	return found
+}
+
+func TestLoad(t *testing.T) {
+	tr := rbush.New(2)
+	n, err := geojson.Load(tr, strings.NewReader(collection))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 5, tr.Count())
+	min, max := tr.Bounds()
+	assert.Equal(t, []float64{0, 0}, min)
+	assert.Equal(t, []float64{41, 41}, max)
+
+	found := search(tr, []float64{0.5, 1.5}, []float64{1.5, 2.5})
+	assert.Equal(t, 2, len(found))
+	for _, f := range found {
+		switch string(f.ID) {
+		case `"pt"`:
+			assert.Equal(t, geom.Point{1, 2}, f.Geometry)
+			assert.Equal(t, `{"name": "point", "n": 1.50}`, string(f.Properties))
+		case "7":
+			assert.Nil(t, f.Properties)
+			assert.Equal(t, 2, len(f.Geometry.(geom.Polygon)))
+		default:
+			t.Fatalf("unexpected feature %s", f.ID)
+		}
+	}
+	found = search(tr, []float64{11.5, 11.5}, []float64{11.5, 11.5})
+	assert.Equal(t, 1, len(found))
+	min, max = found[0].Rect()
+	assert.Equal(t, []float64{10, 9}, min)
+	assert.Equal(t, []float64{12, 12}, max)
+	assert.Equal(t, 1, len(search(tr, []float64{24, 26}, []float64{24, 26})))
+	// the box of the multipolygon covers the gap between its parts
+	assert.Equal(t, 1, len(search(tr, []float64{35, 35}, []float64{36, 36})))
+	assert.Equal(t, 0, len(search(tr, []float64{5, 20}, []float64{6, 30})))
+
+	// in three dimensions only the point has enough coordinates, and the
+	// batch that failed is not loaded
+	tr = rbush.New(3)
+	n, err = geojson.Load(tr, strings.NewReader(collection))
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, tr.Count())
+
+	// a hilbert tree is packed by hilbert value
+	tr = rbush.NewOptions(2, &rbush.Options{
+		Hilbert:    true,
+		HilbertMin: []float64{-180, -90},
+		HilbertMax: []float64{180, 90},
+	})
+	n, err = geojson.Load(tr, strings.NewReader(collection))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.NoError(t, tr.Validate())
+}
+
+func TestWriter(t *testing.T) {
+	var features []*geojson.Feature
+	err := geojson.Decode(strings.NewReader(collection), 2, func(f *geojson.Feature) error {
+		features = append(features, f)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(features))
+
+	var buf bytes.Buffer
+	w := geojson.NewWriter(&buf)
+	for _, f := range features {
+		assert.NoError(t, w.Write(f))
+	}
+	assert.NoError(t, w.Write(geom.Point{5, 6}))
+	assert.Error(t, w.Write(&struct{ rbush.Item }{}))
+	assert.NoError(t, w.Close())
+	assert.Error(t, w.Write(geom.Point{5, 6}))
+	assert.True(t, json.Valid(buf.Bytes()))
+
+	var again []*geojson.Feature
+	err = geojson.Decode(&buf, 2, func(f *geojson.Feature) error {
+		again = append(again, f)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(features)+1, len(again))
+	for i, f := range features {
+		assert.Equal(t, f.Geometry, again[i].Geometry)
+		assert.Equal(t, string(f.ID), string(again[i].ID))
+		var a, b interface{}
+		json.Unmarshal(f.Properties, &a)
+		json.Unmarshal(again[i].Properties, &b)
+		assert.Equal(t, a, b)
+		amin, amax := f.Rect()
+		bmin, bmax := again[i].Rect()
+		assert.Equal(t, amin, bmin)
+		assert.Equal(t, amax, bmax)
+	}
+	assert.Equal(t, geom.Point{5, 6}, again[len(again)-1].Geometry)
+
+	buf.Reset()
+	w = geojson.NewWriter(&buf)
+	assert.NoError(t, w.Close())
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[]}`, buf.String())
+
+	for _, g := range []geom.Geometry{geom.Point{}, geom.LineString{}, geom.MultiPolygon{}} {
+		data, err := geojson.MarshalGeometry(g)
+		assert.NoError(t, err)
+		assert.JSONEq(t, fmt.Sprintf(`{"type":%q,"coordinates":[]}`, g.Type()), string(data))
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var ids []string
+	collect := func(f *geojson.Feature) error {
+		ids = append(ids, string(f.ID))
+		return nil
+	}
+	// features come before the type, and a single feature or geometry
+	docs := []string{
+		`{"features": [{"type": "Feature", "id": 1, "geometry": null, "properties": null}], "type": "FeatureCollection"}`,
+		`{"type": "Feature", "id": 2, "geometry": {"type": "Point", "coordinates": [1, 2]}, "properties": {"a": 1}}`,
+		`{"coordinates": [[1, 2], [3, 4]], "type": "LineString"}`,
+	}
+	for _, doc := range docs {
+		assert.NoError(t, geojson.Decode(strings.NewReader(doc), 2, collect))
+	}
+	assert.Equal(t, []string{"1", "2", ""}, ids)
+
+	stop := fmt.Errorf("stop")
+	ids = nil
+	err := geojson.Decode(strings.NewReader(collection), 2, func(f *geojson.Feature) error {
+		ids = append(ids, string(f.ID))
+		if len(ids) == 2 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, []string{`"pt"`, "7"}, ids)
+
+	bad := []string{
+		`[]`,
+		`{"features": []}`,
+		`{"type": "Feature", "features": [{"type": "Feature", "geometry": null}]}`,
+		`{"type": "FeatureCollection", "features": {}}`,
+		`{"type": "FeatureCollection", "features": [{"type": "Point"}]}`,
+		`{"type": "FeatureCollection", "features": [`,
+		`{"type": "Circle", "coordinates": [1, 2]}`,
+		`{"type": "Point"}`,
+		`{"type": "Point", "coordinates": [1]}`,
+		`{"type": "LineString", "coordinates": [1, 2]}`,
+		`{"type": "MultiPolygon", "coordinates": [[[[1, 2], [3]]]]}`,
+		`{"type": "GeometryCollection", "geometries": [{"type": "Point", "coordinates": "x"}]}`,
+	}
+	for _, doc := range bad {
+		assert.Error(t, geojson.Decode(strings.NewReader(doc), 2, collect), doc)
+	}
+}
+
+func TestLoadLarge(t *testing.T) {
+	var buf bytes.Buffer
+	w := geojson.NewWriter(&buf)
+	var points []geom.Point
+	for i := 0; i < 10000; i++ {
+		p := geom.Point{rand.Float64()*360 - 180, rand.Float64()*180 - 90}
+		points = append(points, p)
+		f := geojson.NewFeature(p)
+		f.ID = json.RawMessage(fmt.Sprint(i))
+		f.Properties = json.RawMessage(fmt.Sprintf(`{"i":%d}`, i))
+		assert.NoError(t, w.Write(f))
+	}
+	assert.NoError(t, w.Close())
+
+	tr := rbush.New(2)
+	n, err := geojson.Load(tr, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(points), n)
+	assert.NoError(t, tr.Validate())
+	// the batches are packed, so most leaves are full
+	st := tr.Stats()
+	leaves := st.Levels[len(st.Levels)-1].Nodes
+	assert.True(t, leaves < len(points)/8, "%d leaves", leaves)
+	found := search(tr, []float64{-10, -10}, []float64{10, 10})
+	var expect int
+	for _, p := range points {
+		if p[0] >= -10 && p[0] <= 10 && p[1] >= -10 && p[1] <= 10 {
+			expect++
+		}
+	}
+	assert.Equal(t, expect, len(found))
+	for _, f := range found {
+		var props struct{ I int }
+		assert.NoError(t, json.Unmarshal(f.Properties, &props))
+		assert.Equal(t, points[props.I], f.Geometry)
+	}
+}
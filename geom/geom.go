@@ -0,0 +1,127 @@
+// Package geom holds the geometry types shared by the geometry encodings.
+//
+// A position is a slice of coordinates, such as x and y, or x, y and z.
+// Every geometry is an rbush.Item whose box is the bounding box of its
+// positions, and all of the positions in a geometry have the same number of
+// coordinates. Empty geometries have no box, and Rect returns nil for them.
+package geom
+
+// Geometry is a shape made of positions.
+type Geometry interface {
+	Rect() (min, max []float64)
+	// Type is the name of the geometry, such as "Point" or "MultiPolygon".
+	Type() string
+}
+
+// Point is a single position.
+type Point []float64
+
+// LineString is a path through positions.
+type LineString []Point
+
+// Polygon is a list of closed rings. The first ring is the exterior, and
+// the others are holes.
+type Polygon []LineString
+
+// MultiPoint is a set of points.
+type MultiPoint []Point
+
+// MultiLineString is a set of line strings.
+type MultiLineString []LineString
+
+// MultiPolygon is a set of polygons.
+type MultiPolygon []Polygon
+
+// GeometryCollection is a set of geometries of any type.
+type GeometryCollection []Geometry
+
+func (Point) Type() string              { return "Point" }
+func (LineString) Type() string         { return "LineString" }
+func (Polygon) Type() string            { return "Polygon" }
+func (MultiPoint) Type() string         { return "MultiPoint" }
+func (MultiLineString) Type() string    { return "MultiLineString" }
+func (MultiPolygon) Type() string       { return "MultiPolygon" }
+func (GeometryCollection) Type() string { return "GeometryCollection" }
+
+func (p Point) Rect() (min, max []float64) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return p, p
+}
+
+func (ls LineString) Rect() (min, max []float64) {
+	var b bounds
+	for _, p := range ls {
+		b.extend(p, p)
+	}
+	return b.min, b.max
+}
+
+func (poly Polygon) Rect() (min, max []float64) {
+	// holes are inside of the exterior
+	if len(poly) == 0 {
+		return nil, nil
+	}
+	return poly[0].Rect()
+}
+
+func (mp MultiPoint) Rect() (min, max []float64) {
+	return LineString(mp).Rect()
+}
+
+func (mls MultiLineString) Rect() (min, max []float64) {
+	var b bounds
+	for _, ls := range mls {
+		b.extend(ls.Rect())
+	}
+	return b.min, b.max
+}
+
+func (mp MultiPolygon) Rect() (min, max []float64) {
+	var b bounds
+	for _, poly := range mp {
+		b.extend(poly.Rect())
+	}
+	return b.min, b.max
+}
+
+func (gc GeometryCollection) Rect() (min, max []float64) {
+	var b bounds
+	for _, g := range gc {
+		b.extend(g.Rect())
+	}
+	return b.min, b.max
+}
+
+// bounds is a growing bounding box, which is nil until it is extended by a
+// box.
+type bounds struct {
+	min, max []float64
+}
+
+func (b *bounds) extend(min, max []float64) {
+	if min == nil {
+		return
+	}
+	if b.min == nil {
+		b.min = append([]float64(nil), min...)
+		b.max = append([]float64(nil), max...)
+		return
+	}
+	for i := range b.min {
+		if min[i] < b.min[i] {
+			b.min[i] = min[i]
+		}
+		if max[i] > b.max[i] {
+			b.max[i] = max[i]
+		}
+	}
+}
+
+// Dims returns the number of coordinates of the positions in a geometry,
+// or zero for an empty geometry.
+func Dims(g Geometry) int {
+	min, _ := g.Rect()
+	return len(min)
+}
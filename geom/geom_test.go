@@ -0,0 +1,58 @@
+package geom_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geom"
+)
+
+func TestRect(t *testing.T) {
+	square := geom.LineString{{0, 0}, {4, 0}, {4, 4}, {0, 4}, {0, 0}}
+	tests := []struct {
+		g        geom.Geometry
+		min, max []float64
+	}{
+		{geom.Point{1, 2}, []float64{1, 2}, []float64{1, 2}},
+		{geom.Point{1, 2, 3}, []float64{1, 2, 3}, []float64{1, 2, 3}},
+		{geom.LineString{{3, 1}, {1, 5}, {2, 2}}, []float64{1, 1}, []float64{3, 5}},
+		{geom.Polygon{square, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}}, []float64{0, 0}, []float64{4, 4}},
+		{geom.MultiPoint{{5, 5}, {-1, 7}}, []float64{-1, 5}, []float64{5, 7}},
+		{geom.MultiLineString{{{0, 0}, {1, 1}}, {{9, -9}, {10, 10}}}, []float64{0, -9}, []float64{10, 10}},
+		{geom.MultiPolygon{{square}, {{{10, 10}, {11, 10}, {10, 11}, {10, 10}}}}, []float64{0, 0}, []float64{11, 11}},
+		{geom.GeometryCollection{geom.Point{-3, 8}, square, geom.MultiPoint{}}, []float64{-3, 0}, []float64{4, 8}},
+	}
+	for _, test := range tests {
+		min, max := test.g.Rect()
+		assert.Equal(t, test.min, min, test.g.Type())
+		assert.Equal(t, test.max, max, test.g.Type())
+		assert.Equal(t, len(test.min), geom.Dims(test.g))
+	}
+
+	// the box is a copy
+	min, _ := square.Rect()
+	min[0] = -100
+	assert.Equal(t, geom.Point{0, 0}, square[0])
+
+	for _, g := range []geom.Geometry{geom.Point{}, geom.LineString{}, geom.Polygon{},
+		geom.MultiPolygon{{}}, geom.GeometryCollection{geom.MultiPoint{}}} {
+		min, max := g.Rect()
+		assert.Nil(t, min)
+		assert.Nil(t, max)
+		assert.Equal(t, 0, geom.Dims(g))
+	}
+
+	// geometries are items
+	tr := rbush.New(2)
+	for _, test := range tests[:1] {
+		tr.Insert(test.g)
+	}
+	tr.Insert(square)
+	var found []rbush.Item
+	tr.Search(geom.Point{3, 3}, func(item rbush.Item) bool {
+		found = append(found, item)
+		return true
+	})
+	assert.Equal(t, []rbush.Item{square}, found)
+}
@@ -0,0 +1,557 @@
+package geom
+
+import (
+	"math"
+	"math/big"
+	"sort"
+)
+
+// The predicates follow the OGC simple features model, where a polygon has
+// its rings as the boundary, a line string has its end points as the
+// boundary, and a point is all interior. They use the x and y of positions,
+// and other coordinates are ignored, as are positions with an x or y that is
+// not finite. Orientation tests are exact, so points
+// that are on a line or a ring are found to be on it. Polygons should be
+// valid, with holes inside of the exterior and parts that do not overlap.
+
+// Intersects reports whether the geometries share a point.
+func Intersects(a, b Geometry) bool {
+	return intersects(newShape(a), newShape(b))
+}
+
+// Disjoint reports whether the geometries share no point.
+func Disjoint(a, b Geometry) bool {
+	return !Intersects(a, b)
+}
+
+// Contains reports whether no point of b is outside of a, and the
+// interiors of the geometries share a point.
+func Contains(a, b Geometry) bool {
+	sa, sb := newShape(a), newShape(b)
+	return covers(sa, sb) && interiorsIntersect(sa, sb)
+}
+
+// Within reports whether a is contained by b.
+func Within(a, b Geometry) bool {
+	return Contains(b, a)
+}
+
+// Touches reports whether the geometries share a point, but their
+// interiors do not.
+func Touches(a, b Geometry) bool {
+	sa, sb := newShape(a), newShape(b)
+	return intersects(sa, sb) && !interiorsIntersect(sa, sb)
+}
+
+// Box returns the geometry of the x and y of a box, which is a polygon, or
+// a line string or a point when the box has no area.
+func Box(min, max []float64) Geometry {
+	if len(min) < 2 {
+		return Point(nil)
+	}
+	switch {
+	case min[0] == max[0] && min[1] == max[1]:
+		return Point{min[0], min[1]}
+	case min[0] == max[0] || min[1] == max[1]:
+		return LineString{{min[0], min[1]}, {max[0], max[1]}}
+	}
+	return Polygon{{
+		{min[0], min[1]}, {max[0], min[1]}, {max[0], max[1]}, {min[0], max[1]}, {min[0], min[1]},
+	}}
+}
+
+type location int
+
+const (
+	exterior location = iota
+	boundary
+	interior
+)
+
+type pt struct {
+	x, y float64
+}
+
+// seg is a segment of a line string, or an edge of a polygon.
+type seg struct {
+	a, b pt
+	edge bool
+	left bool // the interior of the polygon is on the left of an edge
+}
+
+// shape is a geometry taken apart for the predicates.
+type shape struct {
+	points   []pt
+	segs     []seg
+	verts    []pt        // vertices of the line strings
+	ends     map[pt]bool // the boundary of the line strings
+	counts   map[pt]int  // uses of the ends of line strings, while adding
+	polys    [][][]pt    // closed rings
+	firsts   []pt        // the first vertex of every line string and polygon
+	min, max pt
+	empty    bool
+}
+
+func newShape(g Geometry) *shape {
+	s := &shape{
+		min:    pt{math.Inf(+1), math.Inf(+1)},
+		max:    pt{math.Inf(-1), math.Inf(-1)},
+		counts: make(map[pt]int),
+		ends:   make(map[pt]bool),
+	}
+	s.add(g)
+	// mod 2 rule, an end point that is shared by two line strings is not
+	// on the boundary
+	for p, n := range s.counts {
+		if n%2 == 1 {
+			s.ends[p] = true
+		}
+	}
+	s.empty = s.min.x > s.max.x
+	return s
+}
+
+func (s *shape) extend(p pt) {
+	s.min.x, s.min.y = math.Min(s.min.x, p.x), math.Min(s.min.y, p.y)
+	s.max.x, s.max.y = math.Max(s.max.x, p.x), math.Max(s.max.y, p.y)
+}
+
+// position returns the x and y of a position, and whether it is used.
+func position(p []float64) (pt, bool) {
+	if len(p) < 2 || math.IsInf(p[0], 0) || math.IsNaN(p[0]) ||
+		math.IsInf(p[1], 0) || math.IsNaN(p[1]) {
+		return pt{}, false
+	}
+	return pt{p[0], p[1]}, true
+}
+
+// path returns the used positions without repeats.
+func path(ls LineString) []pt {
+	var pts []pt
+	for _, p := range ls {
+		q, ok := position(p)
+		if !ok {
+			continue
+		}
+		if len(pts) == 0 || pts[len(pts)-1] != q {
+			pts = append(pts, q)
+		}
+	}
+	return pts
+}
+
+func (s *shape) add(g Geometry) {
+	switch g := g.(type) {
+	case Point:
+		if p, ok := position(g); ok {
+			s.points = append(s.points, p)
+			s.extend(p)
+		}
+	case LineString:
+		pts := path(g)
+		if len(pts) == 1 {
+			s.add(Point{pts[0].x, pts[0].y})
+			return
+		}
+		if len(pts) == 0 {
+			return
+		}
+		for i := 0; i < len(pts)-1; i++ {
+			s.segs = append(s.segs, seg{a: pts[i], b: pts[i+1]})
+			s.extend(pts[i])
+		}
+		s.extend(pts[len(pts)-1])
+		s.verts = append(s.verts, pts...)
+		s.firsts = append(s.firsts, pts[0])
+		if pts[0] != pts[len(pts)-1] {
+			s.counts[pts[0]]++
+			s.counts[pts[len(pts)-1]]++
+		}
+	case Polygon:
+		var rings [][]pt
+		for i, ring := range g {
+			pts := path(ring)
+			if len(pts) > 0 && pts[0] != pts[len(pts)-1] {
+				pts = append(pts, pts[0])
+			}
+			if len(pts) < 4 {
+				if i == 0 {
+					return // no area
+				}
+				continue
+			}
+			// interior of the polygon is to the left of a counter clockwise
+			// exterior, and of a clockwise hole
+			left := (ringArea(pts) > 0) == (i == 0)
+			for j := 0; j < len(pts)-1; j++ {
+				s.segs = append(s.segs, seg{pts[j], pts[j+1], true, left})
+				s.extend(pts[j])
+			}
+			if i == 0 {
+				s.firsts = append(s.firsts, pts[0])
+			}
+			rings = append(rings, pts)
+		}
+		if len(rings) > 0 {
+			s.polys = append(s.polys, rings)
+		}
+	case MultiPoint:
+		for _, p := range g {
+			s.add(p)
+		}
+	case MultiLineString:
+		for _, ls := range g {
+			s.add(ls)
+		}
+	case MultiPolygon:
+		for _, poly := range g {
+			s.add(poly)
+		}
+	case GeometryCollection:
+		for _, g := range g {
+			s.add(g)
+		}
+	}
+}
+
+// ringArea returns twice the signed area of a closed ring, which is
+// positive when the ring is counter clockwise.
+func ringArea(ring []pt) float64 {
+	var area float64
+	for i := 0; i < len(ring)-1; i++ {
+		area += ring[i].x*ring[i+1].y - ring[i+1].x*ring[i].y
+	}
+	return area
+}
+
+// orient returns the side of the line through a and b that c is on, which
+// is 1 for the left, -1 for the right and 0 for on the line. The result is
+// exact. A float64 estimate is used when its error bound allows.
+func orient(a, b, c pt) int {
+	l := (b.x - a.x) * (c.y - a.y)
+	r := (b.y - a.y) * (c.x - a.x)
+	det := l - r
+	// bound on the error of det, from Shewchuk's orient2d
+	const errBound = (3 + 16*epsilon) * epsilon
+	if math.Abs(det) > errBound*(math.Abs(l)+math.Abs(r)) {
+		if det > 0 {
+			return 1
+		}
+		return -1
+	}
+	rat := func(f float64) *big.Rat { return new(big.Rat).SetFloat64(f) }
+	sub := func(x, y float64) *big.Rat { return new(big.Rat).Sub(rat(x), rat(y)) }
+	el := new(big.Rat).Mul(sub(b.x, a.x), sub(c.y, a.y))
+	er := new(big.Rat).Mul(sub(b.y, a.y), sub(c.x, a.x))
+	return el.Cmp(er)
+}
+
+const epsilon = 1.0 / (1 << 53)
+
+// between reports whether c, which is on the line through a and b, is on
+// the segment from a to b.
+func between(a, b, c pt) bool {
+	return math.Min(a.x, b.x) <= c.x && c.x <= math.Max(a.x, b.x) &&
+		math.Min(a.y, b.y) <= c.y && c.y <= math.Max(a.y, b.y)
+}
+
+func onSegment(a, b, c pt) bool {
+	return between(a, b, c) && orient(a, b, c) == 0
+}
+
+func segmentsIntersect(a, b, c, d pt) bool {
+	o1, o2 := orient(a, b, c), orient(a, b, d)
+	o3, o4 := orient(c, d, a), orient(c, d, b)
+	if o1*o2 < 0 && o3*o4 < 0 {
+		return true
+	}
+	return (o1 == 0 && between(a, b, c)) || (o2 == 0 && between(a, b, d)) ||
+		(o3 == 0 && between(c, d, a)) || (o4 == 0 && between(c, d, b))
+}
+
+// crosses reports whether the segments cross at a point that is not an
+// end of either.
+func crosses(a, b, c, d pt) bool {
+	return orient(a, b, c)*orient(a, b, d) < 0 && orient(c, d, a)*orient(c, d, b) < 0
+}
+
+func ringLocate(ring []pt, p pt) location {
+	var winding int
+	for i := 0; i < len(ring)-1; i++ {
+		a, b := ring[i], ring[i+1]
+		o := orient(a, b, p)
+		if o == 0 && between(a, b, p) {
+			return boundary
+		}
+		if a.y <= p.y {
+			if b.y > p.y && o > 0 {
+				winding++
+			}
+		} else if b.y <= p.y && o < 0 {
+			winding--
+		}
+	}
+	if winding != 0 {
+		return interior
+	}
+	return exterior
+}
+
+func polyLocate(rings [][]pt, p pt) location {
+	loc := ringLocate(rings[0], p)
+	if loc != interior {
+		return loc
+	}
+	for _, hole := range rings[1:] {
+		switch ringLocate(hole, p) {
+		case interior:
+			return exterior
+		case boundary:
+			return boundary
+		}
+	}
+	return interior
+}
+
+// locatePolys returns the location of a point in the polygons.
+func (s *shape) locatePolys(p pt) location {
+	loc := exterior
+	for _, poly := range s.polys {
+		switch polyLocate(poly, p) {
+		case interior:
+			return interior
+		case boundary:
+			loc = boundary
+		}
+	}
+	return loc
+}
+
+// locate returns the location of a point in the shape.
+func (s *shape) locate(p pt) location {
+	if p.x < s.min.x || p.x > s.max.x || p.y < s.min.y || p.y > s.max.y {
+		return exterior
+	}
+	loc := s.locatePolys(p)
+	if loc == interior {
+		return interior
+	}
+	for _, sg := range s.segs {
+		if !sg.edge && onSegment(sg.a, sg.b, p) {
+			if !s.ends[p] {
+				return interior
+			}
+			loc = boundary
+		}
+	}
+	for _, q := range s.points {
+		if q == p {
+			return interior
+		}
+	}
+	return loc
+}
+
+func (s *shape) overlaps(o *shape) bool {
+	return s.min.x <= o.max.x && s.max.x >= o.min.x && s.min.y <= o.max.y && s.max.y >= o.min.y
+}
+
+// piece is the part of a segment between two of the points where it meets
+// another shape.
+type piece struct {
+	line bool     // it is on a line string of the shape
+	loc  location // its location in the polygons of the shape
+	same bool     // it is on an edge with the interior on the same side
+	opp  bool     // it is on an edge with the interior on the other side
+}
+
+// pieces cuts a segment of another shape where it meets this shape, and
+// calls fn with each piece. It stops when fn returns false.
+func (s *shape) pieces(sg seg, fn func(pc piece) bool) bool {
+	d := pt{sg.b.x - sg.a.x, sg.b.y - sg.a.y}
+	dd := d.x*d.x + d.y*d.y
+	param := func(p pt) float64 {
+		t := ((p.x-sg.a.x)*d.x + (p.y-sg.a.y)*d.y) / dd
+		return math.Max(0, math.Min(1, t))
+	}
+	ts := []float64{0, 1}
+	type overlap struct {
+		e      seg
+		t0, t1 float64
+	}
+	var overlaps []overlap
+	min := pt{math.Min(sg.a.x, sg.b.x), math.Min(sg.a.y, sg.b.y)}
+	max := pt{math.Max(sg.a.x, sg.b.x), math.Max(sg.a.y, sg.b.y)}
+	for _, e := range s.segs {
+		if math.Max(e.a.x, e.b.x) < min.x || math.Min(e.a.x, e.b.x) > max.x ||
+			math.Max(e.a.y, e.b.y) < min.y || math.Min(e.a.y, e.b.y) > max.y {
+			continue
+		}
+		oa, ob := orient(sg.a, sg.b, e.a), orient(sg.a, sg.b, e.b)
+		switch {
+		case oa == 0 && ob == 0:
+			ta, tb := param(e.a), param(e.b)
+			ts = append(ts, ta, tb)
+			overlaps = append(overlaps, overlap{e, math.Min(ta, tb), math.Max(ta, tb)})
+		case oa == 0:
+			if between(sg.a, sg.b, e.a) {
+				ts = append(ts, param(e.a))
+			}
+		case ob == 0:
+			if between(sg.a, sg.b, e.b) {
+				ts = append(ts, param(e.b))
+			}
+		case oa != ob && orient(e.a, e.b, sg.a)*orient(e.a, e.b, sg.b) <= 0:
+			// crossing, at the ratio of the distances of the ends of e
+			// from the line of the segment
+			ca := (e.a.x-sg.a.x)*d.y - (e.a.y-sg.a.y)*d.x
+			cb := (e.b.x-sg.a.x)*d.y - (e.b.y-sg.a.y)*d.x
+			f := ca / (ca - cb)
+			ts = append(ts, param(pt{e.a.x + f*(e.b.x-e.a.x), e.a.y + f*(e.b.y-e.a.y)}))
+		}
+	}
+	sort.Float64s(ts)
+	for i := 1; i < len(ts); i++ {
+		t0, t1 := ts[i-1], ts[i]
+		if !(t1 > t0) {
+			continue
+		}
+		tm := (t0 + t1) / 2
+		var pc piece
+		for _, ov := range overlaps {
+			if tm < ov.t0 || tm > ov.t1 {
+				continue
+			}
+			if !ov.e.edge {
+				pc.line = true
+				continue
+			}
+			pc.loc = boundary
+			// the interior of this polygon is on the left of sg when the
+			// edge has the same direction and its interior is left
+			same := d.x*(ov.e.b.x-ov.e.a.x)+d.y*(ov.e.b.y-ov.e.a.y) > 0
+			if (same == ov.e.left) == sg.left {
+				pc.same = true
+			} else {
+				pc.opp = true
+			}
+		}
+		if pc.loc != boundary {
+			pc.loc = s.locatePolys(pt{sg.a.x + tm*d.x, sg.a.y + tm*d.y})
+		}
+		if !fn(pc) {
+			return false
+		}
+	}
+	return true
+}
+
+func intersects(a, b *shape) bool {
+	if a.empty || b.empty || !a.overlaps(b) {
+		return false
+	}
+	for _, p := range a.points {
+		if b.locate(p) != exterior {
+			return true
+		}
+	}
+	for _, p := range b.points {
+		if a.locate(p) != exterior {
+			return true
+		}
+	}
+	for _, sa := range a.segs {
+		for _, sb := range b.segs {
+			if segmentsIntersect(sa.a, sa.b, sb.a, sb.b) {
+				return true
+			}
+		}
+	}
+	// one is inside of a polygon of the other, without any edges meeting
+	for _, p := range a.firsts {
+		if b.locatePolys(p) != exterior {
+			return true
+		}
+	}
+	for _, p := range b.firsts {
+		if a.locatePolys(p) != exterior {
+			return true
+		}
+	}
+	return false
+}
+
+func interiorsIntersect(a, b *shape) bool {
+	if a.empty || b.empty || !a.overlaps(b) {
+		return false
+	}
+	for _, pair := range [2][2]*shape{{a, b}, {b, a}} {
+		a, b := pair[0], pair[1]
+		for _, p := range a.points {
+			if b.locate(p) == interior {
+				return true
+			}
+		}
+		for _, v := range a.verts {
+			if !a.ends[v] && b.locate(v) == interior {
+				return true
+			}
+		}
+		for _, sa := range a.segs {
+			if !b.pieces(sa, func(pc piece) bool {
+				if sa.edge {
+					return !(pc.loc == interior || pc.same)
+				}
+				return !(pc.line || pc.loc == interior)
+			}) {
+				return true
+			}
+		}
+	}
+	for _, sa := range a.segs {
+		for _, sb := range b.segs {
+			if !sa.edge && !sb.edge && crosses(sa.a, sa.b, sb.a, sb.b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// covers reports whether no point of b is outside of a.
+func covers(a, b *shape) bool {
+	if a.empty || b.empty || b.min.x < a.min.x || b.max.x > a.max.x ||
+		b.min.y < a.min.y || b.max.y > a.max.y {
+		return false
+	}
+	if len(b.polys) > 0 && len(a.polys) == 0 {
+		return false
+	}
+	for _, p := range b.points {
+		if a.locate(p) == exterior {
+			return false
+		}
+	}
+	for _, sb := range b.segs {
+		if !a.pieces(sb, func(pc piece) bool {
+			if sb.edge {
+				// the edge is inside, or on an edge of a with the interior
+				// of both on the same side
+				return pc.loc == interior || (pc.loc == boundary && (pc.same || !pc.opp))
+			}
+			return pc.line || pc.loc != exterior
+		}) {
+			return false
+		}
+	}
+	// the boundary of a polygon of a must not pass through the interior of
+	// b, where it would have the outside of a on one side
+	for _, sa := range a.segs {
+		if sa.edge && !b.pieces(sa, func(pc piece) bool {
+			return pc.loc != interior
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package geom_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush/geom"
+)
+
+func square(x, y, size float64) geom.LineString {
+	return geom.LineString{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}}
+}
+
+func TestPredicates(t *testing.T) {
+	poly := geom.Polygon{square(0, 0, 10)}
+	holed := geom.Polygon{square(0, 0, 10), square(4, 4, 2)}
+	tests := []struct {
+		name       string
+		a, b       geom.Geometry
+		intersects bool
+		contains   bool // a contains b
+		within     bool // a is within b
+		touches    bool
+	}{
+		{"same points", geom.Point{1, 2}, geom.Point{1, 2}, true, true, true, false},
+		{"other points", geom.Point{1, 2}, geom.Point{2, 1}, false, false, false, false},
+		{"point in polygon", poly, geom.Point{5, 5}, true, true, false, false},
+		{"point on edge", poly, geom.Point{10, 5}, true, false, false, true},
+		{"point on vertex", poly, geom.Point{0, 0}, true, false, false, true},
+		{"point outside", poly, geom.Point{11, 5}, false, false, false, false},
+		{"point in hole", holed, geom.Point{5, 5}, false, false, false, false},
+		{"point on hole", holed, geom.Point{4, 5}, true, false, false, true},
+		{"point inside line", geom.LineString{{0, 0}, {2, 2}}, geom.Point{1, 1}, true, true, false, false},
+		{"point at line end", geom.LineString{{0, 0}, {2, 2}}, geom.Point{2, 2}, true, false, false, true},
+		{"point near line", geom.LineString{{0, 0}, {3, 1}}, geom.Point{1, 0.3333333333333333}, false, false, false, false},
+		{"closed line has no ends", geom.LineString{{0, 0}, {1, 0}, {1, 1}, {0, 0}}, geom.Point{0, 0}, true, true, false, false},
+		{"crossing lines", geom.LineString{{0, 0}, {2, 2}}, geom.LineString{{0, 2}, {2, 0}}, true, false, false, false},
+		{"lines meet at ends", geom.LineString{{0, 0}, {1, 1}}, geom.LineString{{1, 1}, {2, 0}}, true, false, false, true},
+		{"end on line", geom.LineString{{0, 0}, {2, 0}}, geom.LineString{{1, 0}, {1, 1}}, true, false, false, true},
+		{"collinear overlap", geom.LineString{{0, 0}, {2, 0}}, geom.LineString{{1, 0}, {3, 0}}, true, false, false, false},
+		{"line in line", geom.LineString{{0, 0}, {1, 0}, {3, 0}}, geom.LineString{{0.5, 0}, {2, 0}}, true, true, false, false},
+		{"parallel lines", geom.LineString{{0, 0}, {2, 0}}, geom.LineString{{0, 1}, {2, 1}}, false, false, false, false},
+		{"line in polygon", poly, geom.LineString{{1, 1}, {9, 9}}, true, true, false, false},
+		{"line on edge", poly, geom.LineString{{0, 0}, {10, 0}}, true, false, false, true},
+		{"line across polygon", poly, geom.LineString{{-1, 5}, {11, 5}}, true, false, false, false},
+		{"line along edge and in", poly, geom.LineString{{0, 0}, {5, 0}, {5, 5}}, true, true, false, false},
+		{"line across hole", holed, geom.LineString{{1, 5}, {9, 5}}, true, false, false, false},
+		{"line in hole", holed, geom.LineString{{4.5, 5}, {5.5, 5}}, false, false, false, false},
+		{"line to hole", holed, geom.LineString{{1, 5}, {4, 5}}, true, true, false, false},
+		{"polygon in polygon", poly, geom.Polygon{square(1, 1, 2)}, true, true, false, false},
+		{"same polygons", poly, geom.Polygon{square(0, 0, 10)}, true, true, true, false},
+		{"polygon on inner edge", poly, geom.Polygon{square(0, 0, 2)}, true, true, false, false},
+		{"polygons share edge", poly, geom.Polygon{square(10, 2, 2)}, true, false, false, true},
+		{"polygons share vertex", poly, geom.Polygon{square(10, 10, 2)}, true, false, false, true},
+		{"overlapping polygons", poly, geom.Polygon{square(8, 8, 4)}, true, false, false, false},
+		{"polygon around polygon", geom.Polygon{square(2, 2, 2)}, poly, true, false, true, false},
+		{"polygon fills hole", holed, geom.Polygon{square(4, 4, 2)}, true, false, false, true},
+		{"polygon in hole", holed, geom.Polygon{square(4.5, 4.5, 1)}, false, false, false, false},
+		{"polygon over hole", holed, geom.Polygon{square(3, 3, 4)}, true, false, false, false},
+		{"multipoint", poly, geom.MultiPoint{{1, 1}, {10, 10}}, true, true, false, false},
+		{"multipoint outside", poly, geom.MultiPoint{{1, 1}, {11, 11}}, true, false, false, false},
+		{
+			"multipolygon",
+			geom.MultiPolygon{{square(0, 0, 1)}, {square(5, 5, 1)}},
+			geom.LineString{{0.5, 0.5}, {5.5, 5.5}},
+			true, false, false, false,
+		},
+		{
+			"adjacent parts cover",
+			geom.MultiPolygon{{square(0, 0, 1)}, {square(1, 0, 1)}},
+			geom.LineString{{0.5, 0.5}, {1.5, 0.5}},
+			true, true, false, false,
+		},
+		{
+			"collection",
+			geom.GeometryCollection{geom.Point{20, 20}, poly},
+			geom.Point{5, 5},
+			true, true, false, false,
+		},
+		{"empty", poly, geom.Point(nil), false, false, false, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.intersects, geom.Intersects(test.a, test.b), test.name)
+		assert.Equal(t, test.intersects, geom.Intersects(test.b, test.a), test.name)
+		assert.Equal(t, !test.intersects, geom.Disjoint(test.a, test.b), test.name)
+		assert.Equal(t, test.contains, geom.Contains(test.a, test.b), test.name)
+		assert.Equal(t, test.contains, geom.Within(test.b, test.a), test.name)
+		assert.Equal(t, test.within, geom.Within(test.a, test.b), test.name)
+		assert.Equal(t, test.touches, geom.Touches(test.a, test.b), test.name)
+		assert.Equal(t, test.touches, geom.Touches(test.b, test.a), test.name)
+	}
+}
+
+func TestRobust(t *testing.T) {
+	// points that are on the line only when the orientation is exact
+	a, b := geom.Point{0.1, 0.1}, geom.Point{0.7, 0.7}
+	line := geom.LineString{a, b}
+	for i := 0; i <= 100; i++ {
+		f := 0.1 + float64(i)*0.006
+		assert.True(t, geom.Intersects(line, geom.Point{f, f}))
+	}
+	assert.False(t, geom.Intersects(line, geom.Point{0.3, 0.30000000000000004}))
+	// a ring with a nearly flat vertex
+	poly := geom.Polygon{{{0, 0}, {1e-300, 1e-300}, {1, 1}, {0, 1}, {0, 0}}}
+	assert.True(t, geom.Touches(poly, geom.Point{0.5, 0.5}))
+	// positions that are not finite are ignored
+	inf := math.Inf(1)
+	assert.False(t, geom.Intersects(geom.LineString{{0, 0}, {inf, 1}}, geom.Point{1, 1}))
+	assert.False(t, geom.Intersects(geom.Point{inf, 0}, geom.Point{inf, 0}))
+	assert.True(t, geom.Intersects(geom.LineString{{0, 0}, {math.NaN(), 1}, {2, 2}}, geom.Point{1, 1}))
+	// and finite coordinates that overflow are still exact
+	assert.True(t, geom.Intersects(geom.LineString{{-1e308, -1e308}, {1e308, 1e308}}, geom.Point{1, 1}))
+}
+
+func TestBox(t *testing.T) {
+	assert.Equal(t, geom.Point{1, 2}, geom.Box([]float64{1, 2}, []float64{1, 2}))
+	assert.Equal(t, geom.LineString{{1, 2}, {1, 5}}, geom.Box([]float64{1, 2, 0}, []float64{1, 5, 9}))
+	box := geom.Box([]float64{0, 0}, []float64{2, 2})
+	assert.True(t, geom.Contains(box, geom.Point{1, 1}))
+	assert.True(t, geom.Touches(box, geom.Point{2, 1}))
+}
@@ -0,0 +1,65 @@
+package geom
+
+// Layout names the coordinates of the positions in a geometry.
+type Layout int
+
+const (
+	XY Layout = iota
+	XYZ
+	XYM
+	XYZM
+)
+
+// Dims returns the number of coordinates in a position.
+func (l Layout) Dims() int {
+	switch l {
+	case XYZ, XYM:
+		return 3
+	case XYZM:
+		return 4
+	}
+	return 2
+}
+
+func (l Layout) String() string {
+	switch l {
+	case XYZ:
+		return "XYZ"
+	case XYM:
+		return "XYM"
+	case XYZM:
+		return "XYZM"
+	}
+	return "XY"
+}
+
+// Shape is a geometry with its layout and spatial reference system. It is
+// an item whose box is computed when the shape is created, so a shape can
+// be put into a tree with the dimensions of its layout.
+type Shape struct {
+	Geometry Geometry
+	Layout   Layout
+	// SRID is the spatial reference system, or zero when unknown.
+	SRID int
+
+	min, max []float64
+}
+
+// NewShape returns a shape for a geometry. The box of the shape is not
+// updated when the geometry is changed later.
+func NewShape(g Geometry, layout Layout, srid int) *Shape {
+	s := &Shape{Geometry: g, Layout: layout, SRID: srid}
+	s.min, s.max = g.Rect()
+	return s
+}
+
+// Rect returns the bounding box of the geometry, which is nil for an empty
+// geometry.
+func (s *Shape) Rect() (min, max []float64) {
+	return s.min, s.max
+}
+
+// Geom returns the geometry of the shape.
+func (s *Shape) Geom() Geometry {
+	return s.Geometry
+}
@@ -0,0 +1,109 @@
+package rbush
+
+import (
+	"math"
+
+	"github.com/tidwall/rbush/geom"
+)
+
+// Geometry is an item with an exact shape, which SearchGeometry tests in
+// place of the item's box. Items that are a geom.Geometry are used as is.
+type Geometry interface {
+	Item
+	Geom() geom.Geometry
+}
+
+// Predicate is the relation of an item to the query of SearchGeometry.
+type Predicate int
+
+const (
+	// Intersects finds items that share a point with the query.
+	Intersects Predicate = iota
+	// Contains finds items that contain the query.
+	Contains
+	// Within finds items that are within the query.
+	Within
+	// Disjoint finds items that share no point with the query.
+	Disjoint
+	// Touches finds items that share a point with the query, but not a
+	// point of their interiors.
+	Touches
+)
+
+// itemGeom returns the exact shape of an item, or the box of the item when
+// it has no shape.
+func itemGeom(item Item) geom.Geometry {
+	switch item := item.(type) {
+	case Geometry:
+		if g := item.Geom(); g != nil {
+			return g
+		}
+	case geom.Geometry:
+		return item
+	}
+	return geom.Box(item.Rect())
+}
+
+// SearchGeometry calls iter with the items that are related to the query by
+// the predicate. The boxes of the items are searched first, and then the
+// predicate is tested with the exact shapes, which uses the x and y of the
+// positions. Other dimensions are not filtered when the query has fewer
+// dimensions than the tree.
+func (tr *RBush) SearchGeometry(query geom.Geometry, pred Predicate, iter func(item Item) bool) bool {
+	if query == nil {
+		panic("query is nil")
+	}
+	var test func(a, b geom.Geometry) bool
+	switch pred {
+	case Intersects:
+		test = geom.Intersects
+	case Contains:
+		test = geom.Contains
+	case Within:
+		test = geom.Within
+	case Disjoint:
+		test = geom.Disjoint
+	case Touches:
+		test = geom.Touches
+	default:
+		panic("invalid predicate")
+	}
+	qmin, qmax := query.Rect()
+	if len(qmin) > tr.dims {
+		qmin, qmax = qmin[:tr.dims], qmax[:tr.dims]
+	}
+	if pred == Disjoint {
+		return tr.Scan(func(item Item) bool {
+			if len(qmin) > 0 {
+				min, max := item.Rect()
+				for i := range qmin {
+					if min[i] > qmax[i] || max[i] < qmin[i] {
+						return iter(item)
+					}
+				}
+			}
+			if test(itemGeom(item), query) {
+				return iter(item)
+			}
+			return true
+		})
+	}
+	if len(qmin) == 0 {
+		return true
+	}
+	min := make([]float64, tr.dims)
+	max := make([]float64, tr.dims)
+	for i := range min {
+		if i < len(qmin) {
+			min[i], max[i] = qmin[i], qmax[i]
+		} else {
+			min[i], max[i] = math.Inf(-1), math.Inf(1)
+		}
+	}
+	return tr.searchBBox(min, max, func(item Item) bool {
+		if test(itemGeom(item), query) {
+			return iter(item)
+		}
+		return true
+	})
+}
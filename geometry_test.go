@@ -0,0 +1,113 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geom"
+	"github.com/tidwall/rbush/wkt"
+)
+
+func randomGeometry(rng *rand.Rand) geom.Geometry {
+	x, y := float64(rng.Intn(100)), float64(rng.Intn(100))
+	size := float64(rng.Intn(10) + 1)
+	switch rng.Intn(4) {
+	case 0:
+		return geom.Point{x, y}
+	case 1:
+		return geom.LineString{{x, y}, {x + size, y + float64(rng.Intn(5))}}
+	case 2:
+		return geom.Polygon{{{x, y}, {x + size, y}, {x, y + size}, {x, y}}}
+	}
+	// a box item without a geometry
+	return nil
+}
+
+func TestSearchGeometry(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tr := rbush.New(2)
+	var items []rbush.Item
+	for i := 0; i < 1000; i++ {
+		var item rbush.Item
+		if g := randomGeometry(rng); g != nil {
+			item = g
+		} else {
+			x, y := float64(rng.Intn(100)), float64(rng.Intn(100))
+			item = makeRect(x, y, x+float64(rng.Intn(5)), y+float64(rng.Intn(5)))
+		}
+		items = append(items, item)
+	}
+	tr.Load(items)
+	preds := []struct {
+		pred rbush.Predicate
+		test func(a, b geom.Geometry) bool
+	}{
+		{rbush.Intersects, geom.Intersects},
+		{rbush.Contains, geom.Contains},
+		{rbush.Within, geom.Within},
+		{rbush.Disjoint, geom.Disjoint},
+		{rbush.Touches, geom.Touches},
+	}
+	for i := 0; i < 50; i++ {
+		query := randomGeometry(rng)
+		if query == nil {
+			x, y := float64(rng.Intn(80)), float64(rng.Intn(80))
+			query = geom.Box([]float64{x, y}, []float64{x + 20, y + 20})
+		}
+		for _, p := range preds {
+			var want []rbush.Item
+			for _, item := range items {
+				g, ok := item.(geom.Geometry)
+				if !ok {
+					g = geom.Box(item.Rect())
+				}
+				if p.test(g, query) {
+					want = append(want, item)
+				}
+			}
+			var found []rbush.Item
+			tr.SearchGeometry(query, p.pred, func(item rbush.Item) bool {
+				found = append(found, item)
+				return true
+			})
+			assert.ElementsMatch(t, want, found)
+		}
+	}
+}
+
+func TestSearchShapes(t *testing.T) {
+	tr := rbush.New(3)
+	var shapes []*geom.Shape
+	for _, text := range []string{
+		"POINT Z (5 5 100)",
+		"LINESTRING Z (0 0 0, 10 10 0)",
+		"POLYGON Z ((20 0 0, 30 0 0, 30 10 0, 20 0 0))",
+		"POINT Z (25 9 0)",
+	} {
+		s, err := wkt.Parse(text)
+		assert.NoError(t, err)
+		shapes = append(shapes, s)
+		tr.Insert(s)
+	}
+	search := func(query geom.Geometry, pred rbush.Predicate) []rbush.Item {
+		var found []rbush.Item
+		tr.SearchGeometry(query, pred, func(item rbush.Item) bool {
+			found = append(found, item)
+			return true
+		})
+		return found
+	}
+	// the polygon's box holds the last point, but the polygon does not
+	square := geom.Box([]float64{18, -1}, []float64{32, 11})
+	assert.ElementsMatch(t, []rbush.Item{shapes[2], shapes[3]}, search(square, rbush.Within))
+	assert.ElementsMatch(t, []rbush.Item{shapes[2]}, search(geom.LineString{{20, 5}, {30, 5}}, rbush.Intersects))
+	assert.ElementsMatch(t, []rbush.Item{shapes[3]}, search(geom.Point{25, 9}, rbush.Intersects))
+	// a 2-D query matches every z
+	assert.ElementsMatch(t, []rbush.Item{shapes[0], shapes[1]}, search(geom.Point{5, 5}, rbush.Intersects))
+	assert.ElementsMatch(t, []rbush.Item{shapes[1]}, search(geom.Point{10, 10}, rbush.Touches))
+	assert.ElementsMatch(t, []rbush.Item{shapes[0], shapes[1]}, search(geom.Point{5, 5}, rbush.Contains))
+	assert.Len(t, search(geom.Point{50, 50}, rbush.Disjoint), 4)
+	assert.Empty(t, search(geom.Point(nil), rbush.Intersects))
+}
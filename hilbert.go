@@ -0,0 +1,337 @@
+package rbush
+
+import "sort"
+
+// hilbertSpace maps item centers onto a Hilbert curve.
+type hilbertSpace struct {
+	min, max []float64
+	bits     uint // bits per dimension
+}
+
+func newHilbertSpace(dims int, min, max []float64) *hilbertSpace {
+	if min == nil || max == nil {
+		panic("hilbert bounds are required")
+	}
+	if len(min) != dims || len(max) != dims {
+		panic("hilbert bounds dimensions does not match tree dimensions")
+	}
+	bits := uint(63 / dims)
+	if bits > 32 {
+		bits = 32
+	}
+	if bits == 0 {
+		panic("too many dimensions for a hilbert tree")
+	}
+	return &hilbertSpace{
+		min:  append([]float64(nil), min...),
+		max:  append([]float64(nil), max...),
+		bits: bits,
+	}
+}
+
+// value returns the Hilbert value of the center of the box.
+func (hs *hilbertSpace) value(min, max []float64) uint64 {
+	var buf [8]uint32
+	coords := buf[:0]
+	scale := float64(uint64(1)<<hs.bits - 1)
+	for i := 0; i < len(min); i++ {
+		var v float64
+		if hs.max[i] > hs.min[i] {
+			v = ((min[i]+max[i])/2 - hs.min[i]) / (hs.max[i] - hs.min[i])
+		}
+		if !(v > 0) { // also catches NaN
+			v = 0
+		} else if v > 1 {
+			v = 1
+		}
+		coords = append(coords, uint32(v*scale))
+	}
+	return hilbertIndex(coords, hs.bits)
+}
+
+func (hs *hilbertSpace) itemValue(item Item) uint64 {
+	return hs.value(item.Rect())
+}
+
+// calcLHV sets the largest Hilbert value of a node, whose children are
+// always kept in Hilbert order.
+func (hs *hilbertSpace) calcLHV(node *treeNode) {
+	if len(node.children) == 0 {
+		node.lhv = 0
+	} else if last := node.children[len(node.children)-1]; node.leaf {
+		node.lhv = hs.itemValue(last.(Item))
+	} else {
+		node.lhv = last.(*treeNode).lhv
+	}
+}
+
+// hilbertIndex returns the distance along the Hilbert curve of the point,
+// using John Skilling's transpose algorithm. The coords are modified.
+func hilbertIndex(x []uint32, bits uint) uint64 {
+	n := len(x)
+	m := uint32(1) << (bits - 1)
+	// inverse undo excess work
+	for q := m; q > 1; q >>= 1 {
+		p := q - 1
+		for i := 0; i < n; i++ {
+			if x[i]&q != 0 {
+				x[0] ^= p
+			} else {
+				t := (x[0] ^ x[i]) & p
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	// gray encode
+	for i := 1; i < n; i++ {
+		x[i] ^= x[i-1]
+	}
+	var t uint32
+	for q := m; q > 1; q >>= 1 {
+		if x[n-1]&q != 0 {
+			t ^= q - 1
+		}
+	}
+	for i := 0; i < n; i++ {
+		x[i] ^= t
+	}
+	// interleave the transposed bits, most significant first
+	var h uint64
+	for b := int(bits) - 1; b >= 0; b-- {
+		for i := 0; i < n; i++ {
+			h = h<<1 | uint64(x[i]>>uint(b)&1)
+		}
+	}
+	return h
+}
+
+func (tr *RBush) hilbertInsert(bbox *treeNode, item Item) {
+	h := tr.hilbert.value(bbox.min, bbox.max)
+	path := tr.reusePath[:0]
+	tr.data = tr.own(tr.data)
+	node := tr.data
+	for {
+		path = append(path, node)
+		if node.leaf {
+			break
+		}
+		// descend into the first child with a larger hilbert value
+		children := node.children
+		i := sort.Search(len(children), func(i int) bool {
+			return children[i].(*treeNode).lhv >= h
+		})
+		if i == len(children) {
+			i--
+		}
+		node = tr.ownChild(node, children[i].(*treeNode))
+	}
+	children := node.children
+	i := sort.Search(len(children), func(i int) bool {
+		return tr.hilbert.itemValue(children[i].(Item)) > h
+	})
+	node.children = append(node.children, nil)
+	copy(node.children[i+1:], node.children[i:])
+	node.children[i] = item
+
+	for level := len(path) - 1; level >= 0; level-- {
+		node := path[level]
+		if len(node.children) <= tr.maxEntries {
+			tr.refresh(node)
+		} else if level > 0 {
+			tr.hilbertShare(path[level-1], node)
+		} else {
+			// split the root in two
+			newNode := createNode(nil, tr.dims)
+			newNode.leaf = node.leaf
+			newNode.height = node.height
+			newNode.gen = tr.gen
+			entries := append([]interface{}(nil), node.children...)
+			tr.distribute(entries, node, newNode)
+			tr.data = createNode([]interface{}{node, newNode}, tr.dims)
+			tr.data.height = node.height + 1
+			tr.data.leaf = false
+			tr.data.gen = tr.gen
+			tr.refresh(tr.data)
+		}
+	}
+	tr.reusePath = path
+}
+
+// hilbertShare spreads the entries of an overflowing node over itself and
+// a cooperating sibling. When both are full a new node is added to the
+// parent and the entries are spread over all three.
+func (tr *RBush) hilbertShare(parent, node *treeNode) {
+	i, j := tr.hilbertSibling(parent, node)
+	a := tr.ownChild(parent, parent.children[i].(*treeNode))
+	b := tr.ownChild(parent, parent.children[j].(*treeNode))
+	entries := make([]interface{}, 0, len(a.children)+len(b.children))
+	entries = append(entries, a.children...)
+	entries = append(entries, b.children...)
+	if len(entries) <= tr.maxEntries*2 {
+		tr.distribute(entries, a, b)
+		return
+	}
+	c := createNode(nil, tr.dims)
+	c.leaf = a.leaf
+	c.height = a.height
+	c.gen = tr.gen
+	tr.distribute(entries, a, b, c)
+	parent.children = append(parent.children, nil)
+	copy(parent.children[j+2:], parent.children[j+1:])
+	parent.children[j+1] = c
+}
+
+// hilbertCondense fixes the tree after an item was removed from the last
+// node in path. Underfull nodes borrow entries from a sibling, or are merged
+// with it.
+func (tr *RBush) hilbertCondense(path []*treeNode) {
+	for level := len(path) - 1; level > 0; level-- {
+		node, parent := path[level], path[level-1]
+		if len(node.children) >= tr.minEntries || len(parent.children) < 2 {
+			tr.refresh(node)
+			continue
+		}
+		i, j := tr.hilbertSibling(parent, node)
+		a := tr.ownChild(parent, parent.children[i].(*treeNode))
+		b := tr.ownChild(parent, parent.children[j].(*treeNode))
+		entries := make([]interface{}, 0, len(a.children)+len(b.children))
+		entries = append(entries, a.children...)
+		entries = append(entries, b.children...)
+		if len(entries) > tr.maxEntries {
+			tr.distribute(entries, a, b)
+			continue
+		}
+		tr.distribute(entries, a)
+		copy(parent.children[j:], parent.children[j+1:])
+		parent.children[len(parent.children)-1] = nil
+		parent.children = parent.children[:len(parent.children)-1]
+	}
+	tr.refresh(path[0])
+	tr.condenseRoot()
+}
+
+// hilbertSibling returns the index of node in parent and the index of its
+// cooperating sibling, in order. The right sibling is preferred.
+func (tr *RBush) hilbertSibling(parent, node *treeNode) (int, int) {
+	i := 0
+	for ; i < len(parent.children); i++ {
+		if parent.children[i] == node {
+			break
+		}
+	}
+	if i+1 < len(parent.children) {
+		return i, i + 1
+	}
+	return i - 1, i
+}
+
+// distribute spreads the ordered entries evenly over the nodes.
+func (tr *RBush) distribute(entries []interface{}, nodes ...*treeNode) {
+	for i, node := range nodes {
+		size := len(entries) / (len(nodes) - i)
+		node.children = make([]interface{}, size, tr.maxEntries+1)
+		copy(node.children, entries[:size])
+		entries = entries[size:]
+		tr.refresh(node)
+	}
+}
+
+// Load inserts many items at once. On a Hilbert tree all of the items are
+// sorted by their Hilbert values and packed into full nodes. On other trees
+// the new items are packed with Sort-Tile-Recursive, and the packed subtree
+// is inserted into the tree.
+func (tr *RBush) Load(items []Item) {
+	for _, item := range items {
+		tr.checkItem(item)
+	}
+	if tr.hilbert == nil {
+		tr.loadPacked(items)
+		for _, item := range items {
+			tr.notify(nil, item, false)
+		}
+		return
+	}
+	var entries []Item
+	scan(tr.data, func(item Item) bool {
+		entries = append(entries, item)
+		return true
+	})
+	entries = append(entries, items...)
+	values := make([]uint64, len(entries))
+	for i, item := range entries {
+		values[i] = tr.hilbert.itemValue(item)
+	}
+	sort.Stable(&byHilbert{entries, values})
+	children := make([]interface{}, len(entries))
+	for i, item := range entries {
+		children[i] = item
+	}
+	tr.data = tr.pack(children, nil)
+	for _, item := range items {
+		tr.notify(nil, item, false)
+	}
+}
+
+type byHilbert struct {
+	items  []Item
+	values []uint64
+}
+
+func (arr *byHilbert) Len() int           { return len(arr.items) }
+func (arr *byHilbert) Less(i, j int) bool { return arr.values[i] < arr.values[j] }
+func (arr *byHilbert) Swap(i, j int) {
+	arr.items[i], arr.items[j] = arr.items[j], arr.items[i]
+	arr.values[i], arr.values[j] = arr.values[j], arr.values[i]
+}
+
+// pack builds a tree bottom-up from items by filling each node in turn. The
+// optional order func may reorder the entries of each level before they are
+// grouped into nodes.
+func (tr *RBush) pack(entries []interface{}, order func(entries []interface{}, leaf bool)) *treeNode {
+	if len(entries) == 0 {
+		return createNode(nil, tr.dims)
+	}
+	leaf, height := true, 1
+	for {
+		if order != nil {
+			order(entries, leaf)
+		}
+		sizes := chunkSizes(len(entries), tr.maxEntries, tr.minEntries)
+		nodes := make([]interface{}, len(sizes))
+		for i, size := range sizes {
+			node := createNode(make([]interface{}, size, size+1), tr.dims)
+			copy(node.children, entries[:size])
+			entries = entries[size:]
+			node.leaf = leaf
+			node.height = height
+			node.gen = tr.gen
+			tr.refresh(node)
+			nodes[i] = node
+		}
+		if len(nodes) == 1 {
+			return nodes[0].(*treeNode)
+		}
+		entries = nodes
+		leaf = false
+		height++
+	}
+}
+
+// chunkSizes splits n entries into full nodes of M entries. When the last
+// node would have fewer than m entries it is balanced with the one before.
+func chunkSizes(n, M, m int) []int {
+	k := (n + M - 1) / M
+	sizes := make([]int, k)
+	for i := range sizes {
+		sizes[i] = M
+	}
+	last := n - (k-1)*M
+	sizes[k-1] = last
+	if k > 1 && last < m {
+		total := M + last
+		sizes[k-2] = total - total/2
+		sizes[k-1] = total / 2
+	}
+	return sizes
+}
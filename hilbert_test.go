@@ -0,0 +1,151 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func newHilbert(dims int) *rbush.RBush {
+	min, max := make([]float64, dims), make([]float64, dims)
+	for i := 0; i < dims; i++ {
+		min[i], max[i] = -50, 50
+	}
+	return rbush.NewOptions(dims, &rbush.Options{
+		Hilbert:    true,
+		HilbertMin: min,
+		HilbertMax: max,
+	})
+}
+
+// leafFill returns the average number of items per leaf.
+func leafFill(tr *rbush.RBush) float64 {
+	var leaves int
+	tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		if level == 1 {
+			leaves++
+		}
+		return true
+	})
+	return float64(tr.Count()) / float64(leaves)
+}
+
+func TestHilbert(t *testing.T) {
+	for dims := 1; dims <= 3; dims++ {
+		for _, which := range []string{"point", "rect"} {
+			rand.Seed(time.Now().UnixNano())
+			tr := newHilbert(dims)
+			objs := make([]rbush.Item, 10000)
+			for i := 0; i < len(objs); i++ {
+				objs[i] = makeRandom(which, dims)
+				tr.Insert(objs[i])
+			}
+			assert.Equal(t, len(objs), tr.Count())
+			_, counts := nodeOccupancy(tr)
+			for _, n := range counts {
+				if n < 4 || n > 9 {
+					t.Fatalf("node has %d children", n)
+				}
+			}
+			if dims > 1 {
+				def := rbush.New(dims)
+				for _, obj := range objs {
+					def.Insert(obj)
+				}
+				assert.True(t, leafFill(tr) > leafFill(def))
+			}
+			testSearch(t, tr, objs, 0.10, true)
+			testSearch(t, tr, objs, 0.50, true)
+			testKNN(t, tr, objs, 1000, true)
+
+			perm := rand.Perm(len(objs))
+			var remain []rbush.Item
+			for i, j := range perm {
+				if i < len(perm)/2 {
+					tr.Remove(objs[j])
+				} else {
+					remain = append(remain, objs[j])
+				}
+			}
+			assert.Equal(t, len(remain), tr.Count())
+			_, counts = nodeOccupancy(tr)
+			for _, n := range counts {
+				if n < 4 || n > 9 {
+					t.Fatalf("node has %d children", n)
+				}
+			}
+			testSearch(t, tr, remain, 0.50, true)
+			testKNN(t, tr, remain, 1000, true)
+
+			min, max := tr.Bounds()
+			n := tr.RemoveWhere(min, max, nil)
+			assert.Equal(t, len(remain), n)
+			assert.Equal(t, 0, tr.Count())
+		}
+	}
+}
+
+func TestHilbertLoad(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	objs := make([]rbush.Item, 10000)
+	for i := 0; i < len(objs); i++ {
+		objs[i] = makeRandom("point", 2)
+	}
+	tr := newHilbert(2)
+	tr.Load(objs[:5000])
+	tr.Load(objs[5000:])
+	assert.Equal(t, len(objs), tr.Count())
+	testSearch(t, tr, objs, 0.50, true)
+	testKNN(t, tr, objs, 1000, true)
+
+	// the packed structure does not depend on the order of the items
+	shuffled := make([]rbush.Item, len(objs))
+	for i, j := range rand.Perm(len(objs)) {
+		shuffled[i] = objs[j]
+	}
+	other := newHilbert(2)
+	other.Load(shuffled)
+	var a, b [][]float64
+	tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		a = append(a, min, max)
+		return true
+	})
+	other.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		b = append(b, min, max)
+		return true
+	})
+	assert.Equal(t, a, b)
+
+	// keep inserting into a packed tree
+	for i := 0; i < 1000; i++ {
+		obj := makeRandom("point", 2)
+		objs = append(objs, obj)
+		tr.Insert(obj)
+	}
+	testSearch(t, tr, objs, 0.50, true)
+	assert.Equal(t, len(objs), tr.RemoveAll(objs))
+}
+
+func TestHilbertInsertOverlap(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	tr := newHilbert(2)
+	// a curve with no extent gives every item the same value
+	flat := rbush.NewOptions(2, &rbush.Options{
+		Hilbert:    true,
+		HilbertMin: []float64{0, 0},
+		HilbertMax: []float64{0, 0},
+	})
+	for i := 0; i < 10000; i++ {
+		obj := makeRandom("point", 2)
+		tr.Insert(obj)
+		flat.Insert(obj)
+	}
+	assert.True(t, leafOverlap(tr)*100 < leafOverlap(flat))
+
+	assert.PanicsWithValue(t, "hilbert bounds are required", func() {
+		rbush.NewOptions(2, &rbush.Options{Hilbert: true})
+	})
+}
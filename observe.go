@@ -0,0 +1,112 @@
+package rbush
+
+// Observer receives the changes made to a tree. The methods are called
+// synchronously, after the change was made, and must not change the tree.
+type Observer interface {
+	Inserted(item Item)
+	Removed(item Item)
+	Updated(old, item Item)
+}
+
+// EventType is the kind of change of an Event.
+type EventType int
+
+const (
+	EventInserted EventType = iota + 1
+	EventRemoved
+	EventUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventInserted:
+		return "inserted"
+	case EventRemoved:
+		return "removed"
+	case EventUpdated:
+		return "updated"
+	}
+	return "unknown"
+}
+
+// Event is a change sent on the channel returned by Events. Old is only set
+// for updates.
+type Event struct {
+	Type EventType
+	Item Item
+	Old  Item
+}
+
+// Observe registers an observer of the changes made to the tree, including
+// the changes made by RemoveWhere, RemoveAll, Load and committed
+// transactions. Entries that are moved around inside of the tree are not
+// reported.
+func (tr *RBush) Observe(o Observer) {
+	if o == nil {
+		panic("observer is nil")
+	}
+	tr.observers = append(tr.observers, o)
+}
+
+// Unobserve removes an observer.
+func (tr *RBush) Unobserve(o Observer) {
+	for i, other := range tr.observers {
+		if other == o {
+			tr.observers = append(tr.observers[:i:i], tr.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+type eventObserver struct {
+	ch chan Event
+}
+
+func (o *eventObserver) Inserted(item Item) {
+	o.ch <- Event{Type: EventInserted, Item: item}
+}
+
+func (o *eventObserver) Removed(item Item) {
+	o.ch <- Event{Type: EventRemoved, Item: item}
+}
+
+func (o *eventObserver) Updated(old, item Item) {
+	o.ch <- Event{Type: EventUpdated, Item: item, Old: old}
+}
+
+// Events returns a channel that receives the changes made to the tree. The
+// channel buffers size events, and once it is full a change blocks until the
+// channel is read from.
+func (tr *RBush) Events(size int) <-chan Event {
+	o := &eventObserver{ch: make(chan Event, size)}
+	tr.Observe(o)
+	return o.ch
+}
+
+// StopEvents stops sending changes to a channel returned by Events, and
+// closes it.
+func (tr *RBush) StopEvents(ch <-chan Event) {
+	for _, o := range tr.observers {
+		if o, ok := o.(*eventObserver); ok && (<-chan Event)(o.ch) == ch {
+			tr.Unobserve(o)
+			close(o.ch)
+			return
+		}
+	}
+}
+
+// notify reports an insert when only item is set, a removal when only old
+// was removed, and an update when old was removed and item inserted.
+func (tr *RBush) notify(old, item Item, removed bool) {
+	for _, o := range tr.observers {
+		if item == nil {
+			if removed {
+				o.Removed(old)
+			}
+		} else if removed {
+			o.Updated(old, item)
+		} else {
+			o.Inserted(item)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package rbush_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+// recorder keeps the items that are in the tree according to the events.
+type recorder struct {
+	items   map[rbush.Item]bool
+	updates int
+}
+
+func (r *recorder) Inserted(item rbush.Item) {
+	r.items[item] = true
+}
+
+func (r *recorder) Removed(item rbush.Item) {
+	if !r.items[item] {
+		panic("removed an unknown item")
+	}
+	delete(r.items, item)
+}
+
+func (r *recorder) Updated(old, item rbush.Item) {
+	r.Removed(old)
+	r.Inserted(item)
+	r.updates++
+}
+
+func TestObserve(t *testing.T) {
+	for _, hilbert := range []bool{false, true} {
+		tr := rbush.New(2)
+		if hilbert {
+			tr = newHilbert(2)
+		}
+		r := &recorder{items: make(map[rbush.Item]bool)}
+		tr.Observe(r)
+		var objs []rbush.Item
+		for i := 0; i < 1000; i++ {
+			objs = append(objs, makeRandom("rect", 2))
+			tr.Insert(objs[i])
+		}
+		for i := 0; i < 200; i++ {
+			tr.Remove(objs[i])
+			tr.Remove(objs[i])
+		}
+		for i := 200; i < 300; i++ {
+			obj := makeRandom("rect", 2)
+			tr.Update(objs[i], obj)
+			objs[i] = obj
+		}
+		assert.Equal(t, 100, r.updates)
+		assert.Equal(t, itemSet(tr), r.items)
+
+		tr.RemoveWhere([]float64{-50, -50}, []float64{0, 0}, nil)
+		tr.RemoveAll(objs[300:400])
+		tr.Load([]rbush.Item{makeRandom("rect", 2), makeRandom("rect", 2)})
+		assert.Equal(t, itemSet(tr), r.items)
+
+		// staged changes are reported on commit
+		tx := tr.Begin()
+		for i := 400; i < 500; i++ {
+			tx.Update(objs[i], makeRandom("rect", 2))
+		}
+		tx.Insert(makeRandom("rect", 2))
+		assert.Equal(t, 100, r.updates)
+		tr.Insert(makeRandom("rect", 2))
+		tx.Commit()
+		assert.Equal(t, itemSet(tr), r.items)
+		tx = tr.Begin()
+		tx.Insert(makeRandom("rect", 2))
+		tx.Rollback()
+		assert.Equal(t, itemSet(tr), r.items)
+
+		tr.Unobserve(r)
+		tr.Insert(makeRandom("rect", 2))
+		assert.Equal(t, tr.Count()-1, len(r.items))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	tr := rbush.New(2)
+	ch := tr.Events(10)
+	a, b := makeRandom("rect", 2), makeRandom("rect", 2)
+	tr.Insert(a)
+	tr.Update(a, b)
+	tr.Remove(a)
+	tr.Remove(b)
+	assert.Equal(t, rbush.Event{Type: rbush.EventInserted, Item: a}, <-ch)
+	assert.Equal(t, rbush.Event{Type: rbush.EventUpdated, Item: b, Old: a}, <-ch)
+	assert.Equal(t, rbush.Event{Type: rbush.EventRemoved, Item: b}, <-ch)
+	assert.Equal(t, "updated", rbush.EventUpdated.String())
+
+	// a full channel blocks the change until it is read from
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 20; i++ {
+			tr.Insert(makeRandom("rect", 2))
+		}
+		tr.StopEvents(ch)
+		done <- true
+	}()
+	var n int
+	for range ch {
+		n++
+	}
+	<-done
+	assert.Equal(t, 20, n)
+	tr.Insert(makeRandom("rect", 2))
+}
@@ -0,0 +1,779 @@
+package rbush
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/tidwall/tinyqueue"
+)
+
+// PageCodec converts items to and from the fixed size payloads that a Paged
+// tree stores next to each item box.
+type PageCodec interface {
+	// EncodeItem writes the payload of item into dst, which has exactly
+	// PayloadSize bytes.
+	EncodeItem(dst []byte, item Item) error
+	// DecodeItem returns the item for a box and its payload.
+	DecodeItem(min, max []float64, payload []byte) (Item, error)
+}
+
+// PagedOptions for OpenPaged. The page size and payload size of an existing
+// file are read from the file.
+type PagedOptions struct {
+	// PageSize is the size of each node page in bytes. Default is 4096.
+	PageSize int
+	// PayloadSize is the size of each item payload in bytes. Default is 8.
+	PayloadSize int
+	// CacheSize is the number of pages kept in the buffer pool. Default is
+	// 1024.
+	CacheSize int
+	// Codec converts items to and from payloads. Required.
+	Codec PageCodec
+}
+
+// ErrInvalidPaged is returned when a file does not hold a Paged tree.
+var ErrInvalidPaged = errors.New("invalid paged tree file")
+
+const pagedMagic = "RBPAGED1"
+const pagedHeaderSize = 56
+const pageHeaderSize = 8
+
+// Paged is an R-tree where each node is a fixed size page in a file. Hot
+// pages are kept in an LRU buffer pool, so trees that are larger than memory
+// can be used. The methods match RBush. Items are matched by their box and
+// payload bytes rather than by identity. Errors from the file are kept and
+// returned by Err, Flush and Close, and stop any further operations.
+type Paged struct {
+	file        *os.File
+	codec       PageCodec
+	dims        int
+	pageSize    int
+	payloadSize int
+	maxLeaf     int
+	minLeaf     int
+	maxBranch   int
+	minBranch   int
+
+	root   uint64
+	height int
+	count  int
+	next   uint64 // first page that was never allocated
+	free   uint64 // head of the free page list, or zero
+
+	pool     map[uint64]*page
+	lru      *list.List
+	capacity int
+	hold     bool // no eviction while a mutation is in progress
+	err      error
+}
+
+type page struct {
+	id      uint64
+	leaf    bool
+	height  int
+	entries []pageEntry
+	dirty   bool
+	elem    *list.Element
+}
+
+type pageEntry struct {
+	min, max []float64
+	child    uint64 // branch pages
+	payload  []byte // leaf pages
+}
+
+// pagedError carries file errors out of the tree algorithms, up to the
+// exported method that recovers it.
+type pagedError struct{ err error }
+
+// OpenPaged opens a paged tree from a file, creating it when it does not
+// exist.
+func OpenPaged(path string, dims int, opts *PagedOptions) (*Paged, error) {
+	if opts == nil || opts.Codec == nil {
+		return nil, errors.New("codec is required")
+	}
+	p := &Paged{
+		codec:       opts.Codec,
+		dims:        dims,
+		pageSize:    4096,
+		payloadSize: 8,
+		capacity:    1024,
+		pool:        make(map[uint64]*page),
+		lru:         list.New(),
+	}
+	if opts.PageSize > 0 {
+		p.pageSize = opts.PageSize
+	}
+	if opts.PayloadSize > 0 {
+		p.payloadSize = opts.PayloadSize
+	}
+	if opts.CacheSize > 0 {
+		p.capacity = opts.CacheSize
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	p.file = f
+	fi, err := f.Stat()
+	if err == nil {
+		if fi.Size() == 0 {
+			err = p.create()
+		} else {
+			err = p.readHeader()
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Paged) create() error {
+	if p.pageSize < pagedHeaderSize {
+		return errors.New("page size is too small")
+	}
+	if err := p.setCapacity(); err != nil {
+		return err
+	}
+	p.next = 1
+	root := p.alloc(true, 1)
+	p.root = root.id
+	p.height = 1
+	return p.Flush()
+}
+
+func (p *Paged) setCapacity() error {
+	space := p.pageSize - pageHeaderSize
+	p.maxLeaf = space / (p.dims*16 + p.payloadSize)
+	p.maxBranch = space / (p.dims*16 + 8)
+	if p.maxLeaf > math.MaxUint16 {
+		p.maxLeaf = math.MaxUint16
+	}
+	if p.maxBranch > math.MaxUint16 {
+		p.maxBranch = math.MaxUint16
+	}
+	if p.maxLeaf < 4 || p.maxBranch < 4 {
+		return errors.New("page size is too small")
+	}
+	p.minLeaf = int(mathMax(2, math.Ceil(float64(p.maxLeaf)*0.4)))
+	p.minBranch = int(mathMax(2, math.Ceil(float64(p.maxBranch)*0.4)))
+	return nil
+}
+
+func (p *Paged) readHeader() error {
+	var h [pagedHeaderSize]byte
+	if _, err := p.file.ReadAt(h[:], 0); err != nil {
+		if err == io.EOF {
+			return ErrInvalidPaged
+		}
+		return err
+	}
+	if string(h[:8]) != pagedMagic {
+		return ErrInvalidPaged
+	}
+	if int(binary.LittleEndian.Uint32(h[8:])) != p.dims {
+		return errors.New("file dimensions does not match tree dimensions")
+	}
+	p.pageSize = int(binary.LittleEndian.Uint32(h[12:]))
+	p.payloadSize = int(binary.LittleEndian.Uint32(h[16:]))
+	p.height = int(binary.LittleEndian.Uint32(h[20:]))
+	p.root = binary.LittleEndian.Uint64(h[24:])
+	p.count = int(binary.LittleEndian.Uint64(h[32:]))
+	p.next = binary.LittleEndian.Uint64(h[40:])
+	p.free = binary.LittleEndian.Uint64(h[48:])
+	if p.pageSize < pagedHeaderSize || p.root == 0 || p.root >= p.next {
+		return ErrInvalidPaged
+	}
+	return p.setCapacity()
+}
+
+func (p *Paged) writeHeader() error {
+	h := make([]byte, p.pageSize)
+	copy(h, pagedMagic)
+	binary.LittleEndian.PutUint32(h[8:], uint32(p.dims))
+	binary.LittleEndian.PutUint32(h[12:], uint32(p.pageSize))
+	binary.LittleEndian.PutUint32(h[16:], uint32(p.payloadSize))
+	binary.LittleEndian.PutUint32(h[20:], uint32(p.height))
+	binary.LittleEndian.PutUint64(h[24:], p.root)
+	binary.LittleEndian.PutUint64(h[32:], uint64(p.count))
+	binary.LittleEndian.PutUint64(h[40:], p.next)
+	binary.LittleEndian.PutUint64(h[48:], p.free)
+	_, err := p.file.WriteAt(h, 0)
+	return err
+}
+
+func (p *Paged) fail(err error) {
+	panic(pagedError{err})
+}
+
+// recover turns a file error raised by fail into the sticky error.
+func (p *Paged) recover() {
+	p.hold = false
+	if v := recover(); v != nil {
+		perr, ok := v.(pagedError)
+		if !ok {
+			panic(v)
+		}
+		if p.err == nil {
+			p.err = perr.err
+		}
+	}
+}
+
+// get returns the page with id, reading it into the buffer pool if needed.
+func (p *Paged) get(id uint64) *page {
+	if pg, ok := p.pool[id]; ok {
+		p.lru.MoveToFront(pg.elem)
+		return pg
+	}
+	if id == 0 || id >= p.next {
+		p.fail(ErrInvalidPaged)
+	}
+	data := make([]byte, p.pageSize)
+	if _, err := p.file.ReadAt(data, int64(id)*int64(p.pageSize)); err != nil {
+		p.fail(err)
+	}
+	pg := p.decode(id, data)
+	pg.elem = p.lru.PushFront(pg)
+	p.pool[id] = pg
+	p.trim()
+	return pg
+}
+
+// trim evicts the least recently used pages, writing them when dirty.
+func (p *Paged) trim() {
+	if p.hold {
+		return
+	}
+	for p.lru.Len() > p.capacity {
+		pg := p.lru.Back().Value.(*page)
+		if pg.dirty {
+			p.write(pg)
+		}
+		p.lru.Remove(pg.elem)
+		delete(p.pool, pg.id)
+	}
+}
+
+func (p *Paged) alloc(leaf bool, height int) *page {
+	var id uint64
+	if p.free != 0 {
+		id = p.free
+		var next [8]byte
+		if _, err := p.file.ReadAt(next[:], int64(id)*int64(p.pageSize)); err != nil {
+			p.fail(err)
+		}
+		p.free = binary.LittleEndian.Uint64(next[:])
+	} else {
+		id = p.next
+		p.next++
+	}
+	pg := &page{id: id, leaf: leaf, height: height, dirty: true}
+	pg.elem = p.lru.PushFront(pg)
+	p.pool[id] = pg
+	return pg
+}
+
+// release puts a page on the free list.
+func (p *Paged) release(pg *page) {
+	p.lru.Remove(pg.elem)
+	delete(p.pool, pg.id)
+	data := make([]byte, p.pageSize)
+	binary.LittleEndian.PutUint64(data, p.free)
+	if _, err := p.file.WriteAt(data, int64(pg.id)*int64(p.pageSize)); err != nil {
+		p.fail(err)
+	}
+	p.free = pg.id
+}
+
+func (p *Paged) write(pg *page) {
+	data := make([]byte, p.pageSize)
+	if pg.leaf {
+		data[0] = 1
+	}
+	binary.LittleEndian.PutUint16(data[2:], uint16(len(pg.entries)))
+	binary.LittleEndian.PutUint32(data[4:], uint32(pg.height))
+	b := data[pageHeaderSize:]
+	for _, e := range pg.entries {
+		for i := 0; i < p.dims; i++ {
+			binary.LittleEndian.PutUint64(b, math.Float64bits(e.min[i]))
+			binary.LittleEndian.PutUint64(b[p.dims*8:], math.Float64bits(e.max[i]))
+			b = b[8:]
+		}
+		b = b[p.dims*8:]
+		if pg.leaf {
+			b = b[copy(b, e.payload):]
+		} else {
+			binary.LittleEndian.PutUint64(b, e.child)
+			b = b[8:]
+		}
+	}
+	if _, err := p.file.WriteAt(data, int64(pg.id)*int64(p.pageSize)); err != nil {
+		p.fail(err)
+	}
+	pg.dirty = false
+}
+
+func (p *Paged) decode(id uint64, data []byte) *page {
+	pg := &page{id: id, leaf: data[0] == 1}
+	n := int(binary.LittleEndian.Uint16(data[2:]))
+	pg.height = int(binary.LittleEndian.Uint32(data[4:]))
+	max, size := p.maxBranch, p.dims*16+8
+	if pg.leaf {
+		max, size = p.maxLeaf, p.dims*16+p.payloadSize
+	}
+	if n > max+1 || pageHeaderSize+n*size > len(data) {
+		p.fail(ErrInvalidPaged)
+	}
+	pg.entries = make([]pageEntry, n)
+	b := data[pageHeaderSize:]
+	for j := range pg.entries {
+		e := &pg.entries[j]
+		e.min = make([]float64, p.dims)
+		e.max = make([]float64, p.dims)
+		for i := 0; i < p.dims; i++ {
+			e.min[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+			e.max[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[p.dims*8:]))
+			b = b[8:]
+		}
+		b = b[p.dims*8:]
+		if pg.leaf {
+			e.payload = append([]byte(nil), b[:p.payloadSize]...)
+			b = b[p.payloadSize:]
+		} else {
+			e.child = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		}
+	}
+	return pg
+}
+
+// Err returns the first error that occurred.
+func (p *Paged) Err() error {
+	return p.err
+}
+
+// Flush writes all modified pages and the header to the file, and syncs it.
+func (p *Paged) Flush() (err error) {
+	if p.err != nil {
+		return p.err
+	}
+	defer func() { err = p.err }()
+	defer p.recover()
+	for _, pg := range p.pool {
+		if pg.dirty {
+			p.write(pg)
+		}
+	}
+	if err := p.writeHeader(); err != nil {
+		p.fail(err)
+	}
+	if err := p.file.Sync(); err != nil {
+		p.fail(err)
+	}
+	return nil
+}
+
+// Close flushes and closes the file.
+func (p *Paged) Close() error {
+	err := p.Flush()
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (p *Paged) Count() int {
+	return p.count
+}
+
+func (p *Paged) Bounds() (min, max []float64) {
+	min, max = make([]float64, p.dims), make([]float64, p.dims)
+	if p.err != nil || p.count == 0 {
+		return min, max
+	}
+	defer p.recover()
+	bounds(p.get(p.root), min, max)
+	return min, max
+}
+
+// bounds sets min and max to the union of the page entries.
+func bounds(pg *page, min, max []float64) {
+	for i := range min {
+		min[i], max[i] = mathInfPos, mathInfNeg
+	}
+	for _, e := range pg.entries {
+		for i := range min {
+			min[i] = mathMin(min[i], e.min[i])
+			max[i] = mathMax(max[i], e.max[i])
+		}
+	}
+}
+
+func (p *Paged) entry(item Item) pageEntry {
+	if item == nil {
+		panic("item is nil")
+	}
+	min, max := item.Rect()
+	if len(min) != len(max) || len(min) != p.dims {
+		panic("item dimensions does not match tree dimensions")
+	}
+	for i := 0; i < len(min); i++ {
+		if math.IsNaN(min[i]) || math.IsNaN(max[i]) {
+			panic("item has NaN coordinates")
+		}
+	}
+	e := pageEntry{
+		min:     append([]float64(nil), min...),
+		max:     append([]float64(nil), max...),
+		payload: make([]byte, p.payloadSize),
+	}
+	if err := p.codec.EncodeItem(e.payload, item); err != nil {
+		p.fail(err)
+	}
+	return e
+}
+
+// Insert adds the item. Like RBush.Insert, it panics when the item has NaN
+// coordinates.
+func (p *Paged) Insert(item Item) {
+	if p.err != nil {
+		return
+	}
+	defer p.recover()
+	e := p.entry(item)
+	p.hold = true
+	p.insertEntry(e, 1)
+	p.count++
+	p.hold = false
+	p.trim()
+}
+
+// insertEntry adds the entry to a page at height, splitting pages upwards
+// as needed.
+func (p *Paged) insertEntry(e pageEntry, height int) {
+	if height > p.height {
+		// the tree is too short for the entry, insert its children instead
+		child := p.get(e.child)
+		for _, ce := range child.entries {
+			p.insertEntry(ce, child.height)
+		}
+		p.release(child)
+		return
+	}
+	var path []*page
+	var indexes []int
+	pg := p.get(p.root)
+	for pg.height > height {
+		i := chooseEntry(pg, &e)
+		path = append(path, pg)
+		indexes = append(indexes, i)
+		pg = p.get(pg.entries[i].child)
+	}
+	pg.entries = append(pg.entries, e)
+	pg.dirty = true
+	for {
+		var sibling *page
+		if len(pg.entries) > p.maxEntries(pg) {
+			sibling = p.split(pg)
+		}
+		if len(path) == 0 {
+			if sibling != nil {
+				root := p.alloc(false, pg.height+1)
+				root.entries = []pageEntry{p.branchEntry(pg), p.branchEntry(sibling)}
+				p.root = root.id
+				p.height = root.height
+			}
+			return
+		}
+		parent := path[len(path)-1]
+		i := indexes[len(indexes)-1]
+		path, indexes = path[:len(path)-1], indexes[:len(indexes)-1]
+		bounds(pg, parent.entries[i].min, parent.entries[i].max)
+		if sibling != nil {
+			parent.entries = append(parent.entries, p.branchEntry(sibling))
+		}
+		parent.dirty = true
+		pg = parent
+	}
+}
+
+func (p *Paged) maxEntries(pg *page) int {
+	if pg.leaf {
+		return p.maxLeaf
+	}
+	return p.maxBranch
+}
+
+func (p *Paged) minEntries(pg *page) int {
+	if pg.leaf {
+		return p.minLeaf
+	}
+	return p.minBranch
+}
+
+func (p *Paged) branchEntry(pg *page) pageEntry {
+	e := pageEntry{
+		min:   make([]float64, p.dims),
+		max:   make([]float64, p.dims),
+		child: pg.id,
+	}
+	bounds(pg, e.min, e.max)
+	return e
+}
+
+// chooseEntry returns the entry needing the least area enlargement to hold
+// e, preferring the smallest area on ties.
+func chooseEntry(pg *page, e *pageEntry) int {
+	target := 0
+	minEnlargement, minArea := mathInfPos, mathInfPos
+	bbox := treeNode{min: e.min, max: e.max}
+	for i := range pg.entries {
+		child := treeNode{min: pg.entries[i].min, max: pg.entries[i].max}
+		area := child.area()
+		enlargement := bbox.enlargedArea(&child) - area
+		if enlargement < minEnlargement ||
+			(enlargement == minEnlargement && area < minArea) {
+			minEnlargement, minArea = enlargement, area
+			target = i
+		}
+	}
+	return target
+}
+
+// split moves part of the entries of an overflowing page to a new sibling
+// using the quadratic split.
+func (p *Paged) split(pg *page) *page {
+	bboxes := make([]treeNode, len(pg.entries))
+	for i := range pg.entries {
+		bboxes[i] = treeNode{min: pg.entries[i].min, max: pg.entries[i].max}
+	}
+	group := guttmanGroups(bboxes, p.minEntries(pg), p.dims, SplitQuadratic)
+	sibling := p.alloc(pg.leaf, pg.height)
+	var keep []pageEntry
+	for i, e := range pg.entries {
+		if group[i] == 1 {
+			keep = append(keep, e)
+		} else {
+			sibling.entries = append(sibling.entries, e)
+		}
+	}
+	pg.entries = keep
+	pg.dirty = true
+	return sibling
+}
+
+// Remove removes an item with the same box and payload.
+func (p *Paged) Remove(item Item) {
+	if p.err != nil {
+		return
+	}
+	defer p.recover()
+	e := p.entry(item)
+	p.hold = true
+	var path []*page
+	var indexes []int
+	if leaf, i := p.findEntry(p.get(p.root), &e, &path, &indexes); leaf != nil {
+		copy(leaf.entries[i:], leaf.entries[i+1:])
+		leaf.entries = leaf.entries[:len(leaf.entries)-1]
+		leaf.dirty = true
+		p.count--
+		p.condense(leaf, path, indexes)
+	}
+	p.hold = false
+	p.trim()
+}
+
+func (p *Paged) findEntry(pg *page, e *pageEntry, path *[]*page, indexes *[]int) (*page, int) {
+	bbox := treeNode{min: e.min, max: e.max}
+	if pg.leaf {
+		for i := range pg.entries {
+			x := &pg.entries[i]
+			if floatsEqual(x.min, e.min) && floatsEqual(x.max, e.max) &&
+				bytes.Equal(x.payload, e.payload) {
+				return pg, i
+			}
+		}
+		return nil, -1
+	}
+	for i := range pg.entries {
+		child := treeNode{min: pg.entries[i].min, max: pg.entries[i].max}
+		if !child.contains(&bbox) {
+			continue
+		}
+		*path = append(*path, pg)
+		*indexes = append(*indexes, i)
+		if leaf, j := p.findEntry(p.get(pg.entries[i].child), e, path, indexes); leaf != nil {
+			return leaf, j
+		}
+		*path = (*path)[:len(*path)-1]
+		*indexes = (*indexes)[:len(*indexes)-1]
+	}
+	return nil, -1
+}
+
+func floatsEqual(a, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type pageOrphan struct {
+	entry  pageEntry
+	height int
+}
+
+// condense dissolves underfull pages on the path from the root to pg,
+// then reinserts their entries.
+func (p *Paged) condense(pg *page, path []*page, indexes []int) {
+	var orphans []pageOrphan
+	for len(path) > 0 {
+		parent := path[len(path)-1]
+		i := indexes[len(indexes)-1]
+		path, indexes = path[:len(path)-1], indexes[:len(indexes)-1]
+		if len(pg.entries) < p.minEntries(pg) {
+			for _, e := range pg.entries {
+				orphans = append(orphans, pageOrphan{e, pg.height})
+			}
+			copy(parent.entries[i:], parent.entries[i+1:])
+			parent.entries = parent.entries[:len(parent.entries)-1]
+			p.release(pg)
+		} else {
+			bounds(pg, parent.entries[i].min, parent.entries[i].max)
+		}
+		parent.dirty = true
+		pg = parent
+	}
+	for !pg.leaf && len(pg.entries) == 1 {
+		child := p.get(pg.entries[0].child)
+		p.release(pg)
+		pg = child
+		p.root = pg.id
+		p.height = pg.height
+	}
+	if !pg.leaf && len(pg.entries) == 0 {
+		pg.leaf = true
+		pg.height = 1
+		p.height = 1
+		pg.dirty = true
+	}
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return orphans[i].height > orphans[j].height
+	})
+	for _, o := range orphans {
+		p.insertEntry(o.entry, o.height)
+	}
+}
+
+func (p *Paged) Search(bbox Item, iter func(item Item) bool) (ok bool) {
+	if bbox == nil {
+		panic("bbox is nil")
+	}
+	min, max := bbox.Rect()
+	if len(min) != len(max) || len(min) != p.dims {
+		panic("bbox dimensions does not match tree dimensions")
+	}
+	if p.err != nil {
+		return false
+	}
+	defer func() {
+		if p.err != nil {
+			ok = false
+		}
+	}()
+	defer p.recover()
+	return p.search(p.get(p.root), &treeNode{min: min, max: max}, iter)
+}
+
+func (p *Paged) search(pg *page, bbox *treeNode, iter func(item Item) bool) bool {
+	for i := range pg.entries {
+		e := &pg.entries[i]
+		if !bbox.intersects(&treeNode{min: e.min, max: e.max}) {
+			continue
+		}
+		if pg.leaf {
+			item, err := p.codec.DecodeItem(e.min, e.max, e.payload)
+			if err != nil {
+				p.fail(err)
+			}
+			if !iter(item) {
+				return false
+			}
+		} else if !p.search(p.get(e.child), bbox, iter) {
+			return false
+		}
+	}
+	return true
+}
+
+type pagedQueueItem struct {
+	entry  pageEntry
+	isItem bool
+	dist   float64
+}
+
+func (item *pagedQueueItem) Less(b tinyqueue.Item) bool {
+	return item.dist < b.(*pagedQueueItem).dist
+}
+
+func (p *Paged) KNN(point []float64, iter func(item Item, dist float64) bool) (ok bool) {
+	if p.err != nil {
+		return false
+	}
+	defer func() {
+		if p.err != nil {
+			ok = false
+		}
+	}()
+	defer p.recover()
+	pg := p.get(p.root)
+	queue := tinyqueue.New(nil)
+	for pg != nil {
+		for _, e := range pg.entries {
+			queue.Push(&pagedQueueItem{
+				entry:  e,
+				isItem: pg.leaf,
+				dist:   boxDist(point, e.min, e.max),
+			})
+		}
+		for queue.Len() > 0 && queue.Peek().(*pagedQueueItem).isItem {
+			qi := queue.Pop().(*pagedQueueItem)
+			item, err := p.codec.DecodeItem(qi.entry.min, qi.entry.max, qi.entry.payload)
+			if err != nil {
+				p.fail(err)
+			}
+			if !iter(item, qi.dist) {
+				return false
+			}
+		}
+		if last := queue.Pop(); last != nil {
+			pg = p.get(last.(*pagedQueueItem).entry.child)
+		} else {
+			pg = nil
+		}
+	}
+	return true
+}
+
+func (p *Paged) Scan(iter func(item Item) bool) bool {
+	min, max := make([]float64, p.dims), make([]float64, p.dims)
+	for i := range min {
+		min[i], max[i] = mathInfNeg, mathInfPos
+	}
+	return p.Search(&pagedBox{min, max}, iter)
+}
+
+type pagedBox struct{ min, max []float64 }
+
+func (b *pagedBox) Rect() (min, max []float64) { return b.min, b.max }
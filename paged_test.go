@@ -0,0 +1,181 @@
+package rbush_test
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+type idRect struct {
+	min, max []float64
+	id       uint64
+}
+
+func (r *idRect) Rect() (min, max []float64) {
+	return r.min, r.max
+}
+
+type idCodec struct{}
+
+func (idCodec) EncodeItem(dst []byte, item rbush.Item) error {
+	binary.LittleEndian.PutUint64(dst, item.(*idRect).id)
+	return nil
+}
+
+func (idCodec) DecodeItem(min, max []float64, payload []byte) (rbush.Item, error) {
+	return &idRect{min, max, binary.LittleEndian.Uint64(payload)}, nil
+}
+
+func TestPaged(t *testing.T) {
+	for dims := 1; dims <= 3; dims++ {
+		rand.Seed(time.Now().UnixNano())
+		path := filepath.Join(t.TempDir(), "paged.db")
+		opts := &rbush.PagedOptions{PageSize: 512, CacheSize: 16, Codec: idCodec{}}
+		tr, err := rbush.OpenPaged(path, dims, opts)
+		assert.NoError(t, err)
+		objs := make([]rbush.Item, 20000)
+		for i := range objs {
+			min, max := makeRandom("rect", dims).Rect()
+			objs[i] = &idRect{min, max, uint64(i)}
+			tr.Insert(objs[i])
+		}
+		assert.NoError(t, tr.Err())
+		testPaged(t, tr, objs)
+		assert.NoError(t, tr.Close())
+
+		tr, err = rbush.OpenPaged(path, dims, opts)
+		assert.NoError(t, err)
+		testPaged(t, tr, objs)
+		perm := rand.Perm(len(objs))
+		var remain []rbush.Item
+		for i, j := range perm {
+			if i < len(perm)*3/4 {
+				tr.Remove(objs[j])
+			} else {
+				remain = append(remain, objs[j])
+			}
+		}
+		assert.NoError(t, tr.Err())
+		testPaged(t, tr, remain)
+		assert.NoError(t, tr.Close())
+
+		tr, err = rbush.OpenPaged(path, dims, opts)
+		assert.NoError(t, err)
+		testPaged(t, tr, remain)
+		for _, obj := range remain {
+			tr.Remove(obj)
+		}
+		assert.Equal(t, 0, tr.Count())
+		// the freed pages are used again
+		for _, obj := range objs[:1000] {
+			tr.Insert(obj)
+		}
+		testPaged(t, tr, objs[:1000])
+		assert.NoError(t, tr.Close())
+
+		_, err = rbush.OpenPaged(path, dims+1, opts)
+		assert.Error(t, err)
+	}
+}
+
+func testPaged(t *testing.T, tr *rbush.Paged, objs []rbush.Item) {
+	dims := len(objs[0].(*idRect).min)
+	mem := rbush.New(dims)
+	for _, obj := range objs {
+		mem.Insert(obj)
+	}
+	assert.Equal(t, mem.Count(), tr.Count())
+	min1, max1 := mem.Bounds()
+	min2, max2 := tr.Bounds()
+	assert.Equal(t, min1, min2)
+	assert.Equal(t, max1, max2)
+	for _, percent := range []float64{0.10, 0.50} {
+		values := make([]float64, dims*2)
+		for i := 0; i < dims; i++ {
+			values[i] = (max1[i]+min1[i])/2 - (max1[i]-min1[i])*percent/2
+			values[dims+i] = (max1[i]+min1[i])/2 + (max1[i]-min1[i])*percent/2
+		}
+		box := makeRect(values...)
+		var a1, a2 []int
+		mem.Search(box, func(item rbush.Item) bool {
+			a1 = append(a1, int(item.(*idRect).id))
+			return true
+		})
+		assert.True(t, tr.Search(box, func(item rbush.Item) bool {
+			a2 = append(a2, int(item.(*idRect).id))
+			return true
+		}))
+		sort.Ints(a1)
+		sort.Ints(a2)
+		assert.Equal(t, a1, a2)
+	}
+	center := make([]float64, dims)
+	for i := range center {
+		center[i] = (max1[i] + min1[i]) / 2
+	}
+	var d1, d2 []float64
+	mem.KNN(center, func(item rbush.Item, dist float64) bool {
+		d1 = append(d1, dist)
+		return len(d1) < 100
+	})
+	tr.KNN(center, func(item rbush.Item, dist float64) bool {
+		d2 = append(d2, dist)
+		return len(d2) < 100
+	})
+	assert.Equal(t, d1, d2)
+}
+
+func TestPagedClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paged.db")
+	opts := &rbush.PagedOptions{PageSize: 512, CacheSize: 16, Codec: idCodec{}}
+	for _, fn := range []func(tr *rbush.Paged) bool{
+		func(tr *rbush.Paged) bool { return tr.Flush() == nil },
+		func(tr *rbush.Paged) bool {
+			return tr.Scan(func(item rbush.Item) bool { return true })
+		},
+		func(tr *rbush.Paged) bool {
+			return tr.Search(makeRect(0, 0, 1000, 1000), func(item rbush.Item) bool { return true })
+		},
+		func(tr *rbush.Paged) bool {
+			return tr.KNN([]float64{0, 0}, func(item rbush.Item, dist float64) bool { return true })
+		},
+	} {
+		tr, err := rbush.OpenPaged(path, 2, opts)
+		assert.NoError(t, err)
+		for i := 0; i < 2000; i++ {
+			x, y := float64(i%50), float64(i/50)
+			tr.Insert(&idRect{[]float64{x, y}, []float64{x, y}, uint64(i)})
+		}
+		assert.NoError(t, tr.Close())
+		// the file errors are returned instead of panicking
+		assert.False(t, fn(tr))
+		assert.Error(t, tr.Err())
+	}
+}
+
+func TestNaN(t *testing.T) {
+	nan := makeRect(0, math.NaN(), 1, 1)
+	assert.PanicsWithValue(t, "item has NaN coordinates", func() {
+		rbush.New(2).Insert(nan)
+	})
+	assert.PanicsWithValue(t, "item has NaN coordinates", func() {
+		rbush.NewPacked(2, []rbush.Item{makeRect(0, 0, 1, 1), nan}, nil)
+	})
+	assert.PanicsWithValue(t, "item has NaN coordinates", func() {
+		rbush.NewStatic(2, []float64{0, math.NaN(), 1, 1}, 0)
+	})
+	p, err := rbush.OpenPaged(filepath.Join(t.TempDir(), "paged.db"), 2, &rbush.PagedOptions{Codec: idCodec{}})
+	assert.NoError(t, err)
+	defer p.Close()
+	assert.PanicsWithValue(t, "item has NaN coordinates", func() {
+		p.Insert(&idRect{[]float64{0, math.NaN()}, []float64{1, 1}, 1})
+	})
+	assert.Equal(t, 0, p.Count())
+}
@@ -27,6 +27,10 @@ type treeNode struct {
 	children []interface{}
 	leaf     bool
 	height   int
+	lhv      uint64      // largest hilbert value, hilbert trees only
+	gen      uint64      // generation of the tree that owns the node
+	count    int         // number of items, trees with aggregates only
+	summary  interface{} // summary of the items, trees with an aggregator only
 }
 
 func (a *treeNode) extend(b *treeNode) {
@@ -111,15 +115,66 @@ type RBush struct {
 	minEntries int
 	data       *treeNode
 	reusePath  []*treeNode
+	insertion  InsertStrategy
+	splitting  SplitStrategy
+	hilbert    *hilbertSpace
+	gen        uint64 // nodes of other generations are copied on write
+	reinserted uint64 // heights that had a forced reinsert
+	observers  []Observer
+	aggregates bool
+	aggregator Aggregator
+}
+
+// Options for creating an RBush with NewOptions.
+type Options struct {
+	// MaxEntries is the maximum number of entries in a node. Default is 9.
+	MaxEntries int
+	// Insertion selects the strategy used to insert new entries.
+	Insertion InsertStrategy
+	// Split selects the algorithm used to split overflowing nodes.
+	Split SplitStrategy
+	// Hilbert makes a Hilbert R-tree, where items are kept ordered by the
+	// Hilbert value of their centers and overflowing nodes share entries
+	// with a sibling before they split two into three. The Insertion and
+	// Split options are ignored. Meant for 2-D and 3-D trees.
+	Hilbert bool
+	// HilbertMin and HilbertMax are the bounds of the space covered by the
+	// Hilbert curve, and are required for a Hilbert tree. Item centers
+	// outside of the bounds are clamped.
+	HilbertMin, HilbertMax []float64
+	// Packing selects how NewPacked builds the tree.
+	Packing Packing
+	// Aggregates keeps the number of items below every node, which lets
+	// CountIn skip the nodes that are inside of the box.
+	Aggregates bool
+	// Aggregator keeps a summary of the items below every node for
+	// Aggregate. Implies Aggregates.
+	Aggregator Aggregator
 }
 
 func New(dims int) *RBush {
+	return NewOptions(dims, nil)
+}
+
+func NewOptions(dims int, opts *Options) *RBush {
 	maxEntries := 9
+	if opts != nil && opts.MaxEntries > 0 {
+		maxEntries = opts.MaxEntries
+	}
 	tr := &RBush{}
 	tr.dims = dims
 	tr.maxEntries = int(mathMax(4, float64(maxEntries)))
 	tr.minEntries = int(mathMax(2, math.Ceil(float64(tr.maxEntries)*0.4)))
 	tr.data = createNode(nil, dims)
+	if opts != nil {
+		tr.insertion = opts.Insertion
+		tr.splitting = opts.Split
+		if opts.Hilbert {
+			tr.hilbert = newHilbertSpace(dims, opts.HilbertMin, opts.HilbertMax)
+		}
+		tr.aggregates = opts.Aggregates || opts.Aggregator != nil
+		tr.aggregator = opts.Aggregator
+	}
 	return tr
 }
 
@@ -140,30 +195,58 @@ func createNode(children []interface{}, dims int) *treeNode {
 func fillBBox(item Item, bbox *treeNode) {
 	bbox.min, bbox.max = item.Rect()
 }
+
+// Insert adds an item to the tree. It panics when the item has NaN
+// coordinates, as they can not be ordered, searched or removed, and the
+// same goes for Update, Load and NewPacked.
 func (tr *RBush) Insert(item Item) {
+	min, max := tr.checkItem(item)
+	tr.insertBBox(item, min, max)
+	tr.notify(nil, item, false)
+}
+
+// checkItem panics when the item can not be put in the tree.
+func (tr *RBush) checkItem(item Item) (min, max []float64) {
 	if item == nil {
 		panic("item is nil")
 	}
-	min, max := item.Rect()
+	min, max = item.Rect()
 	if len(min) != len(max) || len(min) != tr.dims {
 		panic("item dimensions does not match tree dimensions")
 	}
-	tr.insertBBox(item, min, max)
+	for i := 0; i < len(min); i++ {
+		if math.IsNaN(min[i]) || math.IsNaN(max[i]) {
+			panic("item has NaN coordinates")
+		}
+	}
+	return min, max
 }
+
 func (tr *RBush) insertBBox(item Item, min, max []float64) {
 	var bbox treeNode
 	bbox.min = min
 	bbox.max = max
+	if tr.hilbert != nil {
+		tr.hilbertInsert(&bbox, item)
+		return
+	}
+	tr.reinserted = 0
 	tr.insert(&bbox, item, tr.data.height-1, false)
 }
 
-func (tr *RBush) insert(bbox *treeNode, item Item, level int, isNode bool) {
+func (tr *RBush) insert(bbox *treeNode, item interface{}, level int, isNode bool) {
 	tr.reusePath = tr.reusePath[:0]
+	tr.data = tr.own(tr.data)
 	node, insertPath := tr.chooseSubtree(bbox, tr.data, level, tr.reusePath)
 	node.children = append(node.children, item)
 	node.extend(bbox)
 	for level >= 0 {
 		if len(insertPath[level].children) > tr.maxEntries {
+			if tr.insertion == InsertRStar && level > 0 &&
+				tr.reinserted&(1<<uint(insertPath[level].height)) == 0 {
+				tr.forceReinsert(insertPath, level)
+				return
+			}
 			insertPath = tr.split(insertPath, level)
 			level--
 		} else {
@@ -171,6 +254,9 @@ func (tr *RBush) insert(bbox *treeNode, item Item, level int, isNode bool) {
 		}
 	}
 	tr.adjustParentBBoxes(bbox, insertPath, level)
+	if tr.aggregates {
+		tr.addAggregates(item, isNode, insertPath, level)
+	}
 	tr.reusePath = insertPath
 }
 func (tr *RBush) adjustParentBBoxes(bbox *treeNode, path []*treeNode, level int) {
@@ -184,8 +270,13 @@ func (tr *RBush) split(insertPath []*treeNode, level int) []*treeNode {
 	var M = len(node.children)
 	var m = tr.minEntries
 
-	tr.chooseSplitAxis(node, m, M)
-	splitIndex := tr.chooseSplitIndex(node, m, M)
+	var splitIndex int
+	if tr.splitting == SplitLinear || tr.splitting == SplitQuadratic {
+		splitIndex = tr.guttmanSplit(node, m)
+	} else {
+		tr.chooseSplitAxis(node, m, M)
+		splitIndex = tr.chooseSplitIndex(node, m, M)
+	}
 
 	spliced := make([]interface{}, len(node.children)-splitIndex)
 	copy(spliced, node.children[splitIndex:])
@@ -194,9 +285,10 @@ func (tr *RBush) split(insertPath []*treeNode, level int) []*treeNode {
 	newNode := createNode(spliced, tr.dims)
 	newNode.height = node.height
 	newNode.leaf = node.leaf
+	newNode.gen = tr.gen
 
-	calcBBox(node, tr.dims)
-	calcBBox(newNode, tr.dims)
+	tr.refresh(node)
+	tr.refresh(newNode)
 
 	if level != 0 {
 		insertPath[level-1].children = append(insertPath[level-1].children, newNode)
@@ -209,7 +301,8 @@ func (tr *RBush) splitRoot(node, newNode *treeNode) {
 	tr.data = createNode([]interface{}{node, newNode}, tr.dims)
 	tr.data.height = node.height + 1
 	tr.data.leaf = false
-	calcBBox(tr.data, tr.dims)
+	tr.data.gen = tr.gen
+	tr.refresh(tr.data)
 }
 func (tr *RBush) chooseSplitIndex(node *treeNode, m, M int) int {
 	var i int
@@ -219,6 +312,8 @@ func (tr *RBush) chooseSplitIndex(node *treeNode, m, M int) int {
 
 	minArea = mathInfPos
 	minOverlap = minArea
+	// used when no distribution compares, such as with infinite boxes
+	index = M - m
 
 	for i = m; i <= M-m; i++ {
 		bbox1 = distBBox(node, 0, i, nil, tr.dims)
@@ -343,6 +438,10 @@ func (tr *RBush) chooseSubtree(bbox, node *treeNode, level int, path []*treeNode
 		if node.leaf || len(path)-1 == level {
 			break
 		}
+		if tr.insertion == InsertRStar && node.height == 2 {
+			node = tr.ownChild(node, chooseLeastOverlap(bbox, node))
+			continue
+		}
 		minEnlargement = mathInfPos
 		minArea = minEnlargement
 		for _, ptr := range node.children {
@@ -363,9 +462,9 @@ func (tr *RBush) chooseSubtree(bbox, node *treeNode, level int, path []*treeNode
 			}
 		}
 		if targetNode != nil {
-			node = targetNode
+			node = tr.ownChild(node, targetNode)
 		} else if len(node.children) > 0 {
-			node = node.children[0].(*treeNode)
+			node = tr.ownChild(node, node.children[0].(*treeNode))
 		} else {
 			node = nil
 		}
@@ -373,6 +472,18 @@ func (tr *RBush) chooseSubtree(bbox, node *treeNode, level int, path []*treeNode
 	return node, path
 }
 
+// refresh recalculates the bbox, and any other summary, of a node from its
+// children.
+func (tr *RBush) refresh(node *treeNode) {
+	calcBBox(node, tr.dims)
+	if tr.hilbert != nil {
+		tr.hilbert.calcLHV(node)
+	}
+	if tr.aggregates {
+		tr.calcAggregates(node)
+	}
+}
+
 func calcBBox(node *treeNode, dims int) {
 	distBBox(node, 0, len(node.children), node, dims)
 }
@@ -445,6 +556,10 @@ func search(node, bbox *treeNode, iter func(item Item) bool) bool {
 }
 
 func (tr *RBush) Remove(item Item) {
+	tr.notify(item, nil, tr.remove(item))
+}
+
+func (tr *RBush) remove(item Item) bool {
 	if item == nil {
 		panic("item is nil")
 	}
@@ -452,10 +567,23 @@ func (tr *RBush) Remove(item Item) {
 	if len(min) != len(max) || len(min) != tr.dims {
 		panic("item dimensions does not match tree dimensions")
 	}
-	tr.removeBBox(item, min, max)
+	return tr.removeBBox(item, min, max)
 }
 
-func (tr *RBush) removeBBox(item Item, min, max []float64) {
+// Update replaces the old item with a new item. The new item is inserted
+// even when the old item is not in the tree.
+func (tr *RBush) Update(old, item Item) {
+	tr.notify(old, item, tr.update(old, item))
+}
+
+func (tr *RBush) update(old, item Item) bool {
+	min, max := tr.checkItem(item)
+	removed := tr.remove(old)
+	tr.insertBBox(item, min, max)
+	return removed
+}
+
+func (tr *RBush) removeBBox(item Item, min, max []float64) bool {
 	var bbox treeNode
 	bbox.min = min
 	bbox.max = max
@@ -487,12 +615,15 @@ func (tr *RBush) removeBBox(item Item, min, max []float64) {
 			index = findItem(item, node)
 			if index != -1 {
 				// item found, remove the item and condense tree upwards
+				path = append(path, node)
+				tr.ownPath(path)
+				node = path[len(path)-1]
 				copy(node.children[index:], node.children[index+1:])
 				node.children[len(node.children)-1] = nil
 				node.children = node.children[:len(node.children)-1]
-				path = append(path, node)
 				tr.condense(path)
-				goto done
+				tr.reusePath = path
+				return true
 			}
 		}
 		if !goingUp && !node.leaf && node.contains(&bbox) { // go down
@@ -513,36 +644,209 @@ func (tr *RBush) removeBBox(item Item, min, max []float64) {
 			node = nil
 		}
 	}
-done:
 	tr.reusePath = path
-	return
+	return false
 }
 func (tr *RBush) condense(path []*treeNode) {
-	// go through the path, removing empty nodes and updating bboxes
-	var siblings []interface{}
-	for i := len(path) - 1; i >= 0; i-- {
-		if len(path[i].children) == 0 {
-			if i > 0 {
-				siblings = path[i-1].children
-				index := -1
-				for j := 0; j < len(siblings); j++ {
-					if siblings[j] == path[i] {
-						index = j
-						break
-					}
+	if tr.hilbert != nil {
+		tr.hilbertCondense(path)
+		return
+	}
+	// go through the path, dissolving underfull nodes and updating bboxes
+	var orphans []interface{}
+	for i := len(path) - 1; i > 0; i-- {
+		if len(path[i].children) < tr.minEntries {
+			siblings := path[i-1].children
+			index := -1
+			for j := 0; j < len(siblings); j++ {
+				if siblings[j] == path[i] {
+					index = j
+					break
 				}
-				copy(siblings[index:], siblings[index+1:])
-				siblings[len(siblings)-1] = nil
-				siblings = siblings[:len(siblings)-1]
-				path[i-1].children = siblings
-			} else {
-				tr.data = createNode(nil, tr.dims) // clear tree
 			}
+			copy(siblings[index:], siblings[index+1:])
+			siblings[len(siblings)-1] = nil
+			siblings = siblings[:len(siblings)-1]
+			path[i-1].children = siblings
+			orphans = append(orphans, path[i].children...)
 		} else {
-			calcBBox(path[i], tr.dims)
+			tr.refresh(path[i])
 		}
 	}
+	tr.refresh(path[0])
+	tr.condenseRoot()
+	// the orphaned entries are reinserted at the level they came from
+	tr.reinsert(orphans)
 }
+
+// RemoveWhere removes all items that intersect the min/max box and for which
+// pred returns true. A nil pred removes every intersecting item. The tree is
+// walked once and condensed once. Returns the number of items removed.
+func (tr *RBush) RemoveWhere(min, max []float64, pred func(item Item) bool) int {
+	if len(min) != len(max) || len(min) != tr.dims {
+		panic("bbox dimensions does not match tree dimensions")
+	}
+	return tr.removeWhere(&treeNode{min: min, max: max}, pred)
+}
+
+// RemoveAll removes every occurrence of the provided items in a single pass.
+// Returns the number of items removed.
+func (tr *RBush) RemoveAll(items []Item) int {
+	if len(items) == 0 {
+		return 0
+	}
+	set := make(map[Item]bool, len(items))
+	bbox := createNode(nil, tr.dims)
+	for _, item := range items {
+		if item == nil {
+			panic("item is nil")
+		}
+		var child treeNode
+		fillBBox(item, &child)
+		if len(child.min) != len(child.max) || len(child.min) != tr.dims {
+			panic("item dimensions does not match tree dimensions")
+		}
+		bbox.extend(&child)
+		set[item] = true
+	}
+	return tr.removeWhere(bbox, func(item Item) bool { return set[item] })
+}
+
+func (tr *RBush) removeWhere(bbox *treeNode, pred func(item Item) bool) int {
+	if !tr.data.intersects(bbox) {
+		return 0
+	}
+	var removed []Item
+	if len(tr.observers) > 0 {
+		match := pred
+		pred = func(item Item) bool {
+			if match == nil || match(item) {
+				removed = append(removed, item)
+				return true
+			}
+			return false
+		}
+	}
+	var orphans []interface{}
+	var n int
+	tr.data, n = tr.removeMatches(tr.data, bbox, pred, &orphans)
+	if n == 0 {
+		return 0
+	}
+	tr.condenseRoot()
+	tr.reinsert(orphans)
+	for _, item := range removed {
+		tr.notify(item, nil, true)
+	}
+	return n
+}
+
+// removeMatches removes the matching items below node. Child nodes that fall
+// under minEntries are dissolved and their entries are added to orphans.
+// Returns the node, which is a copy when a shared node had to be changed.
+func (tr *RBush) removeMatches(node, bbox *treeNode, pred func(item Item) bool, orphans *[]interface{}) (*treeNode, int) {
+	var n, j int
+	if node.leaf {
+		for i := 0; i < len(node.children); i++ {
+			item := node.children[i].(Item)
+			var child treeNode
+			fillBBox(item, &child)
+			if bbox.intersects(&child) && (pred == nil || pred(item)) {
+				node = tr.own(node)
+				n++
+				continue
+			}
+			if n > 0 {
+				node.children[j] = item
+			}
+			j++
+		}
+	} else {
+		for i := 0; i < len(node.children); i++ {
+			child := node.children[i].(*treeNode)
+			if bbox.intersects(child) {
+				var c int
+				if child, c = tr.removeMatches(child, bbox, pred, orphans); c > 0 {
+					node = tr.own(node)
+					n += c
+					if len(child.children) < tr.minEntries {
+						*orphans = append(*orphans, child.children...)
+						continue
+					}
+				}
+			}
+			if n > 0 {
+				node.children[j] = child
+			}
+			j++
+		}
+	}
+	if n > 0 {
+		for i := j; i < len(node.children); i++ {
+			node.children[i] = nil
+		}
+		node.children = node.children[:j]
+		tr.refresh(node)
+	}
+	return node, n
+}
+
+// condenseRoot shortens the tree while the root has a single child, and
+// clears the tree when the root has no children.
+func (tr *RBush) condenseRoot() {
+	for !tr.data.leaf && len(tr.data.children) == 1 {
+		tr.data = tr.data.children[0].(*treeNode)
+	}
+	if len(tr.data.children) == 0 {
+		tr.data = createNode(nil, tr.dims)
+	}
+}
+
+// reinsert puts orphaned items and subtrees back into the tree. Subtrees are
+// inserted at the level matching their height, tallest first.
+func (tr *RBush) reinsert(orphans []interface{}) {
+	sort.SliceStable(orphans, func(i, j int) bool {
+		return entryHeight(orphans[i]) > entryHeight(orphans[j])
+	})
+	for _, entry := range orphans {
+		tr.reinsertEntry(entry)
+	}
+}
+
+func (tr *RBush) reinsertEntry(entry interface{}) {
+	node, ok := entry.(*treeNode)
+	if !ok {
+		item := entry.(Item)
+		min, max := item.Rect()
+		tr.insertBBox(item, min, max)
+		return
+	}
+	if tr.hilbert != nil {
+		// keep the hilbert order by inserting the items one by one
+		scan(node, func(item Item) bool {
+			tr.reinsertEntry(item)
+			return true
+		})
+	} else if len(tr.data.children) == 0 {
+		tr.data = node
+	} else if node.height < tr.data.height {
+		tr.reinserted = 0
+		tr.insert(node, node, tr.data.height-node.height-1, true)
+	} else {
+		// the tree shrank below the subtree, break it apart
+		for _, child := range node.children {
+			tr.reinsertEntry(child)
+		}
+	}
+}
+
+func entryHeight(entry interface{}) int {
+	if node, ok := entry.(*treeNode); ok {
+		return node.height
+	}
+	return 0
+}
+
 func findItem(item Item, node *treeNode) int {
 	for i := 0; i < len(node.children); i++ {
 		if node.children[i] == item {
@@ -552,6 +856,9 @@ func findItem(item Item, node *treeNode) int {
 	return -1
 }
 func (tr *RBush) Count() int {
+	if tr.aggregates {
+		return tr.data.count
+	}
 	return count(tr.data)
 }
 func count(node *treeNode) int {
@@ -364,6 +364,231 @@ func testHasSameItems(a1, a2 []rbush.Item) bool {
 	return true
 }
 
+func TestRemoveWhere(t *testing.T) {
+	for dims := 1; dims <= 5; dims++ {
+		rand.Seed(time.Now().UnixNano())
+		tr := rbush.New(dims)
+		objs := make([]rbush.Item, 10000)
+		for i := 0; i < len(objs); i++ {
+			objs[i] = makeRandom("rect", dims)
+			tr.Insert(objs[i])
+		}
+		min, max := make([]float64, dims), make([]float64, dims)
+		for i := 0; i < dims; i++ {
+			min[i], max[i] = -25, 25
+		}
+		box := &rect{min, max}
+		var remain []rbush.Item
+		var expect int
+		for i, obj := range objs {
+			if testIntersects(obj, box) && i%2 == 0 {
+				expect++
+			} else {
+				remain = append(remain, obj)
+			}
+		}
+		index := make(map[rbush.Item]int)
+		for i, obj := range objs {
+			index[obj] = i
+		}
+		n := tr.RemoveWhere(min, max, func(item rbush.Item) bool {
+			return index[item]%2 == 0
+		})
+		assert.Equal(t, expect, n)
+		assert.Equal(t, len(remain), tr.Count())
+		var arr []rbush.Item
+		tr.Scan(func(item rbush.Item) bool {
+			arr = append(arr, item)
+			return true
+		})
+		assert.True(t, testHasSameItems(remain, arr))
+		testSearch(t, tr, remain, 0.50, true)
+		testKNN(t, tr, remain, 100, true)
+
+		n = tr.RemoveWhere(min, max, nil)
+		assert.Equal(t, expect == 0, n == 0)
+		remain = remain[:0]
+		for _, obj := range objs {
+			if !testIntersects(obj, box) {
+				remain = append(remain, obj)
+			}
+		}
+		assert.Equal(t, len(remain), tr.Count())
+		testSearch(t, tr, remain, 1.00, true)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	for dims := 1; dims <= 5; dims++ {
+		rand.Seed(time.Now().UnixNano())
+		tr := rbush.New(dims)
+		objs := make([]rbush.Item, 10000)
+		for i := 0; i < len(objs); i++ {
+			objs[i] = makeRandom("point", dims)
+			tr.Insert(objs[i])
+		}
+		perm := rand.Perm(len(objs))
+		var remove, remain []rbush.Item
+		for i, j := range perm {
+			if i < len(perm)*3/4 {
+				remove = append(remove, objs[j])
+			} else {
+				remain = append(remain, objs[j])
+			}
+		}
+		assert.Equal(t, len(remove), tr.RemoveAll(remove))
+		assert.Equal(t, 0, tr.RemoveAll(remove))
+		assert.Equal(t, len(remain), tr.Count())
+		testSearch(t, tr, remain, 0.50, true)
+		testKNN(t, tr, remain, len(remain), true)
+		assert.Equal(t, len(remain), tr.RemoveAll(remain))
+		assert.Equal(t, 0, tr.Count())
+		min, max := tr.Bounds()
+		assert.Equal(t, make([]float64, dims), min)
+		assert.Equal(t, make([]float64, dims), max)
+	}
+}
+
+// nodeOccupancy returns the tree height and the number of children of each
+// non-root node, derived from the preorder Traverse output.
+func nodeOccupancy(tr *rbush.RBush) (height int, counts []int) {
+	type frame struct{ level, count int }
+	var stack []frame
+	tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		for len(stack) > 0 && stack[len(stack)-1].level <= level {
+			if len(stack) > 1 {
+				counts = append(counts, stack[len(stack)-1].count)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			stack[len(stack)-1].count++
+		} else {
+			height = level
+		}
+		if level > 0 {
+			stack = append(stack, frame{level: level})
+		}
+		return true
+	})
+	for i := len(stack) - 1; i > 0; i-- {
+		counts = append(counts, stack[i].count)
+	}
+	return height, counts
+}
+
+func TestCondenseChurn(t *testing.T) {
+	// default tree has 9 max entries and 4 min entries
+	const minEntries, maxEntries = 4, 9
+	for dims := 1; dims <= 3; dims++ {
+		rand.Seed(time.Now().UnixNano())
+		tr := rbush.New(dims)
+		var objs []rbush.Item
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 2000; i++ {
+				obj := makeRandom("rect", dims)
+				objs = append(objs, obj)
+				tr.Insert(obj)
+			}
+			// remove most of the items, one at a time
+			perm := rand.Perm(len(objs))
+			var remain []rbush.Item
+			for i, j := range perm {
+				if i < len(perm)*9/10 {
+					tr.Remove(objs[j])
+				} else {
+					remain = append(remain, objs[j])
+				}
+			}
+			objs = remain
+			assert.Equal(t, len(objs), tr.Count())
+
+			height, counts := nodeOccupancy(tr)
+			maxHeight := int(math.Ceil(math.Log(float64(len(objs)))/
+				math.Log(minEntries))) + 1
+			assert.True(t, height <= maxHeight,
+				"height %d exceeds %d for %d items", height, maxHeight, len(objs))
+			for _, n := range counts {
+				if n < minEntries || n > maxEntries {
+					t.Fatalf("node has %d children", n)
+				}
+			}
+		}
+		testSearch(t, tr, objs, 0.50, true)
+		testKNN(t, tr, objs, 100, true)
+	}
+}
+
+// leafOverlap returns the total pairwise intersection area of the leaves.
+func leafOverlap(tr *rbush.RBush) float64 {
+	var leaves [][2][]float64
+	tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		if level == 1 {
+			leaves = append(leaves, [2][]float64{min, max})
+		}
+		return true
+	})
+	var overlap float64
+	for i := 0; i < len(leaves); i++ {
+		for j := i + 1; j < len(leaves); j++ {
+			area := 1.0
+			for k := 0; k < len(leaves[i][0]); k++ {
+				min := math.Max(leaves[i][0][k], leaves[j][0][k])
+				max := math.Min(leaves[i][1][k], leaves[j][1][k])
+				area *= math.Max(0, max-min)
+			}
+			overlap += area
+		}
+	}
+	return overlap
+}
+
+func TestRStar(t *testing.T) {
+	for dims := 1; dims <= 5; dims++ {
+		rand.Seed(time.Now().UnixNano())
+		objs := make([]rbush.Item, 10000)
+		for i := 0; i < len(objs); i++ {
+			objs[i] = makeRandom("rect", dims)
+		}
+		tr := rbush.NewOptions(dims, &rbush.Options{
+			Insertion: rbush.InsertRStar,
+		})
+		for _, obj := range objs {
+			tr.Insert(obj)
+		}
+		assert.Equal(t, len(objs), tr.Count())
+		testSearch(t, tr, objs, 0.10, true)
+		testSearch(t, tr, objs, 0.50, true)
+		testKNN(t, tr, objs, 1000, true)
+		if dims == 2 {
+			def := rbush.New(dims)
+			for _, obj := range objs {
+				def.Insert(obj)
+			}
+			overlap, defOverlap := leafOverlap(tr), leafOverlap(def)
+			fmt.Printf("R* leaf overlap %.0f, default leaf overlap %.0f\n",
+				overlap, defOverlap)
+			assert.True(t, overlap < defOverlap)
+		}
+		perm := rand.Perm(len(objs))
+		var remain []rbush.Item
+		for i, j := range perm {
+			if i < len(perm)/2 {
+				tr.Remove(objs[j])
+			} else {
+				remain = append(remain, objs[j])
+			}
+		}
+		testSearch(t, tr, remain, 0.50, true)
+		for _, obj := range remain {
+			tr.Insert(obj)
+			tr.Remove(obj)
+			tr.Remove(obj)
+		}
+		assert.Equal(t, 0, tr.Count())
+	}
+}
+
 func TestOutput3DPNG(t *testing.T) {
 	rand.Seed(time.Now().UnixNano())
 	tr := rbush.New(3)
@@ -0,0 +1,269 @@
+// Package render draws the structure of an R-tree as SVG or PNG.
+//
+// Trees with more than two dimensions are projected onto two of their
+// axes. One dimensional trees are drawn as bars.
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/tidwall/rbush"
+)
+
+// Options for drawing a tree.
+type Options struct {
+	// Width and Height of the image in pixels. Default is 1000 by 1000.
+	Width, Height int
+	// X and Y are the axes that are drawn. Default is 0 and 1.
+	X, Y int
+	// Min and Max are the bounds of the drawing. Default is the bounds of
+	// the tree and the overlays.
+	Min, Max []float64
+}
+
+// Palette holds the colors of the levels, where items are level 0. Levels
+// past the end of the palette are drawn with the last color.
+var Palette = []color.RGBA{
+	{255, 0, 0, 255},
+	{0, 255, 0, 255},
+	{0, 0, 255, 255},
+	{255, 255, 0, 255},
+	{255, 0, 255, 255},
+	{0, 255, 255, 255},
+	{128, 0, 0, 255},
+	{0, 128, 0, 255},
+	{128, 128, 128, 255},
+}
+
+var (
+	background = color.RGBA{0, 0, 0, 255}
+	queryColor = color.RGBA{255, 255, 255, 255}
+	knnColor   = color.RGBA{255, 165, 0, 255}
+)
+
+type shape struct {
+	min, max [2]float64
+	level    int
+	color    color.RGBA
+	fill     bool
+}
+
+// Drawing is a tree with overlays, ready to be written out.
+type Drawing struct {
+	tr     *rbush.RBush
+	opts   Options
+	dims   int
+	shapes []shape
+	extra  []shape
+}
+
+// New returns a drawing of the tree as it is now. Changes made to the tree
+// later are not drawn.
+func New(tr *rbush.RBush, opts *Options) *Drawing {
+	d := &Drawing{tr: tr, opts: Options{Width: 1000, Height: 1000, Y: 1}}
+	if opts != nil {
+		d.opts = *opts
+		if d.opts.Width <= 0 {
+			d.opts.Width = 1000
+		}
+		if d.opts.Height <= 0 {
+			d.opts.Height = 1000
+		}
+		if d.opts.X == 0 && d.opts.Y == 0 {
+			d.opts.Y = 1
+		}
+	}
+	min, _ := tr.Bounds()
+	d.dims = len(min)
+	if d.dims > 1 && (d.opts.X < 0 || d.opts.X >= d.dims || d.opts.Y < 0 ||
+		d.opts.Y >= d.dims || d.opts.X == d.opts.Y) {
+		panic("invalid axes")
+	}
+	tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+		if level > 0 && math.IsInf(min[0], +1) {
+			return true // empty root
+		}
+		d.shapes = append(d.shapes, d.shape(min, max, level, levelColor(level), level == 0))
+		return true
+	})
+	// items first, so that they do not hide the outlines of the nodes
+	sort.SliceStable(d.shapes, func(i, j int) bool {
+		return d.shapes[i].level < d.shapes[j].level
+	})
+	return d
+}
+
+func levelColor(level int) color.RGBA {
+	if level >= len(Palette) {
+		return Palette[len(Palette)-1]
+	}
+	return Palette[level]
+}
+
+// shape projects a box. In one dimension the box is a bar, inset by level
+// so that nested nodes can be told apart.
+func (d *Drawing) shape(min, max []float64, level int, c color.RGBA, fill bool) shape {
+	if d.dims == 1 {
+		inset := float64(level) * 0.04
+		return shape{[2]float64{min[0], inset}, [2]float64{max[0], 1 - inset}, level, c, fill}
+	}
+	x, y := d.opts.X, d.opts.Y
+	return shape{[2]float64{min[x], min[y]}, [2]float64{max[x], max[y]}, level, c, fill}
+}
+
+// Query overlays a search box, and marks the items that it finds.
+func (d *Drawing) Query(min, max []float64) {
+	d.extra = append(d.extra, d.shape(min, max, 0, queryColor, false))
+	d.tr.Search(&box{min, max}, func(item rbush.Item) bool {
+		min, max := item.Rect()
+		d.extra = append(d.extra, d.shape(min, max, 0, queryColor, true))
+		return true
+	})
+}
+
+// KNN overlays a point, and marks the k items nearest to it.
+func (d *Drawing) KNN(point []float64, k int) {
+	d.extra = append(d.extra, d.shape(point, point, 0, knnColor, false))
+	var n int
+	d.tr.KNN(point, func(item rbush.Item, dist float64) bool {
+		if n == k {
+			return false
+		}
+		min, max := item.Rect()
+		d.extra = append(d.extra, d.shape(min, max, 0, knnColor, true))
+		n++
+		return true
+	})
+}
+
+type box struct {
+	min, max []float64
+}
+
+func (b *box) Rect() (min, max []float64) {
+	return b.min, b.max
+}
+
+// transform maps drawing coordinates to pixels, with y going up.
+type transform struct {
+	min, max [2]float64
+	w, h     float64
+	margin   float64
+}
+
+func (d *Drawing) transform() transform {
+	t := transform{w: float64(d.opts.Width), h: float64(d.opts.Height), margin: 10}
+	if len(d.opts.Min) == d.dims && len(d.opts.Max) == d.dims {
+		s := d.shape(d.opts.Min, d.opts.Max, 0, background, false)
+		t.min, t.max = s.min, s.max
+	} else {
+		t.min = [2]float64{math.Inf(+1), math.Inf(+1)}
+		t.max = [2]float64{math.Inf(-1), math.Inf(-1)}
+		for _, shapes := range [][]shape{d.shapes, d.extra} {
+			for _, s := range shapes {
+				for i := 0; i < 2; i++ {
+					t.min[i] = math.Min(t.min[i], s.min[i])
+					t.max[i] = math.Max(t.max[i], s.max[i])
+				}
+			}
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if !(t.max[i] > t.min[i]) || math.IsInf(t.max[i]-t.min[i], 0) {
+			t.min[i], t.max[i] = -1, 1
+		}
+	}
+	return t
+}
+
+func (t transform) point(x, y float64) (float64, float64) {
+	px := t.margin + (x-t.min[0])/(t.max[0]-t.min[0])*(t.w-2*t.margin)
+	py := t.margin + (t.max[1]-y)/(t.max[1]-t.min[1])*(t.h-2*t.margin)
+	return px, py
+}
+
+// rect returns the pixel box of a shape. Boxes without area are grown so
+// that they can be seen.
+func (t transform) rect(s shape) (x0, y0, x1, y1 float64) {
+	x0, y1 = t.point(s.min[0], s.min[1])
+	x1, y0 = t.point(s.max[0], s.max[1])
+	if x1-x0 < 3 {
+		cx := (x0 + x1) / 2
+		x0, x1 = cx-1.5, cx+1.5
+	}
+	if y1-y0 < 3 {
+		cy := (y0 + y1) / 2
+		y0, y1 = cy-1.5, cy+1.5
+	}
+	return x0, y0, x1, y1
+}
+
+// WriteSVG writes the drawing as an SVG document.
+func (d *Drawing) WriteSVG(w io.Writer) error {
+	t := d.transform()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n",
+		d.opts.Width, d.opts.Height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", hex(background))
+	for _, shapes := range [][]shape{d.shapes, d.extra} {
+		for _, s := range shapes {
+			x0, y0, x1, y1 := t.rect(s)
+			paint := `fill="none" stroke="` + hex(s.color) + `" stroke-width="1"`
+			if s.fill {
+				paint = `fill="` + hex(s.color) + `"`
+			}
+			fmt.Fprintf(bw, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" %s/>`+"\n",
+				x0, y0, x1-x0, y1-y0, paint)
+		}
+	}
+	fmt.Fprintln(bw, `</svg>`)
+	return bw.Flush()
+}
+
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Image returns the drawing as an image.
+func (d *Drawing) Image() *image.RGBA {
+	t := d.transform()
+	img := image.NewRGBA(image.Rect(0, 0, d.opts.Width, d.opts.Height))
+	fillRect(img, img.Bounds(), background)
+	for _, shapes := range [][]shape{d.shapes, d.extra} {
+		for _, s := range shapes {
+			x0, y0, x1, y1 := t.rect(s)
+			r := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)),
+				int(math.Ceil(x1)), int(math.Ceil(y1)))
+			if s.fill {
+				fillRect(img, r, s.color)
+				continue
+			}
+			fillRect(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+1), s.color)
+			fillRect(img, image.Rect(r.Min.X, r.Max.Y-1, r.Max.X, r.Max.Y), s.color)
+			fillRect(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+1, r.Max.Y), s.color)
+			fillRect(img, image.Rect(r.Max.X-1, r.Min.Y, r.Max.X, r.Max.Y), s.color)
+		}
+	}
+	return img
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// WritePNG writes the drawing as a PNG image.
+func (d *Drawing) WritePNG(w io.Writer) error {
+	return png.Encode(w, d.Image())
+}
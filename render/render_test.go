@@ -0,0 +1,109 @@
+package render_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/render"
+)
+
+type point []float64
+
+func (p point) Rect() (min, max []float64) {
+	return p, p
+}
+
+func randomTree(dims, n int) *rbush.RBush {
+	tr := rbush.New(dims)
+	for i := 0; i < n; i++ {
+		p := make(point, dims)
+		for j := range p {
+			p[j] = rand.Float64()*100 - 50
+		}
+		tr.Insert(p)
+	}
+	return tr
+}
+
+// countRects returns the number of rect elements in an SVG document.
+func countRects(t *testing.T, data []byte) int {
+	var doc struct {
+		Rects []struct{} `xml:"rect"`
+	}
+	assert.NoError(t, xml.Unmarshal(data, &doc))
+	return len(doc.Rects)
+}
+
+func TestRender(t *testing.T) {
+	for dims := 1; dims <= 3; dims++ {
+		tr := randomTree(dims, 1000)
+		var nodes int
+		tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+			nodes++
+			return true
+		})
+		d := render.New(tr, &render.Options{Width: 400, Height: 300})
+		var buf bytes.Buffer
+		assert.NoError(t, d.WriteSVG(&buf))
+		// the background and every node and item
+		assert.Equal(t, nodes+1, countRects(t, buf.Bytes()))
+
+		min, max := make([]float64, dims), make([]float64, dims)
+		for i := range min {
+			min[i], max[i] = -10, 10
+		}
+		d.Query(min, max)
+		d.KNN(make([]float64, dims), 5)
+		buf.Reset()
+		assert.NoError(t, d.WriteSVG(&buf))
+		// the query box, the knn point and its results, and the found items
+		found := countRects(t, buf.Bytes()) - nodes - 1 - 2 - 5
+		var expect int
+		tr.Traverse(func(bmin, bmax []float64, level int, item rbush.Item) bool {
+			if level == 0 && intersects(bmin, bmax, min, max) {
+				expect++
+			}
+			return true
+		})
+		assert.Equal(t, expect, found)
+
+		buf.Reset()
+		assert.NoError(t, d.WritePNG(&buf))
+		img, err := png.Decode(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, 400, img.Bounds().Dx())
+		assert.Equal(t, 300, img.Bounds().Dy())
+		colors := make(map[[3]uint32]bool)
+		for y := 0; y < 300; y++ {
+			for x := 0; x < 400; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				colors[[3]uint32{r >> 8, g >> 8, b >> 8}] = true
+			}
+		}
+		// items, leaves, the query and the knn results
+		assert.True(t, colors[[3]uint32{255, 0, 0}])
+		assert.True(t, colors[[3]uint32{0, 255, 0}])
+		assert.True(t, colors[[3]uint32{255, 255, 255}])
+		assert.True(t, colors[[3]uint32{255, 165, 0}])
+	}
+
+	// an empty tree only has a background
+	var buf bytes.Buffer
+	assert.NoError(t, render.New(rbush.New(2), nil).WriteSVG(&buf))
+	assert.Equal(t, 1, countRects(t, buf.Bytes()))
+	assert.Panics(t, func() { render.New(rbush.New(3), &render.Options{X: 1, Y: 3}) })
+}
+
+func intersects(amin, amax, bmin, bmax []float64) bool {
+	for i := range amin {
+		if !(bmin[i] <= amax[i] && bmax[i] >= amin[i]) {
+			return false
+		}
+	}
+	return true
+}
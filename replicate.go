@@ -0,0 +1,449 @@
+package rbush
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// LeaderOptions for NewLeader.
+type LeaderOptions struct {
+	// Options for the tree.
+	Options *Options
+	// Codec converts items to and from bytes. Required, and must be safe
+	// for concurrent use.
+	Codec ItemCodec
+	// Retain is the number of operations that are kept for followers that
+	// resume. A follower that is further behind starts over from a
+	// snapshot. Default is 4096.
+	Retain int
+}
+
+// Leader is an RBush that publishes an ordered log of its inserts and
+// removals to followers. Every operation has a sequence number, and a
+// follower that connects with the sequence of the last operation it applied
+// gets the operations after it. A new follower, or one that is too far
+// behind, gets a snapshot of the tree first.
+//
+// The methods of a Leader are safe for concurrent use.
+type Leader struct {
+	mu      sync.RWMutex
+	tr      *RBush
+	opts    LeaderOptions
+	seq     uint64 // sequence of the last operation
+	log     []logRecord
+	changed chan struct{} // closed when an operation is added
+	closed  bool
+	conns   map[io.Closer]bool
+}
+
+type logRecord struct {
+	seq  uint64
+	op   byte
+	data []byte
+}
+
+// ErrInvalidLog is returned when a replication log is malformed, corrupt,
+// or has a gap in its sequence.
+var ErrInvalidLog = errors.New("invalid replication log")
+
+// replSnapshot is a record that is followed by a snapshot of the tree at
+// the sequence of the record.
+const replSnapshot = 3
+
+const replMagic = "RBREPL01"
+
+// NewLeader returns an empty tree that publishes its changes.
+func NewLeader(dims int, opts *LeaderOptions) (*Leader, error) {
+	if opts == nil || opts.Codec == nil {
+		return nil, errors.New("codec is required")
+	}
+	l := &Leader{
+		opts:    *opts,
+		tr:      NewOptions(dims, opts.Options),
+		changed: make(chan struct{}),
+		conns:   make(map[io.Closer]bool),
+	}
+	if l.opts.Retain <= 0 {
+		l.opts.Retain = 4096
+	}
+	return l, nil
+}
+
+func (l *Leader) append(op byte, data []byte) {
+	l.seq++
+	l.log = append(l.log, logRecord{l.seq, op, data})
+	if len(l.log) >= 2*l.opts.Retain {
+		// copied, so that the records being written are not changed
+		l.log = append([]logRecord(nil), l.log[len(l.log)-l.opts.Retain:]...)
+	}
+	if !l.closed {
+		close(l.changed)
+		l.changed = make(chan struct{})
+	}
+}
+
+// Insert adds the item to the tree and the log.
+func (l *Leader) Insert(item Item) error {
+	data, err := l.opts.Codec.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxEncodedItem {
+		return errors.New("encoded item is too large")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tr.Insert(item)
+	l.append(walInsert, data)
+	return nil
+}
+
+// Remove removes the item from the tree, and adds the removal to the log
+// when the item was in the tree. Followers remove an item with the same
+// encoded bytes.
+func (l *Leader) Remove(item Item) error {
+	data, err := l.opts.Codec.MarshalItem(item)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tr.remove(item) {
+		l.append(walRemove, data)
+	}
+	return nil
+}
+
+// Seq returns the sequence number of the last operation.
+func (l *Leader) Seq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.seq
+}
+
+// next returns the records after seq, or a copy of the tree when the
+// records are no longer kept. It also returns a channel that is closed
+// when there are more records.
+func (l *Leader) next(seq uint64) ([]logRecord, *RBush, uint64, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, nil, 0, nil
+	}
+	if seq == l.seq {
+		return nil, nil, 0, l.changed
+	}
+	if seq < l.seq && len(l.log) > 0 && seq+1 >= l.log[0].seq {
+		return l.log[seq+1-l.log[0].seq:], nil, 0, l.changed
+	}
+	// the nodes are shared with the copy, and copied before they change
+	l.tr.gen = nextGeneration()
+	tr := l.tr.shadow()
+	return nil, tr, l.seq, l.changed
+}
+
+// WriteLog writes the operations after the sequence from to w, starting
+// with a snapshot when they are no longer kept, and then the operations
+// as they are made. It returns once Close is called or a write fails.
+func (l *Leader) WriteLog(w io.Writer, from uint64) error {
+	return l.writeLog(w, from, nil)
+}
+
+// writeLog is WriteLog, which also returns once gone is closed.
+func (l *Leader) writeLog(w io.Writer, from uint64, gone <-chan struct{}) error {
+	bw := bufio.NewWriter(w)
+	seq := from
+	for {
+		records, tr, snapSeq, changed := l.next(seq)
+		if changed == nil {
+			return bw.Flush()
+		}
+		if tr != nil {
+			if err := writeRecord(bw, snapSeq, replSnapshot, nil); err != nil {
+				return err
+			}
+			if err := tr.writeSnapshot(bw, l.opts.Codec, snapSeq); err != nil {
+				return err
+			}
+			seq = snapSeq
+		}
+		for _, r := range records {
+			if err := writeRecord(bw, r.seq, r.op, r.data); err != nil {
+				return err
+			}
+			seq = r.seq
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if tr == nil && len(records) == 0 {
+			select {
+			case <-changed:
+			case <-gone:
+				return nil
+			}
+		}
+	}
+}
+
+// Serve accepts followers on the listener, and writes the log to each of
+// them from the sequence they connect with. It returns once Close is
+// called.
+func (l *Leader) Serve(ln net.Listener) error {
+	if !l.track(ln, true) {
+		ln.Close()
+		return nil
+	}
+	defer l.track(ln, false)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return nil
+			}
+			return err
+		}
+		go l.serveConn(conn)
+	}
+}
+
+func (l *Leader) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if !l.track(conn, true) {
+		return
+	}
+	defer l.track(conn, false)
+	var hello [16]byte
+	if _, err := io.ReadFull(conn, hello[:]); err != nil || string(hello[:8]) != replMagic {
+		return
+	}
+	gone := make(chan struct{})
+	go func() {
+		// a follower sends nothing after the hello, so the read returns
+		// once the connection ends
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	l.writeLog(conn, binary.LittleEndian.Uint64(hello[8:]), gone)
+}
+
+// track adds or removes a listener or a connection that is closed by
+// Close. It reports false when the leader is closed.
+func (l *Leader) track(c io.Closer, add bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !add {
+		delete(l.conns, c)
+		return true
+	}
+	if l.closed {
+		return false
+	}
+	l.conns[c] = true
+	return true
+}
+
+func (l *Leader) isClosed() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.closed
+}
+
+// Close stops writing the log to followers, and closes the listeners and
+// connections of Serve. The tree can still be used.
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.changed)
+	for c := range l.conns {
+		c.Close()
+	}
+	return nil
+}
+
+func (l *Leader) Search(bbox Item, iter func(item Item) bool) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tr.Search(bbox, iter)
+}
+
+func (l *Leader) KNN(point []float64, iter func(item Item, dist float64) bool) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tr.KNN(point, iter)
+}
+
+func (l *Leader) Scan(iter func(item Item) bool) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tr.Scan(iter)
+}
+
+func (l *Leader) Count() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tr.Count()
+}
+
+func (l *Leader) Bounds() (min, max []float64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tr.Bounds()
+}
+
+// FollowerOptions for NewFollower.
+type FollowerOptions struct {
+	// Options for the tree.
+	Options *Options
+	// Codec converts items to and from bytes. Required.
+	Codec ItemCodec
+}
+
+// Follower is an RBush that applies the log of a Leader. Items that are
+// read from the log are new values, so removals match items by their
+// encoded bytes.
+//
+// The methods of a Follower are safe for concurrent use, and queries see
+// the tree between operations.
+type Follower struct {
+	mu    sync.RWMutex
+	tr    *RBush
+	opts  FollowerOptions
+	seq   uint64 // sequence of the last applied operation
+	items encodedItems
+}
+
+// NewFollower returns an empty tree that follows a leader.
+func NewFollower(dims int, opts *FollowerOptions) (*Follower, error) {
+	if opts == nil || opts.Codec == nil {
+		return nil, errors.New("codec is required")
+	}
+	return &Follower{
+		opts:  *opts,
+		tr:    NewOptions(dims, opts.Options),
+		items: make(encodedItems),
+	}, nil
+}
+
+// Follow connects to a leader that is served by Serve, and applies its log
+// from the last applied operation. It returns once the connection ends.
+// When it fails it can be called again on a new connection to resume.
+func (f *Follower) Follow(conn io.ReadWriter) error {
+	var hello [16]byte
+	copy(hello[:], replMagic)
+	binary.LittleEndian.PutUint64(hello[8:], f.Seq())
+	if _, err := conn.Write(hello[:]); err != nil {
+		return err
+	}
+	return f.Apply(conn)
+}
+
+// Apply reads a log written by WriteLog and applies it, until r returns
+// io.EOF. Operations that were already applied are skipped.
+func (f *Follower) Apply(r io.Reader) error {
+	// readSnapshot reads through the same buffer
+	br := bufio.NewReader(r)
+	for {
+		seq, op, data, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		} else if err == errInvalidRecord {
+			return ErrInvalidLog
+		} else if err != nil {
+			return err
+		}
+		if op == replSnapshot {
+			if err := f.applySnapshot(br, seq); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := f.apply(seq, op, data); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Follower) applySnapshot(r *bufio.Reader, seq uint64) error {
+	tr, snapSeq, err := readSnapshot(r, f.opts.Codec, f.opts.Options)
+	if err == ErrInvalidSnapshot || err == nil && snapSeq != seq {
+		return ErrInvalidLog
+	} else if err != nil {
+		return err
+	}
+	if tr.dims != f.tr.dims {
+		return errors.New("snapshot dimensions does not match tree dimensions")
+	}
+	items, err := indexEncoded(tr, f.opts.Codec)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tr, f.items, f.seq = tr, items, seq
+	return nil
+}
+
+func (f *Follower) apply(seq uint64, op byte, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if seq <= f.seq {
+		return nil
+	}
+	if seq != f.seq+1 {
+		return ErrInvalidLog
+	}
+	if err := applyRecord(f.tr, f.items, f.opts.Codec, op, data); err != nil {
+		if err == errInvalidRecord {
+			return ErrInvalidLog
+		}
+		return err
+	}
+	f.seq = seq
+	return nil
+}
+
+// Seq returns the sequence number of the last applied operation.
+func (f *Follower) Seq() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.seq
+}
+
+func (f *Follower) Search(bbox Item, iter func(item Item) bool) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tr.Search(bbox, iter)
+}
+
+func (f *Follower) KNN(point []float64, iter func(item Item, dist float64) bool) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tr.KNN(point, iter)
+}
+
+func (f *Follower) Scan(iter func(item Item) bool) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tr.Scan(iter)
+}
+
+func (f *Follower) Count() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tr.Count()
+}
+
+func (f *Follower) Bounds() (min, max []float64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.tr.Bounds()
+}
@@ -0,0 +1,244 @@
+package rbush_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+// recordingConn keeps the bytes read from a connection.
+type recordingConn struct {
+	net.Conn
+	mu   sync.Mutex
+	read bytes.Buffer
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.read.Write(p[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *recordingConn) snapshots() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Count(c.read.Bytes(), []byte("RBSNAP01"))
+}
+
+// apply runs the operations on the leader.
+func apply(t *testing.T, l *rbush.Leader, ops []*idRect, removes []bool) {
+	for i, op := range ops {
+		if removes[i] {
+			assert.NoError(t, l.Remove(op))
+		} else {
+			assert.NoError(t, l.Insert(op))
+		}
+	}
+}
+
+// caughtUp waits for the follower to apply every operation of the leader.
+func caughtUp(t *testing.T, l *rbush.Leader, f *rbush.Follower) {
+	deadline := time.Now().Add(10 * time.Second)
+	for f.Seq() != l.Seq() {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower is at %d, leader is at %d", f.Seq(), l.Seq())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, scanIDs(l), scanIDs(f))
+	assert.Equal(t, l.Count(), f.Count())
+	min1, max1 := l.Bounds()
+	min2, max2 := f.Bounds()
+	assert.Equal(t, min1, min2)
+	assert.Equal(t, max1, max2)
+}
+
+func TestReplicate(t *testing.T) {
+	ops, removes := durableOps(3000)
+	l, err := rbush.NewLeader(2, &rbush.LeaderOptions{Codec: idRectCodec{}, Retain: 500})
+	assert.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error)
+	go func() { served <- l.Serve(ln) }()
+
+	follow := func(f *rbush.Follower) (*recordingConn, chan error) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.NoError(t, err)
+		rc := &recordingConn{Conn: conn}
+		done := make(chan error, 1)
+		go func() { done <- f.Follow(rc) }()
+		return rc, done
+	}
+
+	// a follower gets every operation while they are retained
+	f, err := rbush.NewFollower(2, &rbush.FollowerOptions{Codec: idRectCodec{}})
+	assert.NoError(t, err)
+	conn, done := follow(f)
+	apply(t, l, ops[:400], removes[:400])
+	caughtUp(t, l, f)
+	assert.Equal(t, 0, conn.snapshots())
+
+	// it resumes from its last operation
+	conn.Close()
+	<-done
+	apply(t, l, ops[400:600], removes[400:600])
+	conn, done = follow(f)
+	caughtUp(t, l, f)
+	assert.Equal(t, 0, conn.snapshots())
+
+	// and starts over from a snapshot when it is too far behind
+	conn.Close()
+	<-done
+	apply(t, l, ops[600:2500], removes[600:2500])
+	conn, done = follow(f)
+	caughtUp(t, l, f)
+	assert.Equal(t, 1, conn.snapshots())
+
+	// a new follower bootstraps from a snapshot while the leader changes
+	f2, err := rbush.NewFollower(2, &rbush.FollowerOptions{Codec: idRectCodec{}})
+	assert.NoError(t, err)
+	conn2, done2 := follow(f2)
+	apply(t, l, ops[2500:], removes[2500:])
+	caughtUp(t, l, f)
+	caughtUp(t, l, f2)
+	assert.NotZero(t, conn2.snapshots())
+
+	assert.NoError(t, l.Close())
+	assert.NoError(t, <-served)
+	<-done
+	<-done2
+	// the leader is still a tree after it is closed
+	assert.NoError(t, l.Insert(&idRect{[]float64{1, 1}, []float64{2, 2}, 99999}))
+	assert.Equal(t, f.Count()+1, l.Count())
+}
+
+// logBytes returns the log of the operations as written by WriteLog.
+func TestLeaderDisconnect(t *testing.T) {
+	l, err := rbush.NewLeader(2, &rbush.LeaderOptions{Codec: idRectCodec{}})
+	assert.NoError(t, err)
+	defer l.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go l.Serve(ln)
+	time.Sleep(10 * time.Millisecond)
+	base := runtime.NumGoroutine()
+
+	// followers that go away while the leader is idle
+	var conns []net.Conn
+	for i := 0; i < 20; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		assert.NoError(t, err)
+		conn.Write(append([]byte("RBREPL01"), make([]byte, 8)...))
+		conns = append(conns, conn)
+	}
+	waitFor := func(ok func(n int) bool) bool {
+		for i := 0; i < 500; i++ {
+			if ok(runtime.NumGoroutine()) {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	assert.True(t, waitFor(func(n int) bool { return n >= base+20 }))
+	for _, conn := range conns {
+		conn.Close()
+	}
+	assert.True(t, waitFor(func(n int) bool { return n <= base }),
+		"%d goroutines, expected %d", runtime.NumGoroutine(), base)
+}
+
+func logBytes(t *testing.T, ops []*idRect, removes []bool, from uint64) []byte {
+	l, err := rbush.NewLeader(2, &rbush.LeaderOptions{Codec: idRectCodec{}, Retain: 10})
+	assert.NoError(t, err)
+	apply(t, l, ops, removes)
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() { done <- l.WriteLog(w, from) }()
+	// every record of the test workload is 57 bytes
+	_, err = io.CopyN(&buf, r, int64(len(ops)-int(from))*57)
+	assert.NoError(t, err)
+	l.Close()
+	go io.Copy(io.Discard, r)
+	assert.NoError(t, <-done)
+	return buf.Bytes()
+}
+
+func TestApply(t *testing.T) {
+	ops, removes := durableOps(8)
+	f, err := rbush.NewFollower(2, &rbush.FollowerOptions{Codec: idRectCodec{}})
+	assert.NoError(t, err)
+	data := logBytes(t, ops, removes, 0)
+	assert.NoError(t, f.Apply(bytes.NewReader(data[:4*57])))
+	assert.Equal(t, uint64(4), f.Seq())
+	// operations that were applied are skipped
+	assert.NoError(t, f.Apply(bytes.NewReader(data)))
+	assert.Equal(t, uint64(8), f.Seq())
+	var want []int
+	for i, op := range ops {
+		if !removes[i] {
+			want = append(want, int(op.id))
+		}
+	}
+	for i, op := range ops {
+		if removes[i] {
+			for j, id := range want {
+				if id == int(op.id) {
+					want = append(want[:j], want[j+1:]...)
+					break
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, want, scanIDs(f))
+
+	// a gap in the sequence
+	f, _ = rbush.NewFollower(2, &rbush.FollowerOptions{Codec: idRectCodec{}})
+	assert.Equal(t, rbush.ErrInvalidLog, f.Apply(bytes.NewReader(data[57:])))
+	assert.Equal(t, uint64(0), f.Seq())
+	// a corrupt record
+	corrupt := append([]byte(nil), data...)
+	corrupt[2*57+30] ^= 0xFF
+	assert.Equal(t, rbush.ErrInvalidLog, f.Apply(bytes.NewReader(corrupt)))
+	assert.Equal(t, uint64(2), f.Seq())
+	// a torn record after a whole one
+	assert.Equal(t, rbush.ErrInvalidLog, f.Apply(bytes.NewReader(data[:3*57+20])))
+	assert.Equal(t, uint64(3), f.Seq())
+	// a snapshot of another number of dimensions
+	f3, _ := rbush.NewFollower(3, &rbush.FollowerOptions{Codec: idRectCodec{}})
+	assert.Error(t, f3.Apply(bytes.NewReader(snapshotLog(t, ops, removes))))
+
+	_, err = rbush.NewLeader(2, nil)
+	assert.Error(t, err)
+	_, err = rbush.NewFollower(2, &rbush.FollowerOptions{})
+	assert.Error(t, err)
+}
+
+// snapshotLog returns a log that starts with a snapshot.
+func snapshotLog(t *testing.T, ops []*idRect, removes []bool) []byte {
+	l, err := rbush.NewLeader(2, &rbush.LeaderOptions{Codec: idRectCodec{}, Retain: 1})
+	assert.NoError(t, err)
+	apply(t, l, ops, removes)
+	var buf bytes.Buffer
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() { done <- l.WriteLog(w, 0) }()
+	// the record and the header of the snapshot
+	_, err = io.CopyN(&buf, r, 17+28)
+	assert.NoError(t, err)
+	l.Close()
+	go io.Copy(io.Discard, r)
+	<-done
+	return buf.Bytes()
+}
@@ -0,0 +1,634 @@
+// Package resp serves collections of objects over the Redis protocol, with
+// commands like those of Tile38.
+//
+//	SET key id POINT lat lon
+//	SET key id BOUNDS minlat minlon maxlat maxlon
+//	SET key id OBJECT geojson
+//	GET key id
+//	DEL key id
+//	WITHIN key [CURSOR start] [LIMIT count] BOUNDS ... | OBJECT ...
+//	INTERSECTS key [CURSOR start] [LIMIT count] BOUNDS ... | OBJECT ...
+//	NEARBY key [CURSOR start] [LIMIT count] POINT lat lon [radius]
+//	SCAN key [CURSOR start] [LIMIT count]
+//	BOUNDS key
+//
+// As in Tile38, positions are given as latitude and longitude, and objects
+// are replied as GeoJSON, where positions are longitude and latitude. Unlike
+// Tile38, distances are in degrees, on the plane of the coordinates.
+//
+// WITHIN and INTERSECTS test the exact shape of the objects. The replies of
+// the searches are an array of the next cursor, which is zero when there
+// are no more objects, and an array of id and object pairs.
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geojson"
+	"github.com/tidwall/rbush/geom"
+)
+
+// defaultLimit is the number of objects replied by a search without LIMIT.
+const defaultLimit = 100
+
+type object struct {
+	id       string
+	g        geom.Geometry
+	min, max []float64
+}
+
+func (o *object) Rect() (min, max []float64) {
+	return o.min, o.max
+}
+
+func (o *object) Geom() geom.Geometry {
+	return o.g
+}
+
+type collection struct {
+	tr   *rbush.RBush
+	byID map[string]*object
+	ids  []string // sorted, for SCAN
+}
+
+// Server holds the collections, and serves the clients that connect to
+// it.
+type Server struct {
+	mu          sync.RWMutex
+	collections map[string]*collection
+
+	connMu    sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server without collections.
+func New() *Server {
+	return &Server{
+		collections: make(map[string]*collection),
+		listeners:   make(map[net.Listener]bool),
+		conns:       make(map[net.Conn]bool),
+	}
+}
+
+// ErrServerClosed is returned by Serve after Close is called.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Serve accepts connections on l, and serves each of them on its own
+// goroutine. It returns ErrServerClosed once Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMu.Lock()
+	if s.closed {
+		s.connMu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.connMu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.connMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.connMu.Lock()
+		if s.closed {
+			s.connMu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.connMu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.connMu.Lock()
+			delete(s.conns, conn)
+			s.connMu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// ListenAndServe listens on the TCP address and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close closes the listeners and the connections, and waits for the
+// connections to be done.
+func (s *Server) Close() error {
+	s.connMu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connMu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				w.Write(appendReply(nil, err))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if strings.EqualFold(args[0], "QUIT") {
+			w.Write(appendReply(nil, Status("OK")))
+			w.Flush()
+			return
+		}
+		w.Write(appendReply(nil, s.Do(args...)))
+		// replies to pipelined commands are written together
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// protocolError is an error in the framing of a command, after which the
+// connection is closed.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// maxBulk is the largest argument of a command.
+const maxBulk = 512 << 20
+
+// maxInline is the longest line of an inline command, as with Redis.
+const maxInline = 64 << 10
+
+// readCommand reads an array of bulk strings, or an inline command, which
+// is a line of words.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1<<20 {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		// an empty command, as with Redis
+		return nil, nil
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got %q", line))
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[size:]) != "\r\n" {
+			return nil, protocolError("bulk string is not followed by CRLF")
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line)+len(frag) > maxInline {
+			return "", protocolError("too big inline request")
+		}
+		line = append(line, frag...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), nil
+	}
+}
+
+// Status is a simple string reply.
+type Status string
+
+// appendReply appends a reply, which is a Status, an error, an int, a
+// string, nil or a []interface{} of replies.
+func appendReply(dst []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case Status:
+		return append(append(append(dst, '+'), v...), "\r\n"...)
+	case error:
+		msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(v.Error())
+		return append(append(append(dst, "-ERR "...), msg...), "\r\n"...)
+	case int:
+		return append(strconv.AppendInt(append(dst, ':'), int64(v), 10), "\r\n"...)
+	case string:
+		dst = strconv.AppendInt(append(dst, '$'), int64(len(v)), 10)
+		return append(append(append(dst, "\r\n"...), v...), "\r\n"...)
+	case nil:
+		return append(dst, "$-1\r\n"...)
+	case []interface{}:
+		dst = append(strconv.AppendInt(append(dst, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, v := range v {
+			dst = appendReply(dst, v)
+		}
+		return dst
+	}
+	panic(fmt.Sprintf("resp: invalid reply %T", v))
+}
+
+func errWrongArgs(cmd string) error {
+	return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+// Do runs a command and returns its reply, which is a Status, an error, an
+// int, a string, nil or a []interface{} of replies.
+func (s *Server) Do(args ...string) interface{} {
+	if len(args) == 0 {
+		return errors.New("empty command")
+	}
+	cmd := strings.ToUpper(args[0])
+	switch cmd {
+	case "PING":
+		return Status("PONG")
+	case "SET":
+		return s.set(args)
+	case "GET":
+		return s.get(args)
+	case "DEL":
+		return s.del(args)
+	case "WITHIN":
+		return s.search(args, rbush.Within)
+	case "INTERSECTS":
+		return s.search(args, rbush.Intersects)
+	case "NEARBY":
+		return s.nearby(args)
+	case "SCAN":
+		return s.scan(args)
+	case "BOUNDS":
+		return s.bounds(args)
+	}
+	return fmt.Errorf("unknown command '%s'", args[0])
+}
+
+// parseFloats parses the numbers of an area.
+func parseFloats(args []string) ([]float64, error) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("invalid argument '%s'", arg)
+		}
+		values[i] = f
+	}
+	return values, nil
+}
+
+// parseArea parses POINT lat lon, BOUNDS minlat minlon maxlat maxlon or
+// OBJECT geojson, and returns the arguments that follow it.
+func parseArea(cmd string, args []string) (geom.Geometry, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, errWrongArgs(cmd)
+	}
+	switch strings.ToUpper(args[0]) {
+	case "POINT":
+		if len(args) < 3 {
+			return nil, nil, errWrongArgs(cmd)
+		}
+		v, err := parseFloats(args[1:3])
+		if err != nil {
+			return nil, nil, err
+		}
+		return geom.Point{v[1], v[0]}, args[3:], nil
+	case "BOUNDS":
+		if len(args) < 5 {
+			return nil, nil, errWrongArgs(cmd)
+		}
+		v, err := parseFloats(args[1:5])
+		if err != nil {
+			return nil, nil, err
+		}
+		if v[0] > v[2] || v[1] > v[3] {
+			return nil, nil, errors.New("invalid bounds")
+		}
+		return geom.Box([]float64{v[1], v[0]}, []float64{v[3], v[2]}), args[5:], nil
+	case "OBJECT":
+		if len(args) < 2 {
+			return nil, nil, errWrongArgs(cmd)
+		}
+		g, err := geojson.ParseGeometry([]byte(args[1]), 2)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid object: %v", err)
+		}
+		if min, _ := g.Rect(); min == nil {
+			return nil, nil, errors.New("invalid object: empty geometry")
+		}
+		return g, args[2:], nil
+	}
+	return nil, nil, fmt.Errorf("invalid argument '%s'", args[0])
+}
+
+// set runs SET key id area.
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 4 {
+		return errWrongArgs(args[0])
+	}
+	g, rest, err := parseArea(args[0], args[3:])
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errWrongArgs(args[0])
+	}
+	key, id := args[1], args[2]
+	o := &object{id: id, g: g}
+	o.min, o.max = g.Rect()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.collections[key]
+	if c == nil {
+		c = &collection{tr: rbush.New(2), byID: make(map[string]*object)}
+		s.collections[key] = c
+	}
+	if old := c.byID[id]; old != nil {
+		c.tr.Update(old, o)
+	} else {
+		c.tr.Insert(o)
+		i := sort.SearchStrings(c.ids, id)
+		c.ids = append(c.ids, "")
+		copy(c.ids[i+1:], c.ids[i:])
+		c.ids[i] = id
+	}
+	c.byID[id] = o
+	return Status("OK")
+}
+
+func marshalObject(o *object) string {
+	data, err := geojson.MarshalGeometry(o.g)
+	if err != nil {
+		// the geometries are parsed or built by the server
+		panic(err)
+	}
+	return string(data)
+}
+
+// get runs GET key id.
+func (s *Server) get(args []string) interface{} {
+	if len(args) != 3 {
+		return errWrongArgs(args[0])
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c := s.collections[args[1]]; c != nil {
+		if o := c.byID[args[2]]; o != nil {
+			return marshalObject(o)
+		}
+	}
+	return nil
+}
+
+// del runs DEL key id, which replies the number of removed objects.
+func (s *Server) del(args []string) interface{} {
+	if len(args) != 3 {
+		return errWrongArgs(args[0])
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.collections[args[1]]
+	if c == nil || c.byID[args[2]] == nil {
+		return 0
+	}
+	c.tr.Remove(c.byID[args[2]])
+	delete(c.byID, args[2])
+	i := sort.SearchStrings(c.ids, args[2])
+	c.ids = append(c.ids[:i], c.ids[i+1:]...)
+	if len(c.byID) == 0 {
+		delete(s.collections, args[1])
+	}
+	return 1
+}
+
+// page is the CURSOR and LIMIT of a search.
+type page struct {
+	cursor, limit int
+}
+
+// parsePage parses the options that come before the area of a search.
+func parsePage(cmd string, args []string) (page, []string, error) {
+	p := page{limit: defaultLimit}
+	for len(args) > 0 {
+		var v *int
+		switch strings.ToUpper(args[0]) {
+		case "CURSOR":
+			v = &p.cursor
+		case "LIMIT":
+			v = &p.limit
+		default:
+			return p, args, nil
+		}
+		if len(args) < 2 {
+			return p, nil, errWrongArgs(cmd)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return p, nil, fmt.Errorf("invalid argument '%s'", args[1])
+		}
+		*v = n
+		args = args[2:]
+	}
+	return p, args, nil
+}
+
+// results collects the objects of a page.
+type results struct {
+	page
+	n    int
+	objs []interface{}
+	next int
+}
+
+// add adds an object, and reports whether more are wanted.
+func (r *results) add(o *object) bool {
+	if r.n >= r.cursor {
+		if len(r.objs) == r.limit {
+			r.next = r.n
+			return false
+		}
+		r.objs = append(r.objs, []interface{}{o.id, marshalObject(o)})
+	}
+	r.n++
+	return true
+}
+
+func (r *results) reply() interface{} {
+	if r.objs == nil {
+		r.objs = []interface{}{}
+	}
+	return []interface{}{r.next, r.objs}
+}
+
+// search runs WITHIN or INTERSECTS.
+func (s *Server) search(args []string, pred rbush.Predicate) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs(args[0])
+	}
+	p, rest, err := parsePage(args[0], args[2:])
+	if err != nil {
+		return err
+	}
+	area, rest, err := parseArea(args[0], rest)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errWrongArgs(args[0])
+	}
+	r := &results{page: p}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c := s.collections[args[1]]; c != nil {
+		c.tr.SearchGeometry(area, pred, func(item rbush.Item) bool {
+			return r.add(item.(*object))
+		})
+	}
+	return r.reply()
+}
+
+// nearby runs NEARBY, which replies the objects in order of distance.
+func (s *Server) nearby(args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs(args[0])
+	}
+	p, rest, err := parsePage(args[0], args[2:])
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 || !strings.EqualFold(rest[0], "POINT") {
+		return errors.New("expected POINT")
+	}
+	area, rest, err := parseArea(args[0], rest)
+	if err != nil {
+		return err
+	}
+	radius := math.Inf(1)
+	switch len(rest) {
+	case 0:
+	case 1:
+		v, err := parseFloats(rest)
+		if err != nil {
+			return err
+		}
+		if v[0] < 0 {
+			return fmt.Errorf("invalid argument '%s'", rest[0])
+		}
+		radius = v[0]
+	default:
+		return errWrongArgs(args[0])
+	}
+	r := &results{page: p}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c := s.collections[args[1]]; c != nil {
+		// KNN reports squared distances
+		c.tr.KNN(area.(geom.Point), func(item rbush.Item, dist float64) bool {
+			return dist <= radius*radius && r.add(item.(*object))
+		})
+	}
+	return r.reply()
+}
+
+// scan runs SCAN, which replies the objects in order of id.
+func (s *Server) scan(args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs(args[0])
+	}
+	p, rest, err := parsePage(args[0], args[2:])
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return errWrongArgs(args[0])
+	}
+	r := &results{page: p}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if c := s.collections[args[1]]; c != nil && p.cursor < len(c.ids) {
+		// the cursor is a position in the sorted ids
+		r.n = p.cursor
+		for _, id := range c.ids[p.cursor:] {
+			if !r.add(c.byID[id]) {
+				break
+			}
+		}
+	}
+	return r.reply()
+}
+
+// bounds runs BOUNDS key, which replies the box of the collection as
+// GeoJSON. The box is a polygon unless it has no area.
+func (s *Server) bounds(args []string) interface{} {
+	if len(args) != 2 {
+		return errWrongArgs(args[0])
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c := s.collections[args[1]]
+	if c == nil {
+		return nil
+	}
+	min, max := c.tr.Bounds()
+	return marshalObject(&object{g: geom.Box(min, max)})
+}
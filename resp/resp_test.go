@@ -0,0 +1,275 @@
+package resp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush/resp"
+)
+
+// client is a raw RESP client.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *client {
+	conn, err := net.Dial("tcp", addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t, conn, bufio.NewReader(conn)}
+}
+
+func (c *client) send(args ...string) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, sb.String())
+	assert.NoError(c.t, err)
+}
+
+// read reads a reply, where an error is "ERR ..." with a leading '-', an
+// integer is an int, a bulk string is a string, and an array is a
+// []interface{}.
+func (c *client) read() interface{} {
+	line, err := c.r.ReadString('\n')
+	if !assert.NoError(c.t, err) {
+		return nil
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return line
+	case ':':
+		n, err := strconv.Atoi(line[1:])
+		assert.NoError(c.t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		assert.NoError(c.t, err)
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		assert.NoError(c.t, err)
+		return string(buf[:n])
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		assert.NoError(c.t, err)
+		arr := []interface{}{}
+		for i := 0; i < n; i++ {
+			arr = append(arr, c.read())
+		}
+		return arr
+	}
+	c.t.Fatalf("invalid reply %q", line)
+	return nil
+}
+
+func (c *client) do(args ...string) interface{} {
+	c.send(args...)
+	return c.read()
+}
+
+// ids returns the ids of the reply of a search, and its cursor.
+func (c *client) ids(args ...string) (int, []string) {
+	reply, ok := c.do(args...).([]interface{})
+	if !assert.True(c.t, ok, "%v", args) || !assert.Len(c.t, reply, 2) {
+		return 0, nil
+	}
+	ids := []string{}
+	for _, pair := range reply[1].([]interface{}) {
+		ids = append(ids, pair.([]interface{})[0].(string))
+	}
+	return reply[0].(int), ids
+}
+
+func start(t *testing.T) (*resp.Server, string) {
+	s := resp.New()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		assert.Equal(t, resp.ErrServerClosed, <-done)
+	})
+	return s, l.Addr().String()
+}
+
+func TestCommands(t *testing.T) {
+	_, addr := start(t)
+	c := dial(t, addr)
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, "OK", c.do("SET", "fleet", "truck1", "POINT", "33.5", "-112.2"))
+	assert.Equal(t, "OK", c.do("set", "fleet", "truck2", "point", "33.6", "-112.1"))
+	assert.Equal(t, "OK", c.do("SET", "fleet", "depot", "BOUNDS", "30", "-115", "31", "-114"))
+	assert.Equal(t, "OK", c.do("SET", "fleet", "route", "OBJECT",
+		`{"type":"LineString","coordinates":[[-112,33],[-111,34]]}`))
+
+	assert.Equal(t, `{"type":"Point","coordinates":[-112.2,33.5]}`, c.do("GET", "fleet", "truck1"))
+	assert.Nil(t, c.do("GET", "fleet", "nope"))
+	assert.Nil(t, c.do("GET", "nope", "truck1"))
+
+	cursor, ids := c.ids("SCAN", "fleet")
+	assert.Equal(t, 0, cursor)
+	assert.Equal(t, []string{"depot", "route", "truck1", "truck2"}, ids)
+	cursor, ids = c.ids("SCAN", "fleet", "LIMIT", "3")
+	assert.Equal(t, 3, cursor)
+	assert.Equal(t, []string{"depot", "route", "truck1"}, ids)
+	cursor, ids = c.ids("SCAN", "fleet", "CURSOR", "3", "LIMIT", "3")
+	assert.Equal(t, 0, cursor)
+	assert.Equal(t, []string{"truck2"}, ids)
+	cursor, ids = c.ids("SCAN", "fleet", "CURSOR", "9")
+	assert.Equal(t, 0, cursor)
+	assert.Empty(t, ids)
+
+	_, ids = c.ids("WITHIN", "fleet", "BOUNDS", "33", "-113", "34", "-112")
+	assert.ElementsMatch(t, []string{"truck1", "truck2"}, ids)
+	_, ids = c.ids("WITHIN", "fleet", "BOUNDS", "33", "-113", "35", "-110")
+	assert.ElementsMatch(t, []string{"truck1", "truck2", "route"}, ids)
+	// the box of the route intersects, but the line does not
+	_, ids = c.ids("INTERSECTS", "fleet", "BOUNDS", "33.9", "-112", "34", "-111.9")
+	assert.Empty(t, ids)
+	_, ids = c.ids("INTERSECTS", "fleet", "OBJECT",
+		`{"type":"Polygon","coordinates":[[[-116,29],[-113,29],[-113,33.55],[-116,29]]]}`)
+	assert.ElementsMatch(t, []string{"depot"}, ids)
+
+	_, ids = c.ids("NEARBY", "fleet", "POINT", "33.5", "-112.2")
+	assert.Equal(t, []string{"truck1", "truck2", "route", "depot"}, ids)
+	_, ids = c.ids("NEARBY", "fleet", "LIMIT", "2", "POINT", "33.5", "-112.2")
+	assert.Equal(t, []string{"truck1", "truck2"}, ids)
+	_, ids = c.ids("NEARBY", "fleet", "POINT", "33.5", "-112.2", "0.1")
+	assert.Equal(t, []string{"truck1"}, ids)
+
+	assert.Equal(t, `{"type":"Polygon","coordinates":[[[-115,30],[-111,30],[-111,34],[-115,34],[-115,30]]]}`,
+		c.do("BOUNDS", "fleet"))
+	assert.Nil(t, c.do("BOUNDS", "nope"))
+
+	// replacing an object moves it
+	assert.Equal(t, "OK", c.do("SET", "fleet", "truck1", "POINT", "30.5", "-114.5"))
+	_, ids = c.ids("WITHIN", "fleet", "BOUNDS", "33", "-113", "34", "-112")
+	assert.Equal(t, []string{"truck2"}, ids)
+	assert.Equal(t, 1, c.do("DEL", "fleet", "truck1"))
+	assert.Equal(t, 0, c.do("DEL", "fleet", "truck1"))
+	_, ids = c.ids("SCAN", "fleet")
+	assert.Equal(t, []string{"depot", "route", "truck2"}, ids)
+	_, ids = c.ids("SCAN", "nope")
+	assert.Empty(t, ids)
+}
+
+func TestErrors(t *testing.T) {
+	_, addr := start(t)
+	c := dial(t, addr)
+	for _, args := range [][]string{
+		{"NOPE"},
+		{"SET", "fleet", "truck1"},
+		{"SET", "fleet", "truck1", "POINT", "33"},
+		{"SET", "fleet", "truck1", "POINT", "33", "x"},
+		{"SET", "fleet", "truck1", "POINT", "33", "-112", "extra"},
+		{"SET", "fleet", "truck1", "CIRCLE", "33", "-112"},
+		{"SET", "fleet", "truck1", "BOUNDS", "34", "0", "33", "1"},
+		{"SET", "fleet", "truck1", "OBJECT", `{"type":"Point"}`},
+		{"SET", "fleet", "truck1", "OBJECT", `{"type":"Point","coordinates":[]}`},
+		{"GET", "fleet"},
+		{"WITHIN", "fleet", "LIMIT"},
+		{"WITHIN", "fleet", "LIMIT", "-1", "BOUNDS", "0", "0", "1", "1"},
+		{"NEARBY", "fleet", "BOUNDS", "0", "0", "1", "1"},
+		{"NEARBY", "fleet", "POINT", "0", "0", "-1"},
+		{"SCAN", "fleet", "MATCH", "*"},
+	} {
+		reply, _ := c.do(args...).(string)
+		assert.True(t, strings.HasPrefix(reply, "-ERR "), "%v: %q", args, reply)
+	}
+	_, ids := c.ids("SCAN", "fleet")
+	assert.Empty(t, ids)
+
+	// empty and negative multibulk lengths are empty commands
+	io.WriteString(c.conn, "*0\r\n*-1\r\nPING\r\n")
+	assert.Equal(t, "PONG", c.read())
+}
+
+func TestInline(t *testing.T) {
+	_, addr := start(t)
+	c := dial(t, addr)
+	// inline commands and pipelined commands
+	io.WriteString(c.conn, "PING\r\nSET fleet truck1 POINT 1 2\r\n\r\nGET fleet truck1\n")
+	assert.Equal(t, "PONG", c.read())
+	assert.Equal(t, "OK", c.read())
+	assert.Equal(t, `{"type":"Point","coordinates":[2,1]}`, c.read())
+	assert.Equal(t, "OK", c.do("QUIT"))
+	_, err := c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// a protocol error closes the connection
+	c = dial(t, addr)
+	io.WriteString(c.conn, "*1\r\n+PING\r\n")
+	reply, _ := c.read().(string)
+	assert.True(t, strings.HasPrefix(reply, "-ERR Protocol error"), reply)
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+
+	// and so does a line that is too long
+	c = dial(t, addr)
+	io.WriteString(c.conn, "PING "+strings.Repeat("x", 64<<10)+"\r\n")
+	reply, _ = c.read().(string)
+	assert.True(t, strings.HasPrefix(reply, "-ERR Protocol error"), reply)
+	_, err = c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDo(t *testing.T) {
+	s := resp.New()
+	assert.Equal(t, 0, s.Do("DEL", "fleet", "truck1"))
+	assert.Nil(t, s.Do("BOUNDS", "fleet"))
+	s.Do("SET", "fleet", "truck1", "POINT", "1", "2")
+	assert.Equal(t, resp.Status("PONG"), s.Do("PING"))
+	assert.Equal(t, `{"type":"Point","coordinates":[2,1]}`, s.Do("BOUNDS", "fleet"))
+	assert.Error(t, s.Do().(error))
+}
+
+func TestScanPages(t *testing.T) {
+	s := resp.New()
+	var expect []string
+	for _, i := range rand.Perm(1000) {
+		id := fmt.Sprintf("id%04d", i)
+		s.Do("SET", "fleet", id, "POINT", "1", "2")
+		if i%3 == 0 {
+			s.Do("DEL", "fleet", id)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if i%3 != 0 {
+			expect = append(expect, fmt.Sprintf("id%04d", i))
+		}
+	}
+	var ids []string
+	cursor := 0
+	for {
+		reply := s.Do("SCAN", "fleet", "CURSOR", fmt.Sprint(cursor), "LIMIT", "100").([]interface{})
+		for _, obj := range reply[1].([]interface{}) {
+			ids = append(ids, obj.([]interface{})[0].(string))
+		}
+		if cursor = reply[0].(int); cursor == 0 {
+			break
+		}
+	}
+	assert.Equal(t, expect, ids)
+}
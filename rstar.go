@@ -0,0 +1,130 @@
+package rbush
+
+import "sort"
+
+// InsertStrategy selects how new entries are placed in the tree.
+type InsertStrategy int
+
+const (
+	// InsertDefault descends into the child needing the least area
+	// enlargement, and splits nodes as soon as they overflow.
+	InsertDefault InsertStrategy = iota
+	// InsertRStar uses the R*-tree insertion. At the level above the leaves
+	// it descends into the child needing the least overlap enlargement, and
+	// an overflowing node first reinserts its farthest entries, once per
+	// level for each insertion, before it is split.
+	InsertRStar
+)
+
+// reinsertFactor is the portion of a node's entries that are removed and
+// reinserted on overflow. The R*-tree paper found 30% to work best.
+const reinsertFactor = 0.3
+
+// chooseLeastOverlap returns the child of node whose overlap with its
+// siblings grows the least when extended by bbox. Ties are resolved by the
+// least area enlargement, and then the smallest area.
+func chooseLeastOverlap(bbox, node *treeNode) *treeNode {
+	var target *treeNode
+	var minOverlap, minEnlargement, minArea float64
+	enlarged := treeNode{
+		min: make([]float64, len(bbox.min)),
+		max: make([]float64, len(bbox.max)),
+	}
+	for i, ptr := range node.children {
+		child := ptr.(*treeNode)
+		copy(enlarged.min, child.min)
+		copy(enlarged.max, child.max)
+		enlarged.extend(bbox)
+		var overlap float64
+		for j, ptr := range node.children {
+			if j == i {
+				continue
+			}
+			sibling := ptr.(*treeNode)
+			overlap += enlarged.intersectionArea(sibling) -
+				child.intersectionArea(sibling)
+		}
+		area := child.area()
+		enlargement := enlarged.area() - area
+		if target == nil || overlap < minOverlap ||
+			(overlap == minOverlap && (enlargement < minEnlargement ||
+				(enlargement == minEnlargement && area < minArea))) {
+			target = child
+			minOverlap = overlap
+			minEnlargement = enlargement
+			minArea = area
+		}
+	}
+	return target
+}
+
+// forceReinsert removes the entries farthest from the center of the
+// overflowing node at level and inserts them again, closest first.
+func (tr *RBush) forceReinsert(path []*treeNode, level int) {
+	node := path[level]
+	tr.reinserted |= 1 << uint(node.height)
+
+	center := make([]float64, tr.dims)
+	for i := 0; i < tr.dims; i++ {
+		center[i] = (node.min[i] + node.max[i]) / 2
+	}
+	dists := make([]float64, len(node.children))
+	for i, ptr := range node.children {
+		bbox := entryBBox(ptr, node.leaf)
+		var dist float64
+		for j := 0; j < tr.dims; j++ {
+			d := (bbox.min[j]+bbox.max[j])/2 - center[j]
+			dist += d * d
+		}
+		dists[i] = dist
+	}
+	sort.Sort(&byDist{node.children, dists})
+
+	p := int(float64(len(node.children)) * reinsertFactor)
+	if p < 1 {
+		p = 1
+	}
+	keep := len(node.children) - p
+	entries := make([]interface{}, p)
+	copy(entries, node.children[keep:])
+	for i := keep; i < len(node.children); i++ {
+		node.children[i] = nil
+	}
+	node.children = node.children[:keep]
+	for i := level; i >= 0; i-- {
+		tr.refresh(path[i])
+	}
+
+	for _, entry := range entries {
+		if child, ok := entry.(*treeNode); ok {
+			tr.insert(child, child, tr.data.height-child.height-1, true)
+		} else {
+			bbox := entryBBox(entry, true)
+			tr.insert(&bbox, entry, tr.data.height-1, false)
+		}
+	}
+}
+
+// entryBBox returns the bbox of a node child.
+func entryBBox(ptr interface{}, leaf bool) treeNode {
+	var bbox treeNode
+	if leaf {
+		fillBBox(ptr.(Item), &bbox)
+	} else {
+		child := ptr.(*treeNode)
+		bbox.min, bbox.max = child.min, child.max
+	}
+	return bbox
+}
+
+type byDist struct {
+	children []interface{}
+	dists    []float64
+}
+
+func (arr *byDist) Len() int           { return len(arr.children) }
+func (arr *byDist) Less(i, j int) bool { return arr.dists[i] < arr.dists[j] }
+func (arr *byDist) Swap(i, j int) {
+	arr.children[i], arr.children[j] = arr.children[j], arr.children[i]
+	arr.dists[i], arr.dists[j] = arr.dists[j], arr.dists[i]
+}
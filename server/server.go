@@ -0,0 +1,699 @@
+// Package server serves named trees of GeoJSON features over HTTP.
+//
+//	PUT    /indexes/{name}?dims=2          create an index
+//	DELETE /indexes/{name}                 drop an index
+//	GET    /indexes                        list the indexes
+//	POST   /indexes/{name}/items           insert or replace features
+//	GET    /indexes/{name}/items/{id}      get a feature
+//	DELETE /indexes/{name}/items/{id}      remove a feature
+//	GET    /indexes/{name}/search?bbox=minx,miny,maxx,maxy
+//	GET    /indexes/{name}/knn?point=x,y&k=10
+//	GET    /indexes/{name}/radius?point=x,y&r=5
+//	GET    /indexes/{name}/stats
+//
+// Features are kept by their id, and inserting a feature with an id that
+// is already in the index replaces it. The body of an insert is a Feature
+// or a FeatureCollection, and an index that does not exist is created.
+//
+// Results are a FeatureCollection, or one feature per line when the
+// request has format=ndjson or accepts application/x-ndjson. The results
+// of knn and radius are in order of distance to the box of the features.
+// Errors are an object with an "error" member.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geojson"
+)
+
+// Options for New.
+type Options struct {
+	// Dir is the directory the indexes are saved to and loaded from. The
+	// indexes are only kept in memory when it is empty.
+	Dir string
+	// Dims is the number of dimensions of indexes that are created without
+	// a dims parameter. Default is 2.
+	Dims int
+	// MaxBody is the largest request body that is read. Default is 64 MiB.
+	MaxBody int64
+}
+
+// Server serves the indexes. It is an http.Handler.
+type Server struct {
+	opts    Options
+	mu      sync.RWMutex
+	indexes map[string]*index
+	http    *http.Server
+}
+
+type index struct {
+	mu   sync.RWMutex
+	dims int
+	tr   *rbush.RBush
+	byID map[string]*geojson.Feature
+}
+
+func newIndex(dims int) *index {
+	return &index{dims: dims, tr: rbush.New(dims), byID: make(map[string]*geojson.Feature)}
+}
+
+// featureCodec encodes features as GeoJSON for snapshots.
+type featureCodec struct{ dims int }
+
+func (c featureCodec) MarshalItem(item rbush.Item) ([]byte, error) {
+	return json.Marshal(item)
+}
+
+func (c featureCodec) UnmarshalItem(data []byte) (rbush.Item, error) {
+	return geojson.ParseFeature(data, c.dims)
+}
+
+// manifest is the file in Dir that lists the saved indexes.
+const manifest = "indexes.json"
+
+// New returns a server, with the indexes that were saved to opts.Dir.
+func New(opts *Options) (*Server, error) {
+	s := &Server{indexes: make(map[string]*index)}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Dims <= 0 {
+		s.opts.Dims = 2
+	}
+	if s.opts.MaxBody <= 0 {
+		s.opts.MaxBody = 64 << 20
+	}
+	if s.opts.Dir == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(filepath.Join(s.opts.Dir, manifest))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var dims map[string]int
+	if err := json.Unmarshal(data, &dims); err != nil {
+		return nil, fmt.Errorf("%s: %v", manifest, err)
+	}
+	for name, n := range dims {
+		idx, err := loadIndex(filepath.Join(s.opts.Dir, name+".snap"), n)
+		if err != nil {
+			return nil, err
+		}
+		s.indexes[name] = idx
+	}
+	return s, nil
+}
+
+func loadIndex(path string, dims int) (*index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr, err := rbush.ReadSnapshot(f, featureCodec{dims}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	idx := newIndex(dims)
+	idx.tr = tr
+	tr.Scan(func(item rbush.Item) bool {
+		f := item.(*geojson.Feature)
+		idx.byID[featureID(f)] = f
+		return true
+	})
+	return idx, nil
+}
+
+// Save writes a snapshot of every index to Dir. Each file is replaced
+// once it is complete, so a failed save leaves the last one in place.
+func (s *Server) Save() error {
+	if s.opts.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.opts.Dir, 0755); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dims := make(map[string]int)
+	for name, idx := range s.indexes {
+		idx.mu.RLock()
+		err := writeFile(filepath.Join(s.opts.Dir, name+".snap"), func(w io.Writer) error {
+			return idx.tr.WriteSnapshot(w, featureCodec{idx.dims})
+		})
+		idx.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		dims[name] = idx.dims
+	}
+	err := writeFile(filepath.Join(s.opts.Dir, manifest), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(dims)
+	})
+	if err != nil {
+		return err
+	}
+	// remove the snapshots of dropped indexes
+	paths, _ := filepath.Glob(filepath.Join(s.opts.Dir, "*.snap"))
+	for _, path := range paths {
+		if _, ok := dims[strings.TrimSuffix(filepath.Base(path), ".snap")]; !ok {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// writeFile writes a file through a temporary file that is renamed over
+// the path once it is synced.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Serve accepts connections on l until Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.http == nil {
+		s.http = &http.Server{Handler: s}
+	}
+	srv := s.http
+	s.mu.Unlock()
+	err := srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// ListenAndServe listens on the TCP address and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Shutdown stops accepting connections, waits for the requests in progress
+// to finish or for ctx to be done, and then saves the indexes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.http
+	if srv == nil {
+		// a closed server, so that a later Serve returns at once
+		s.http = &http.Server{Handler: s}
+		s.http.Close()
+	}
+	s.mu.Unlock()
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return s.Save()
+}
+
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func errorf(code int, format string, args ...interface{}) error {
+	return &httpError{code, fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var herr *httpError
+	if errors.As(err, &herr) {
+		code = herr.code
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.serve(w, r); err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) error {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "indexes" {
+		return errorf(http.StatusNotFound, "not found")
+	}
+	parts = parts[1:]
+	switch {
+	case len(parts) == 0:
+		if r.Method != http.MethodGet {
+			return errorf(http.StatusMethodNotAllowed, "method not allowed")
+		}
+		s.list(w)
+		return nil
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodPut:
+			return s.create(w, r, parts[0])
+		case http.MethodDelete:
+			return s.drop(w, parts[0])
+		}
+		return errorf(http.StatusMethodNotAllowed, "method not allowed")
+	case len(parts) == 2 && parts[1] == "items" && r.Method == http.MethodPost:
+		return s.insert(w, r, parts[0])
+	case len(parts) == 3 && parts[1] == "items":
+		switch r.Method {
+		case http.MethodGet:
+			return s.get(w, parts[0], parts[2])
+		case http.MethodDelete:
+			return s.remove(w, parts[0], parts[2])
+		}
+		return errorf(http.StatusMethodNotAllowed, "method not allowed")
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		idx, err := s.index(parts[0])
+		if err != nil {
+			return err
+		}
+		switch parts[1] {
+		case "search":
+			return idx.search(w, r)
+		case "knn":
+			return idx.knn(w, r)
+		case "radius":
+			return idx.radius(w, r)
+		case "stats":
+			idx.stats(w)
+			return nil
+		}
+	}
+	return errorf(http.StatusNotFound, "not found")
+}
+
+// validName reports whether an index name can be used as a file name.
+func validName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) index(name string) (*index, error) {
+	s.mu.RLock()
+	idx := s.indexes[name]
+	s.mu.RUnlock()
+	if idx == nil {
+		return nil, errorf(http.StatusNotFound, "index %q not found", name)
+	}
+	return idx, nil
+}
+
+type indexInfo struct {
+	Name  string `json:"name"`
+	Dims  int    `json:"dims"`
+	Count int    `json:"count"`
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.RLock()
+	infos := []indexInfo{}
+	for name, idx := range s.indexes {
+		idx.mu.RLock()
+		infos = append(infos, indexInfo{name, idx.dims, idx.tr.Count()})
+		idx.mu.RUnlock()
+	}
+	s.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request, name string) error {
+	if !validName(name) {
+		return errorf(http.StatusBadRequest, "invalid index name %q", name)
+	}
+	dims := s.opts.Dims
+	if v := r.URL.Query().Get("dims"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return errorf(http.StatusBadRequest, "invalid dims %q", v)
+		}
+		dims = n
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if idx := s.indexes[name]; idx != nil {
+		if idx.dims != dims {
+			return errorf(http.StatusConflict, "index %q has %d dimensions", name, idx.dims)
+		}
+		writeJSON(w, http.StatusOK, indexInfo{name, dims, 0})
+		return nil
+	}
+	s.indexes[name] = newIndex(dims)
+	writeJSON(w, http.StatusCreated, indexInfo{name, dims, 0})
+	return nil
+}
+
+func (s *Server) drop(w http.ResponseWriter, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.indexes[name] == nil {
+		return errorf(http.StatusNotFound, "index %q not found", name)
+	}
+	delete(s.indexes, name)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// featureID returns the id of a feature, which is the text of a string
+// id or the JSON of a number id.
+func featureID(f *geojson.Feature) string {
+	var s string
+	if json.Unmarshal(f.ID, &s) == nil {
+		return s
+	}
+	return string(f.ID)
+}
+
+func (s *Server) insert(w http.ResponseWriter, r *http.Request, name string) error {
+	if !validName(name) {
+		return errorf(http.StatusBadRequest, "invalid index name %q", name)
+	}
+	dims := s.opts.Dims
+	s.mu.RLock()
+	if idx := s.indexes[name]; idx != nil {
+		dims = idx.dims
+	}
+	s.mu.RUnlock()
+	// the body is read before the index is locked
+	var features []*geojson.Feature
+	err := geojson.Decode(http.MaxBytesReader(w, r.Body, s.opts.MaxBody), dims, func(f *geojson.Feature) error {
+		if len(f.ID) == 0 {
+			return errors.New("feature has no id")
+		}
+		if min, _ := f.Rect(); min == nil {
+			return fmt.Errorf("feature %s has no geometry", f.ID)
+		}
+		features = append(features, f)
+		return nil
+	})
+	if err != nil {
+		return errorf(http.StatusBadRequest, "%v", err)
+	}
+	s.mu.Lock()
+	idx := s.indexes[name]
+	if idx == nil {
+		idx = newIndex(dims)
+		s.indexes[name] = idx
+	}
+	s.mu.Unlock()
+	if idx.dims != dims {
+		return errorf(http.StatusConflict, "index %q has %d dimensions", name, idx.dims)
+	}
+	idx.mu.Lock()
+	for _, f := range features {
+		id := featureID(f)
+		if old := idx.byID[id]; old != nil {
+			idx.tr.Update(old, f)
+		} else {
+			idx.tr.Insert(f)
+		}
+		idx.byID[id] = f
+	}
+	count := idx.tr.Count()
+	idx.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]int{"inserted": len(features), "count": count})
+	return nil
+}
+
+func (s *Server) get(w http.ResponseWriter, name, id string) error {
+	idx, err := s.index(name)
+	if err != nil {
+		return err
+	}
+	idx.mu.RLock()
+	f := idx.byID[id]
+	idx.mu.RUnlock()
+	if f == nil {
+		return errorf(http.StatusNotFound, "feature %q not found", id)
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	return json.NewEncoder(w).Encode(f)
+}
+
+func (s *Server) remove(w http.ResponseWriter, name, id string) error {
+	idx, err := s.index(name)
+	if err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	f := idx.byID[id]
+	if f != nil {
+		idx.tr.Remove(f)
+		delete(idx.byID, id)
+	}
+	idx.mu.Unlock()
+	if f == nil {
+		return errorf(http.StatusNotFound, "feature %q not found", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// coords parses a query parameter of n comma separated numbers.
+func coords(r *http.Request, param string, n int) ([]float64, error) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return nil, errorf(http.StatusBadRequest, "missing %s", param)
+	}
+	fields := strings.Split(v, ",")
+	if len(fields) != n {
+		return nil, errorf(http.StatusBadRequest, "%s has %d numbers, expected %d", param, len(fields), n)
+	}
+	values := make([]float64, n)
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(f) {
+			return nil, errorf(http.StatusBadRequest, "invalid %s %q", param, v)
+		}
+		values[i] = f
+	}
+	return values, nil
+}
+
+// number parses a query parameter, which is def when it is missing.
+func number(r *http.Request, param string, def float64) (float64, error) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || f < 0 {
+		return 0, errorf(http.StatusBadRequest, "invalid %s %q", param, v)
+	}
+	return f, nil
+}
+
+// integer parses a query parameter that is a positive integer, which is def
+// when it is missing.
+func integer(r *http.Request, param string, def int) (int, error) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, errorf(http.StatusBadRequest, "invalid %s %q", param, v)
+	}
+	return n, nil
+}
+
+type box struct{ min, max []float64 }
+
+func (b *box) Rect() (min, max []float64) {
+	return b.min, b.max
+}
+
+// snapshot returns a copy of the tree that can be read while the index
+// changes. The tree only copies the nodes that it changes afterwards.
+func (idx *index) snapshot() *rbush.Tx {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.tr.Begin()
+}
+
+func (idx *index) search(w http.ResponseWriter, r *http.Request) error {
+	values, err := coords(r, "bbox", idx.dims*2)
+	if err != nil {
+		return err
+	}
+	limit, err := integer(r, "limit", 0)
+	if err != nil {
+		return err
+	}
+	tx := idx.snapshot()
+	defer tx.Rollback()
+	return writeFeatures(w, r, func(write func(f *geojson.Feature) bool) {
+		n := 0
+		tx.Search(&box{values[:idx.dims], values[idx.dims:]}, func(item rbush.Item) bool {
+			n++
+			return write(item.(*geojson.Feature)) && (limit == 0 || n < limit)
+		})
+	})
+}
+
+func (idx *index) knn(w http.ResponseWriter, r *http.Request) error {
+	point, err := coords(r, "point", idx.dims)
+	if err != nil {
+		return err
+	}
+	k, err := integer(r, "k", 1)
+	if err != nil {
+		return err
+	}
+	tx := idx.snapshot()
+	defer tx.Rollback()
+	return writeFeatures(w, r, func(write func(f *geojson.Feature) bool) {
+		n := 0
+		tx.KNN(point, func(item rbush.Item, dist float64) bool {
+			n++
+			return write(item.(*geojson.Feature)) && n < k
+		})
+	})
+}
+
+func (idx *index) radius(w http.ResponseWriter, r *http.Request) error {
+	point, err := coords(r, "point", idx.dims)
+	if err != nil {
+		return err
+	}
+	radius, err := number(r, "r", -1)
+	if err != nil {
+		return err
+	} else if radius < 0 {
+		return errorf(http.StatusBadRequest, "missing r")
+	}
+	limit, err := integer(r, "limit", 0)
+	if err != nil {
+		return err
+	}
+	tx := idx.snapshot()
+	defer tx.Rollback()
+	return writeFeatures(w, r, func(write func(f *geojson.Feature) bool) {
+		n := 0
+		// KNN reports squared distances
+		tx.KNN(point, func(item rbush.Item, dist float64) bool {
+			if dist > radius*radius {
+				return false
+			}
+			n++
+			return write(item.(*geojson.Feature)) && (limit == 0 || n < limit)
+		})
+	})
+}
+
+type indexStats struct {
+	Dims   int       `json:"dims"`
+	Count  int       `json:"count"`
+	Min    []float64 `json:"min"`
+	Max    []float64 `json:"max"`
+	Height int       `json:"height"`
+	Nodes  int       `json:"nodes"`
+}
+
+func (idx *index) stats(w http.ResponseWriter) {
+	idx.mu.RLock()
+	st := idx.tr.Stats()
+	min, max := idx.tr.Bounds()
+	info := indexStats{idx.dims, st.Items, min, max, st.Height, st.Nodes}
+	idx.mu.RUnlock()
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ndjson reports whether the results are written one feature per line.
+func ndjson(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "ndjson"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// writeFeatures writes the features that each finds, as they are found.
+// Lines of NDJSON are flushed in batches, so a client can read them before
+// the last one is found. write reports false once the client is gone.
+func writeFeatures(w http.ResponseWriter, r *http.Request, each func(write func(f *geojson.Feature) bool)) error {
+	if !ndjson(r) {
+		w.Header().Set("Content-Type", "application/geo+json")
+		gw := geojson.NewWriter(w)
+		ok := true
+		each(func(f *geojson.Feature) bool {
+			ok = gw.Write(f) == nil
+			return ok
+		})
+		if ok {
+			gw.Close()
+		}
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	n := 0
+	each(func(f *geojson.Feature) bool {
+		if err := enc.Encode(f); err != nil {
+			return false
+		}
+		n++
+		if flusher != nil && n%256 == 0 {
+			flusher.Flush()
+		}
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,271 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush/server"
+)
+
+const places = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "id": "a", "geometry": {"type": "Point", "coordinates": [1, 1]}, "properties": {"name": "A"}},
+	{"type": "Feature", "id": "b", "geometry": {"type": "Point", "coordinates": [5, 5]}},
+	{"type": "Feature", "id": 3, "geometry": {"type": "LineString", "coordinates": [[8, 0], [9, 2]]}},
+	{"type": "Feature", "id": "d", "geometry": {"type": "Polygon", "coordinates": [[[20, 20], [30, 20], [30, 30], [20, 20]]]}}
+]}`
+
+type client struct {
+	t   *testing.T
+	url string
+}
+
+// do sends a request and returns the status and the body.
+func (c *client) do(method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, c.url+path, strings.NewReader(body))
+	assert.NoError(c.t, err)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(c.t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(c.t, err)
+	return resp.StatusCode, string(data)
+}
+
+// ids returns the ids of the features of a FeatureCollection.
+func (c *client) ids(path string) []string {
+	code, body := c.do("GET", path, "")
+	assert.Equal(c.t, http.StatusOK, code, body)
+	var fc struct {
+		Type     string
+		Features []struct{ ID json.RawMessage }
+	}
+	assert.NoError(c.t, json.Unmarshal([]byte(body), &fc), body)
+	assert.Equal(c.t, "FeatureCollection", fc.Type)
+	ids := []string{}
+	for _, f := range fc.Features {
+		ids = append(ids, string(f.ID))
+	}
+	return ids
+}
+
+func start(t *testing.T, opts *server.Options) (*server.Server, *client) {
+	s, err := server.New(opts)
+	assert.NoError(t, err)
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, &client{t, ts.URL}
+}
+
+func TestServer(t *testing.T) {
+	_, c := start(t, nil)
+	code, body := c.do("POST", "/indexes/places/items", places)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"inserted": 4, "count": 4}`, body)
+
+	assert.ElementsMatch(t, []string{`"a"`, `"b"`}, c.ids("/indexes/places/search?bbox=0,0,6,6"))
+	assert.Equal(t, []string{}, c.ids("/indexes/places/search?bbox=10,10,11,11"))
+	assert.Len(t, c.ids("/indexes/places/search?bbox=0,0,100,100&limit=2"), 2)
+	assert.Equal(t, []string{`"b"`, `3`}, c.ids("/indexes/places/knn?point=6,4&k=2"))
+	assert.Equal(t, []string{`"b"`}, c.ids("/indexes/places/knn?point=6,4"))
+	assert.Equal(t, []string{`"b"`, `3`, `"a"`}, c.ids("/indexes/places/radius?point=6,4&r=7.1"))
+
+	code, body = c.do("GET", "/indexes/places/items/a", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"type": "Feature", "id": "a", "geometry": {"type": "Point", "coordinates": [1, 1]}, "properties": {"name": "A"}}`, body)
+	code, _ = c.do("GET", "/indexes/places/items/3", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a feature with the same id replaces the old one
+	code, _ = c.do("POST", "/indexes/places/items",
+		`{"type": "Feature", "id": "a", "geometry": {"type": "Point", "coordinates": [50, 50]}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{`"b"`}, c.ids("/indexes/places/search?bbox=0,0,6,6"))
+	assert.Equal(t, []string{`"a"`}, c.ids("/indexes/places/search?bbox=49,49,51,51"))
+
+	code, _ = c.do("DELETE", "/indexes/places/items/b", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = c.do("DELETE", "/indexes/places/items/b", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, []string{}, c.ids("/indexes/places/search?bbox=0,0,6,6"))
+
+	code, body = c.do("GET", "/indexes/places/stats", "")
+	assert.Equal(t, http.StatusOK, code)
+	var stats struct {
+		Dims, Count, Height int
+		Min, Max            []float64
+	}
+	assert.NoError(t, json.Unmarshal([]byte(body), &stats))
+	assert.Equal(t, 2, stats.Dims)
+	assert.Equal(t, 3, stats.Count)
+	assert.Equal(t, []float64{8, 0}, stats.Min)
+	assert.Equal(t, []float64{50, 50}, stats.Max)
+
+	code, _ = c.do("PUT", "/indexes/cubes?dims=3", "")
+	assert.Equal(t, http.StatusCreated, code)
+	code, body = c.do("GET", "/indexes", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[{"name": "cubes", "dims": 3, "count": 0}, {"name": "places", "dims": 2, "count": 3}]`, body)
+	code, _ = c.do("DELETE", "/indexes/cubes", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = c.do("GET", "/indexes/cubes/stats", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestErrors(t *testing.T) {
+	_, c := start(t, nil)
+	c.do("POST", "/indexes/places/items", places)
+	c.do("PUT", "/indexes/cubes?dims=3", "")
+	tests := []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/", "", http.StatusNotFound},
+		{"GET", "/indexes/nope/search?bbox=0,0,1,1", "", http.StatusNotFound},
+		{"GET", "/indexes/places/items/nope", "", http.StatusNotFound},
+		{"GET", "/indexes/places/nope", "", http.StatusNotFound},
+		{"POST", "/indexes", "", http.StatusMethodNotAllowed},
+		{"GET", "/indexes/places/search", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/search?bbox=0,0,1", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/search?bbox=0,0,1,x", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/knn?point=1,1&k=-1", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/knn?point=1,1&k=0", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/knn?point=1,1&k=1.5", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/search?bbox=0,0,1,1&limit=0", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/search?bbox=0,0,1,1&limit=1e9", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/radius?point=1,1&r=1&limit=-2", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/radius?point=1,1&r=1&limit=2.5", "", http.StatusBadRequest},
+		{"GET", "/indexes/places/radius?point=1,1", "", http.StatusBadRequest},
+		{"PUT", "/indexes/bad.name", "", http.StatusBadRequest},
+		{"PUT", "/indexes/cubes?dims=2", "", http.StatusConflict},
+		{"PUT", "/indexes/cubes?dims=0", "", http.StatusBadRequest},
+		{"POST", "/indexes/places/items", `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [1, 1]}}`, http.StatusBadRequest},
+		{"POST", "/indexes/places/items", `{"type": "Feature", "id": 1, "geometry": null}`, http.StatusBadRequest},
+		{"POST", "/indexes/cubes/items", `{"type": "Feature", "id": 1, "geometry": {"type": "Point", "coordinates": [1, 1]}}`, http.StatusBadRequest},
+		{"POST", "/indexes/places/items", `{"type": "Feature"`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		code, body := c.do(test.method, test.path, test.body)
+		assert.Equal(t, test.code, code, "%s %s: %s", test.method, test.path, body)
+		var e struct{ Error string }
+		assert.NoError(t, json.Unmarshal([]byte(body), &e), body)
+		assert.NotEmpty(t, e.Error)
+	}
+	// nothing was inserted by the failed requests
+	code, body := c.do("GET", "/indexes", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[{"name": "cubes", "dims": 3, "count": 0}, {"name": "places", "dims": 2, "count": 4}]`, body)
+}
+
+func TestNDJSON(t *testing.T) {
+	_, c := start(t, nil)
+	var sb strings.Builder
+	sb.WriteString(`{"type": "FeatureCollection", "features": [`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"type": "Feature", "id": %d, "geometry": {"type": "Point", "coordinates": [%d, %d]}}`,
+			i, i%100, i/100)
+	}
+	sb.WriteString(`]}`)
+	code, _ := c.do("POST", "/indexes/points/items", sb.String())
+	assert.Equal(t, http.StatusOK, code)
+
+	for _, req := range []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return http.Get(c.url + "/indexes/points/search?bbox=0,0,100,100&format=ndjson")
+		},
+		func() (*http.Response, error) {
+			req, _ := http.NewRequest("GET", c.url+"/indexes/points/search?bbox=0,0,100,100", nil)
+			req.Header.Set("Accept", "application/x-ndjson")
+			return http.DefaultClient.Do(req)
+		},
+	} {
+		resp, err := req()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+		n := 0
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			var f struct{ Type string }
+			assert.NoError(t, json.Unmarshal(sc.Bytes(), &f))
+			assert.Equal(t, "Feature", f.Type)
+			n++
+		}
+		resp.Body.Close()
+		assert.Equal(t, 1000, n)
+	}
+
+	// results are from the index as it was when the query started
+	resp, err := http.Get(c.url + "/indexes/points/search?bbox=0,0,100,100&format=ndjson")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	assert.True(t, sc.Scan())
+	for i := 0; i < 100; i++ {
+		code, _ := c.do("DELETE", fmt.Sprintf("/indexes/points/items/%d", i), "")
+		assert.Equal(t, http.StatusNoContent, code)
+	}
+	n := 1
+	for sc.Scan() {
+		n++
+	}
+	assert.Equal(t, 1000, n)
+	assert.Len(t, c.ids("/indexes/points/search?bbox=0,0,100,100"), 900)
+}
+
+func TestPersist(t *testing.T) {
+	dir := t.TempDir()
+	s, c := start(t, &server.Options{Dir: dir})
+	c.do("POST", "/indexes/places/items", places)
+	c.do("PUT", "/indexes/empty?dims=3", "")
+	c.do("PUT", "/indexes/dropped", "")
+	assert.NoError(t, s.Save())
+	c.do("DELETE", "/indexes/dropped", "")
+	c.do("DELETE", "/indexes/places/items/b", "")
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	_, c = start(t, &server.Options{Dir: dir})
+	code, body := c.do("GET", "/indexes", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[{"name": "empty", "dims": 3, "count": 0}, {"name": "places", "dims": 2, "count": 3}]`, body)
+	assert.ElementsMatch(t, []string{`"a"`}, c.ids("/indexes/places/search?bbox=0,0,6,6"))
+	code, body = c.do("GET", "/indexes/places/items/a", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"A"`)
+	// replacing a feature that was loaded from a snapshot
+	c.do("POST", "/indexes/places/items", `{"type": "Feature", "id": 3, "geometry": {"type": "Point", "coordinates": [2, 2]}}`)
+	assert.ElementsMatch(t, []string{`"a"`, `3`}, c.ids("/indexes/places/search?bbox=0,0,6,6"))
+	assert.Equal(t, []string{}, c.ids("/indexes/places/search?bbox=8,0,9,2"))
+}
+
+func TestShutdown(t *testing.T) {
+	s, err := server.New(&server.Options{Dir: t.TempDir()})
+	assert.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	c := &client{t, "http://" + l.Addr().String()}
+	code, _ := c.do("POST", "/indexes/places/items", places)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.NoError(t, <-done)
+	_, err = http.Get(c.url + "/indexes")
+	assert.Error(t, err)
+}
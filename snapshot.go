@@ -0,0 +1,135 @@
+package rbush
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// ItemCodec converts items to and from bytes for snapshots and logs.
+type ItemCodec interface {
+	MarshalItem(item Item) ([]byte, error)
+	UnmarshalItem(data []byte) (Item, error)
+}
+
+// ErrInvalidSnapshot is returned when a snapshot is malformed or corrupt.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+const snapshotMagic = "RBSNAP01"
+
+// maxEncodedItem is the largest encoded item that is accepted when reading.
+const maxEncodedItem = 64 << 20
+
+// WriteSnapshot writes every item in the tree to w.
+func (tr *RBush) WriteSnapshot(w io.Writer, codec ItemCodec) error {
+	return tr.writeSnapshot(w, codec, 0)
+}
+
+// ReadSnapshot returns a new tree holding the items of a snapshot. The tree
+// is bulk loaded with NewPacked using opts.
+func ReadSnapshot(r io.Reader, codec ItemCodec, opts *Options) (*RBush, error) {
+	tr, _, err := readSnapshot(r, codec, opts)
+	return tr, err
+}
+
+// writeSnapshot writes the items along with a sequence number, which is
+// the position in a log that the snapshot was taken at.
+func (tr *RBush) writeSnapshot(w io.Writer, codec ItemCodec, seq uint64) error {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var header [28]byte
+	copy(header[:], snapshotMagic)
+	binary.LittleEndian.PutUint32(header[8:], uint32(tr.dims))
+	binary.LittleEndian.PutUint64(header[12:], seq)
+	binary.LittleEndian.PutUint64(header[20:], uint64(tr.Count()))
+	bw.Write(header[:])
+	var err error
+	var buf [binary.MaxVarintLen64]byte
+	tr.Scan(func(item Item) bool {
+		var data []byte
+		data, err = codec.MarshalItem(item)
+		if err != nil {
+			return false
+		}
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))])
+		bw.Write(data)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[:], crc.Sum32())
+	_, err = w.Write(buf[:4])
+	return err
+}
+
+func readSnapshot(r io.Reader, codec ItemCodec, opts *Options) (*RBush, uint64, error) {
+	crc := crc32.NewIEEE()
+	br := bufio.NewReader(r)
+	tr := io.TeeReader(br, crc)
+	var header [28]byte
+	if _, err := io.ReadFull(tr, header[:]); err != nil {
+		return nil, 0, snapshotError(err)
+	}
+	if string(header[:8]) != snapshotMagic {
+		return nil, 0, ErrInvalidSnapshot
+	}
+	dims := int(binary.LittleEndian.Uint32(header[8:]))
+	seq := binary.LittleEndian.Uint64(header[12:])
+	count := binary.LittleEndian.Uint64(header[20:])
+	if dims <= 0 {
+		return nil, 0, ErrInvalidSnapshot
+	}
+	var items []Item
+	for i := uint64(0); i < count; i++ {
+		n, err := binary.ReadUvarint(byteReader{tr})
+		if err != nil {
+			return nil, 0, snapshotError(err)
+		}
+		if n > maxEncodedItem {
+			return nil, 0, ErrInvalidSnapshot
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(tr, data); err != nil {
+			return nil, 0, snapshotError(err)
+		}
+		item, err := codec.UnmarshalItem(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		min, max := item.Rect()
+		if len(min) != dims || len(max) != dims {
+			return nil, 0, ErrInvalidSnapshot
+		}
+		items = append(items, item)
+	}
+	sum := crc.Sum32()
+	var trailer [4]byte
+	if _, err := io.ReadFull(br, trailer[:]); err != nil {
+		return nil, 0, snapshotError(err)
+	}
+	if binary.LittleEndian.Uint32(trailer[:]) != sum {
+		return nil, 0, ErrInvalidSnapshot
+	}
+	return NewPacked(dims, items, opts), seq, nil
+}
+
+func snapshotError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidSnapshot
+	}
+	return err
+}
+
+type byteReader struct{ io.Reader }
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r, b[:])
+	return b[0], err
+}
@@ -0,0 +1,179 @@
+package rbush
+
+// SplitStrategy selects how an overflowing node is split in two.
+type SplitStrategy int
+
+const (
+	// SplitRStar sorts the entries along each axis and picks the axis with
+	// the least margin and the distribution with the least overlap.
+	SplitRStar SplitStrategy = iota
+	// SplitQuadratic is Guttman's quadratic split.
+	SplitQuadratic
+	// SplitLinear is Guttman's linear split.
+	SplitLinear
+)
+
+// guttmanSplit distributes the children of node into two groups using
+// Guttman's linear or quadratic algorithm. The children of the first group
+// are moved to the front of the node, and the size of that group is returned.
+func (tr *RBush) guttmanSplit(node *treeNode, m int) int {
+	bboxes := make([]treeNode, len(node.children))
+	for i, ptr := range node.children {
+		bboxes[i] = entryBBox(ptr, node.leaf)
+	}
+	group := guttmanGroups(bboxes, m, tr.dims, tr.splitting)
+
+	// move the first group to the front
+	j := 0
+	for i := range group {
+		if group[i] == 1 {
+			node.children[i], node.children[j] = node.children[j], node.children[i]
+			group[i], group[j] = group[j], group[i]
+			j++
+		}
+	}
+	return j
+}
+
+// guttmanGroups assigns each of the bboxes to group 1 or 2, with at least m
+// bboxes in each group.
+func guttmanGroups(bboxes []treeNode, m, dims int, splitting SplitStrategy) []int {
+	M := len(bboxes)
+	var s1, s2 int
+	if splitting == SplitLinear {
+		s1, s2 = linearPickSeeds(bboxes, dims)
+	} else {
+		s1, s2 = quadraticPickSeeds(bboxes, dims)
+	}
+
+	// group[i] is 1 or 2 when the entry is assigned, 0 otherwise
+	group := make([]int, M)
+	group[s1], group[s2] = 1, 2
+	g1, g2 := createNode(nil, dims), createNode(nil, dims)
+	g1.extend(&bboxes[s1])
+	g2.extend(&bboxes[s2])
+	n1, n2 := 1, 1
+	for remaining := M - 2; remaining > 0; remaining-- {
+		var next, target int
+		switch {
+		case n1+remaining == m:
+			next, target = pickAny(group), 1
+		case n2+remaining == m:
+			next, target = pickAny(group), 2
+		default:
+			if splitting == SplitLinear {
+				next = pickAny(group)
+			} else {
+				next = quadraticPickNext(bboxes, group, g1, g2)
+			}
+			a1, a2 := g1.area(), g2.area()
+			d1 := g1.enlargedArea(&bboxes[next]) - a1
+			d2 := g2.enlargedArea(&bboxes[next]) - a2
+			if d1 < d2 || (d1 == d2 && (a1 < a2 || (a1 == a2 && n1 <= n2))) {
+				target = 1
+			} else {
+				target = 2
+			}
+		}
+		group[next] = target
+		if target == 1 {
+			g1.extend(&bboxes[next])
+			n1++
+		} else {
+			g2.extend(&bboxes[next])
+			n2++
+		}
+	}
+	return group
+}
+
+func pickAny(group []int) int {
+	for i, g := range group {
+		if g == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// quadraticPickSeeds returns the pair of entries that would waste the most
+// area if they were put in the same group.
+func quadraticPickSeeds(bboxes []treeNode, dims int) (int, int) {
+	s1, s2 := 0, 1
+	maxWaste := mathInfNeg
+	for i := 0; i < len(bboxes); i++ {
+		for j := i + 1; j < len(bboxes); j++ {
+			waste := bboxes[i].enlargedArea(&bboxes[j]) -
+				bboxes[i].area() - bboxes[j].area()
+			if waste > maxWaste {
+				maxWaste = waste
+				s1, s2 = i, j
+			}
+		}
+	}
+	return s1, s2
+}
+
+// quadraticPickNext returns the unassigned entry with the greatest
+// preference for one group over the other.
+func quadraticPickNext(bboxes []treeNode, group []int, g1, g2 *treeNode) int {
+	next := -1
+	maxDiff := mathInfNeg
+	a1, a2 := g1.area(), g2.area()
+	for i := range bboxes {
+		if group[i] != 0 {
+			continue
+		}
+		d1 := g1.enlargedArea(&bboxes[i]) - a1
+		d2 := g2.enlargedArea(&bboxes[i]) - a2
+		diff := d1 - d2
+		if diff < 0 {
+			diff = -diff
+		}
+		if next == -1 || diff > maxDiff {
+			maxDiff = diff
+			next = i
+		}
+	}
+	return next
+}
+
+// linearPickSeeds returns the pair of entries with the greatest normalized
+// separation along any axis.
+func linearPickSeeds(bboxes []treeNode, dims int) (int, int) {
+	s1, s2 := 0, 1
+	maxSep := mathInfNeg
+	for axis := 0; axis < dims; axis++ {
+		// the entry with the highest low side and the one with the lowest
+		// high side
+		hi, lo := 0, 0
+		minLo, maxHi := mathInfPos, mathInfNeg
+		for i := range bboxes {
+			if bboxes[i].min[axis] > bboxes[hi].min[axis] {
+				hi = i
+			}
+			if bboxes[i].max[axis] < bboxes[lo].max[axis] {
+				lo = i
+			}
+			minLo = mathMin(minLo, bboxes[i].min[axis])
+			maxHi = mathMax(maxHi, bboxes[i].max[axis])
+		}
+		if hi == lo {
+			// pick any other entry for the low side
+			if lo == 0 {
+				lo = 1
+			} else {
+				lo = 0
+			}
+		}
+		sep := bboxes[hi].min[axis] - bboxes[lo].max[axis]
+		if width := maxHi - minLo; width > 0 {
+			sep /= width
+		}
+		if sep > maxSep {
+			maxSep = sep
+			s1, s2 = lo, hi
+		}
+	}
+	return s1, s2
+}
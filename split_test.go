@@ -0,0 +1,94 @@
+package rbush_test
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+var splitStrategies = []struct {
+	name     string
+	strategy rbush.SplitStrategy
+}{
+	{"rstar", rbush.SplitRStar},
+	{"quadratic", rbush.SplitQuadratic},
+	{"linear", rbush.SplitLinear},
+}
+
+func TestSplitStrategies(t *testing.T) {
+	for _, ss := range splitStrategies {
+		for dims := 1; dims <= 5; dims++ {
+			rand.Seed(time.Now().UnixNano())
+			tr := rbush.NewOptions(dims, &rbush.Options{Split: ss.strategy})
+			objs := make([]rbush.Item, 10000)
+			for i := 0; i < len(objs); i++ {
+				objs[i] = makeRandom("rect", dims)
+				tr.Insert(objs[i])
+			}
+			assert.Equal(t, len(objs), tr.Count())
+			_, counts := nodeOccupancy(tr)
+			for _, n := range counts {
+				if n < 4 || n > 9 {
+					t.Fatalf("%s: node has %d children", ss.name, n)
+				}
+			}
+			testSearch(t, tr, objs, 0.10, true)
+			testSearch(t, tr, objs, 0.50, true)
+			testKNN(t, tr, objs, 1000, true)
+			for _, obj := range objs {
+				tr.Remove(obj)
+			}
+			assert.Equal(t, 0, tr.Count())
+		}
+	}
+}
+
+func BenchmarkSplitInsert(b *testing.B) {
+	for _, ss := range splitStrategies {
+		for dims := 1; dims <= 10; dims++ {
+			b.Run(fmt.Sprintf("%s/%dD", ss.name, dims), func(b *testing.B) {
+				rand.Seed(1)
+				objs := make([]rbush.Item, b.N)
+				for i := range objs {
+					objs[i] = makeRandom("rect", dims)
+				}
+				tr := rbush.NewOptions(dims, &rbush.Options{Split: ss.strategy})
+				b.ResetTimer()
+				for _, obj := range objs {
+					tr.Insert(obj)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSplitSearch(b *testing.B) {
+	for _, ss := range splitStrategies {
+		for dims := 1; dims <= 10; dims++ {
+			b.Run(fmt.Sprintf("%s/%dD", ss.name, dims), func(b *testing.B) {
+				rand.Seed(1)
+				tr := rbush.NewOptions(dims, &rbush.Options{Split: ss.strategy})
+				for i := 0; i < 10000; i++ {
+					tr.Insert(makeRandom("rect", dims))
+				}
+				queries := make([]rbush.Item, 1000)
+				for i := range queries {
+					queries[i] = makeRandom("rect", dims)
+				}
+				var found int
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					tr.Search(queries[i%len(queries)], func(item rbush.Item) bool {
+						found++
+						return true
+					})
+				}
+				b.ReportMetric(float64(found)/float64(b.N), "items/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package rbush
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/tidwall/tinyqueue"
+)
+
+// Static is a packed, read-only index over boxes. The tree is kept in a
+// few flat arrays instead of nodes, and can be serialized as one byte slice.
+// Queries follow the same rules as RBush, but report the index of each item
+// in the order that it was provided.
+type Static struct {
+	dims     int
+	nodeSize int
+	numItems int
+	// boxes holds the min and max of every entry, items first followed by
+	// each level of nodes up to the root.
+	boxes []float64
+	// indices holds the item index for items, or the offset of the first
+	// child for nodes.
+	indices []uint32
+	// levels holds the entry offset where each level ends.
+	levels []int
+	// mapped is the memory mapped file from OpenStatic.
+	mapped []byte
+}
+
+const staticMagic = "RBSTATIC"
+const staticHeaderSize = 32
+
+// ErrInvalidStatic is returned when bytes do not hold a Static index.
+var ErrInvalidStatic = errors.New("invalid static index data")
+
+// NewStatic returns a Static index over boxes, which holds the min values
+// followed by the max values of each item. A nodeSize of zero uses 16. It
+// panics when a box has NaN coordinates, or when the items and nodes do not
+// fit in the uint32 indices.
+func NewStatic(dims int, boxes []float64, nodeSize int) *Static {
+	if dims <= 0 || len(boxes)%(dims*2) != 0 {
+		panic("boxes length does not match dimensions")
+	}
+	if nodeSize <= 0 {
+		nodeSize = 16
+	} else if nodeSize < 2 {
+		nodeSize = 2
+	}
+	n := len(boxes) / (dims * 2)
+	levels := staticLevels(n, nodeSize)
+	if uint64(levels[len(levels)-1]) > math.MaxUint32 {
+		panic("too many items for a static index")
+	}
+	s := &Static{
+		dims:     dims,
+		nodeSize: nodeSize,
+		numItems: n,
+		boxes:    make([]float64, levels[len(levels)-1]*dims*2),
+		indices:  make([]uint32, levels[len(levels)-1]),
+		levels:   levels,
+	}
+	if n == 0 {
+		return s
+	}
+
+	// order the items into tiles
+	centers := make([]float64, n*dims)
+	es := make([]strEntry, n)
+	for i := 0; i < n; i++ {
+		box := boxes[i*dims*2 : (i+1)*dims*2]
+		center := centers[i*dims : (i+1)*dims]
+		for j := 0; j < dims; j++ {
+			if math.IsNaN(box[j]) || math.IsNaN(box[dims+j]) {
+				panic("item has NaN coordinates")
+			}
+			center[j] = (box[j] + box[dims+j]) / 2
+		}
+		es[i] = strEntry{i, center}
+	}
+	var wg sync.WaitGroup
+	strPartition(es, 0, dims, nodeSize, &wg)
+	wg.Wait()
+	for i, e := range es {
+		index := e.ptr.(int)
+		copy(s.boxes[i*dims*2:(i+1)*dims*2], boxes[index*dims*2:(index+1)*dims*2])
+		s.indices[i] = uint32(index)
+	}
+
+	// fill the nodes, level by level
+	pos := n
+	for l := 0; l < len(levels)-1; l++ {
+		start := 0
+		if l > 0 {
+			start = levels[l-1]
+		}
+		for i := start; i < levels[l]; i += nodeSize {
+			end := i + nodeSize
+			if end > levels[l] {
+				end = levels[l]
+			}
+			box := s.boxes[pos*dims*2 : (pos+1)*dims*2]
+			for j := 0; j < dims; j++ {
+				box[j] = mathInfPos
+				box[dims+j] = mathInfNeg
+			}
+			for k := i; k < end; k++ {
+				child := s.boxes[k*dims*2 : (k+1)*dims*2]
+				for j := 0; j < dims; j++ {
+					box[j] = mathMin(box[j], child[j])
+					box[dims+j] = mathMax(box[dims+j], child[dims+j])
+				}
+			}
+			s.indices[pos] = uint32(i)
+			pos++
+		}
+	}
+	return s
+}
+
+// NewStaticItems returns a Static index over the boxes of items.
+func NewStaticItems(dims int, items []Item, nodeSize int) *Static {
+	boxes := make([]float64, 0, len(items)*dims*2)
+	for _, item := range items {
+		if item == nil {
+			panic("item is nil")
+		}
+		min, max := item.Rect()
+		if len(min) != len(max) || len(min) != dims {
+			panic("item dimensions does not match tree dimensions")
+		}
+		boxes = append(boxes, min...)
+		boxes = append(boxes, max...)
+	}
+	return NewStatic(dims, boxes, nodeSize)
+}
+
+// staticLevels returns the entry offset where each level ends, for n items.
+func staticLevels(n, nodeSize int) []int {
+	levels := []int{n}
+	count := n
+	for {
+		count = (count + nodeSize - 1) / nodeSize
+		if count == 0 {
+			return levels
+		}
+		levels = append(levels, levels[len(levels)-1]+count)
+		if count == 1 {
+			return levels
+		}
+	}
+}
+
+// Count returns the number of items in the index.
+func (s *Static) Count() int {
+	return s.numItems
+}
+
+// Bounds returns the bounds of all items.
+func (s *Static) Bounds() (min, max []float64) {
+	if s.numItems == 0 {
+		return make([]float64, s.dims), make([]float64, s.dims)
+	}
+	root := len(s.indices) - 1
+	box := s.boxes[root*s.dims*2 : (root+1)*s.dims*2]
+	return box[:s.dims:s.dims], box[s.dims:]
+}
+
+// Search iterates over the index of every item that intersects bbox.
+func (s *Static) Search(bbox Item, iter func(index int) bool) bool {
+	if bbox == nil {
+		panic("bbox is nil")
+	}
+	min, max := bbox.Rect()
+	if len(min) != len(max) || len(min) != s.dims {
+		panic("bbox dimensions does not match tree dimensions")
+	}
+	if s.numItems == 0 {
+		return true
+	}
+	return s.search(len(s.indices)-1, len(s.levels)-1, min, max, iter)
+}
+
+func (s *Static) search(entry, level int, min, max []float64, iter func(index int) bool) bool {
+	start := int(s.indices[entry])
+	end := start + s.nodeSize
+	if level == 1 {
+		if end > s.levels[0] {
+			end = s.levels[0]
+		}
+		for i := start; i < end; i++ {
+			if s.intersects(i, min, max) {
+				if !iter(int(s.indices[i])) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if end > s.levels[level-1] {
+		end = s.levels[level-1]
+	}
+	for i := start; i < end; i++ {
+		if s.intersects(i, min, max) {
+			if !s.search(i, level-1, min, max, iter) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Static) intersects(entry int, min, max []float64) bool {
+	box := s.boxes[entry*s.dims*2 : (entry+1)*s.dims*2]
+	for i := 0; i < s.dims; i++ {
+		if !(min[i] <= box[s.dims+i] && max[i] >= box[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type staticQueueItem struct {
+	entry int
+	level int
+	dist  float64
+}
+
+func (item *staticQueueItem) Less(b tinyqueue.Item) bool {
+	return item.dist < b.(*staticQueueItem).dist
+}
+
+// KNN iterates over the index of every item, nearest to point first. The
+// dist is the squared distance to the item box, the same as RBush.KNN.
+func (s *Static) KNN(point []float64, iter func(index int, dist float64) bool) bool {
+	if s.numItems == 0 {
+		return true
+	}
+	queue := tinyqueue.New(nil)
+	entry, level := len(s.indices)-1, len(s.levels)-1
+	for {
+		start := int(s.indices[entry])
+		end := start + s.nodeSize
+		if end > s.levels[level-1] {
+			end = s.levels[level-1]
+		}
+		for i := start; i < end; i++ {
+			box := s.boxes[i*s.dims*2 : (i+1)*s.dims*2]
+			queue.Push(&staticQueueItem{
+				entry: i,
+				level: level - 1,
+				dist:  boxDist(point, box[:s.dims], box[s.dims:]),
+			})
+		}
+		for queue.Len() > 0 && queue.Peek().(*staticQueueItem).level == 0 {
+			item := queue.Pop().(*staticQueueItem)
+			if !iter(int(s.indices[item.entry]), item.dist) {
+				return false
+			}
+		}
+		last := queue.Pop()
+		if last == nil {
+			return true
+		}
+		entry, level = last.(*staticQueueItem).entry, last.(*staticQueueItem).level
+	}
+}
+
+// Bytes returns the index serialized as a single byte slice.
+func (s *Static) Bytes() []byte {
+	var buf bytes.Buffer
+	buf.Grow(staticHeaderSize + len(s.boxes)*8 + len(s.indices)*4)
+	s.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// WriteTo writes the serialized index to w.
+func (s *Static) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var header [staticHeaderSize]byte
+	copy(header[:], staticMagic)
+	binary.LittleEndian.PutUint32(header[8:], uint32(s.dims))
+	binary.LittleEndian.PutUint32(header[12:], uint32(s.nodeSize))
+	binary.LittleEndian.PutUint64(header[16:], uint64(s.numItems))
+	binary.LittleEndian.PutUint64(header[24:], uint64(len(s.indices)))
+	bw.Write(header[:])
+	var b [8]byte
+	for _, v := range s.boxes {
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		bw.Write(b[:])
+	}
+	for _, v := range s.indices {
+		binary.LittleEndian.PutUint32(b[:], v)
+		bw.Write(b[:4])
+	}
+	n := int64(staticHeaderSize + len(s.boxes)*8 + len(s.indices)*4)
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LoadStatic returns the index serialized in data. When possible the index
+// uses data directly instead of copying it, in which case data must not be
+// modified while the index is in use.
+func LoadStatic(data []byte) (*Static, error) {
+	if len(data) < staticHeaderSize || string(data[:8]) != staticMagic {
+		return nil, ErrInvalidStatic
+	}
+	dims := int(binary.LittleEndian.Uint32(data[8:]))
+	nodeSize := int(binary.LittleEndian.Uint32(data[12:]))
+	numItems := binary.LittleEndian.Uint64(data[16:])
+	numEntries := binary.LittleEndian.Uint64(data[24:])
+	if dims <= 0 || nodeSize < 2 || numItems > math.MaxUint32 {
+		return nil, ErrInvalidStatic
+	}
+	levels := staticLevels(int(numItems), nodeSize)
+	if uint64(levels[len(levels)-1]) != numEntries ||
+		uint64(len(data)) != staticHeaderSize+numEntries*uint64(dims*2*8+4) {
+		return nil, ErrInvalidStatic
+	}
+	s := &Static{
+		dims:     dims,
+		nodeSize: nodeSize,
+		numItems: int(numItems),
+		levels:   levels,
+	}
+	nboxes := int(numEntries) * dims * 2
+	boxData := data[staticHeaderSize : staticHeaderSize+nboxes*8]
+	indexData := data[staticHeaderSize+nboxes*8:]
+	if nboxes > 0 && littleEndian && uintptr(unsafe.Pointer(&data[0]))%8 == 0 {
+		s.boxes = unsafe.Slice((*float64)(unsafe.Pointer(&boxData[0])), nboxes)
+		s.indices = unsafe.Slice((*uint32)(unsafe.Pointer(&indexData[0])), numEntries)
+	} else {
+		s.boxes = make([]float64, nboxes)
+		for i := range s.boxes {
+			s.boxes[i] = math.Float64frombits(binary.LittleEndian.Uint64(boxData[i*8:]))
+		}
+		s.indices = make([]uint32, numEntries)
+		for i := range s.indices {
+			s.indices[i] = binary.LittleEndian.Uint32(indexData[i*4:])
+		}
+	}
+	// every node must point into the level below it
+	for l := 1; l < len(levels); l++ {
+		start := 0
+		if l > 1 {
+			start = levels[l-2]
+		}
+		for i := levels[l-1]; i < levels[l]; i++ {
+			if int(s.indices[i]) < start || int(s.indices[i]) >= levels[l-1] {
+				return nil, ErrInvalidStatic
+			}
+		}
+	}
+	return s, nil
+}
+
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
@@ -0,0 +1,51 @@
+//go:build linux
+// +build linux
+
+package rbush
+
+import (
+	"os"
+	"syscall"
+)
+
+// OpenStatic memory maps a file written by Static.WriteTo, or holding the
+// output of Static.Bytes. Queries read the mapped file directly, so the
+// index is not loaded into the heap and the pages are shared by every
+// process that maps the same file. Close must be called to unmap the file.
+func OpenStatic(path string) (*Static, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < staticHeaderSize || int64(int(fi.Size())) != fi.Size() {
+		return nil, ErrInvalidStatic
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()),
+		syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	s, err := LoadStatic(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	s.mapped = data
+	return s, nil
+}
+
+// Close unmaps the file of an index opened with OpenStatic. The index must
+// not be used afterwards.
+func (s *Static) Close() error {
+	if s.mapped == nil {
+		return nil
+	}
+	data := s.mapped
+	s.mapped, s.boxes, s.indices = nil, nil, nil
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package rbush
+
+import "io/ioutil"
+
+// OpenStatic reads a file written by Static.WriteTo, or holding the output
+// of Static.Bytes. Memory mapping is only available on Linux, elsewhere the
+// file is read into memory.
+func OpenStatic(path string) (*Static, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return LoadStatic(data)
+}
+
+// Close releases the index. The index must not be used afterwards.
+func (s *Static) Close() error {
+	s.boxes, s.indices = nil, nil
+	return nil
+}
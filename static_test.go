@@ -0,0 +1,118 @@
+package rbush_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func TestStatic(t *testing.T) {
+	for dims := 1; dims <= 5; dims++ {
+		for _, n := range []int{0, 1, 16, 17, 1000, 20000} {
+			rand.Seed(time.Now().UnixNano())
+			objs := make([]rbush.Item, n)
+			tr := rbush.New(dims)
+			for i := 0; i < n; i++ {
+				objs[i] = makeRandom("rect", dims)
+				tr.Insert(objs[i])
+			}
+			s := rbush.NewStaticItems(dims, objs, 0)
+			testStatic(t, s, tr, objs)
+
+			data := s.Bytes()
+			s2, err := rbush.LoadStatic(data)
+			assert.NoError(t, err)
+			testStatic(t, s2, tr, objs)
+
+			// misaligned data is copied
+			buf := make([]byte, len(data)+1)
+			copy(buf[1:], data)
+			s3, err := rbush.LoadStatic(buf[1:])
+			assert.NoError(t, err)
+			testStatic(t, s3, tr, objs)
+
+			_, err = rbush.LoadStatic(data[:len(data)-1])
+			assert.Equal(t, rbush.ErrInvalidStatic, err)
+		}
+	}
+}
+
+func testStatic(t *testing.T, s *rbush.Static, tr *rbush.RBush, objs []rbush.Item) {
+	assert.Equal(t, len(objs), s.Count())
+	min1, max1 := s.Bounds()
+	min2, max2 := tr.Bounds()
+	assert.Equal(t, min2, min1)
+	assert.Equal(t, max2, max1)
+	index := make(map[rbush.Item]int)
+	for i, obj := range objs {
+		index[obj] = i
+	}
+	for _, percent := range []float64{0.10, 0.50, 1.00} {
+		values := make([]float64, len(min2)*2)
+		for i := 0; i < len(min2); i++ {
+			values[i] = (max2[i]+min2[i])/2 - (max2[i]-min2[i])*percent/2
+			values[len(values)/2+i] = (max2[i]+min2[i])/2 + (max2[i]-min2[i])*percent/2
+		}
+		box := makeRect(values...)
+		var a1, a2 []int
+		s.Search(box, func(index int) bool {
+			a1 = append(a1, index)
+			return true
+		})
+		tr.Search(box, func(item rbush.Item) bool {
+			a2 = append(a2, index[item])
+			return true
+		})
+		sort.Ints(a1)
+		sort.Ints(a2)
+		assert.Equal(t, a2, a1)
+	}
+	center := make([]float64, len(min2))
+	for i := range center {
+		center[i] = (max2[i] + min2[i]) / 2
+	}
+	var d1, d2 []float64
+	s.KNN(center, func(index int, dist float64) bool {
+		d1 = append(d1, dist)
+		return len(d1) < 100
+	})
+	tr.KNN(center, func(item rbush.Item, dist float64) bool {
+		d2 = append(d2, dist)
+		return len(d2) < 100
+	})
+	assert.Equal(t, d2, d1)
+}
+
+func TestOpenStatic(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	objs := make([]rbush.Item, 20000)
+	tr := rbush.New(2)
+	for i := range objs {
+		objs[i] = makeRandom("rect", 2)
+		tr.Insert(objs[i])
+	}
+	path := filepath.Join(t.TempDir(), "index.rbs")
+	f, err := os.Create(path)
+	assert.NoError(t, err)
+	s := rbush.NewStaticItems(2, objs, 0)
+	n, err := s.WriteTo(f)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(s.Bytes())), n)
+	assert.NoError(t, f.Close())
+
+	s, err = rbush.OpenStatic(path)
+	assert.NoError(t, err)
+	testStatic(t, s, tr, objs)
+	assert.NoError(t, s.Close())
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("garbage"), 0666))
+	_, err = rbush.OpenStatic(path)
+	assert.Equal(t, rbush.ErrInvalidStatic, err)
+}
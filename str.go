@@ -0,0 +1,133 @@
+package rbush
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Packing trades build speed against query speed for NewPacked.
+type Packing int
+
+const (
+	// PackFast orders the items with Sort-Tile-Recursive and groups the
+	// upper levels in the order that their nodes were made.
+	PackFast Packing = iota
+	// PackTight orders every level of the tree with Sort-Tile-Recursive.
+	// It takes longer to build, but nodes overlap less.
+	PackTight
+)
+
+// strParallel is the smallest slab that is partitioned on its own goroutine.
+const strParallel = 4096
+
+// NewPacked returns a tree built from items using Sort-Tile-Recursive
+// packing. All leaves are full, except for the last one or two. The tree can
+// still be modified afterwards. A Hilbert tree is packed in Hilbert order.
+func NewPacked(dims int, items []Item, opts *Options) *RBush {
+	tr := NewOptions(dims, opts)
+	if tr.hilbert != nil {
+		tr.Load(items)
+		return tr
+	}
+	entries := make([]interface{}, len(items))
+	for i, item := range items {
+		tr.checkItem(item)
+		entries[i] = item
+	}
+	tight := opts != nil && opts.Packing == PackTight
+	tr.data = tr.pack(entries, func(entries []interface{}, leaf bool) {
+		if leaf || tight {
+			tr.strOrder(entries, leaf)
+		}
+	})
+	return tr
+}
+
+// loadPacked packs the items and inserts them as a subtree. The smaller of
+// the tree and the subtree is inserted into the taller one.
+func (tr *RBush) loadPacked(items []Item) {
+	if len(items) == 0 {
+		return
+	}
+	entries := make([]interface{}, len(items))
+	for i, item := range items {
+		entries[i] = item
+	}
+	node := tr.pack(entries, func(entries []interface{}, leaf bool) {
+		if leaf {
+			tr.strOrder(entries, leaf)
+		}
+	})
+	if len(tr.data.children) == 0 {
+		tr.data = node
+		return
+	}
+	if node.height > tr.data.height {
+		tr.data, node = node, tr.data
+	}
+	if len(node.children) >= tr.minEntries {
+		tr.reinsertEntry(node)
+		return
+	}
+	// a root can have fewer entries than a node inside of the tree
+	for _, child := range node.children {
+		tr.reinsertEntry(child)
+	}
+}
+
+type strEntry struct {
+	ptr    interface{}
+	center []float64
+}
+
+// strOrder reorders the entries so that every run of maxEntries entries
+// makes up a tile.
+func (tr *RBush) strOrder(entries []interface{}, leaf bool) {
+	centers := make([]float64, len(entries)*tr.dims)
+	es := make([]strEntry, len(entries))
+	for i, ptr := range entries {
+		bbox := entryBBox(ptr, leaf)
+		center := centers[i*tr.dims : (i+1)*tr.dims]
+		for j := 0; j < tr.dims; j++ {
+			center[j] = (bbox.min[j] + bbox.max[j]) / 2
+		}
+		es[i] = strEntry{ptr, center}
+	}
+	var wg sync.WaitGroup
+	strPartition(es, 0, tr.dims, tr.maxEntries, &wg)
+	wg.Wait()
+	for i := range es {
+		entries[i] = es[i].ptr
+	}
+}
+
+// strPartition sorts the entries on axis and cuts them into slabs, which are
+// partitioned on the next axis. Slabs hold a multiple of M entries.
+func strPartition(es []strEntry, axis, dims, M int, wg *sync.WaitGroup) {
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].center[axis] < es[j].center[axis]
+	})
+	if axis == dims-1 {
+		return
+	}
+	nodes := (len(es) + M - 1) / M
+	slabs := int(math.Ceil(math.Pow(float64(nodes), 1/float64(dims-axis))))
+	size := M * ((nodes + slabs - 1) / slabs)
+	for i := 0; i < len(es); i += size {
+		end := i + size
+		if end > len(es) {
+			end = len(es)
+		}
+		slab := es[i:end]
+		if len(slab) < strParallel {
+			strPartition(slab, axis+1, dims, M, wg)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			strPartition(slab, axis+1, dims, M, wg)
+		}()
+	}
+}
@@ -0,0 +1,123 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func TestPacked(t *testing.T) {
+	for _, packing := range []rbush.Packing{rbush.PackFast, rbush.PackTight} {
+		for dims := 1; dims <= 5; dims++ {
+			for _, n := range []int{0, 1, 9, 10, 13, 100, 20000} {
+				rand.Seed(time.Now().UnixNano())
+				objs := make([]rbush.Item, n)
+				for i := 0; i < n; i++ {
+					objs[i] = makeRandom("rect", dims)
+				}
+				tr := rbush.NewPacked(dims, objs, &rbush.Options{Packing: packing})
+				assert.Equal(t, n, tr.Count())
+				_, counts := nodeOccupancy(tr)
+				for _, c := range counts {
+					if c < 4 || c > 9 {
+						t.Fatalf("node has %d children", c)
+					}
+				}
+				if n > 0 {
+					// every leaf is full, except the last two
+					var leaves int
+					tr.Traverse(func(min, max []float64, level int, item rbush.Item) bool {
+						if level == 1 {
+							leaves++
+						}
+						return true
+					})
+					assert.Equal(t, (n+8)/9, leaves)
+					testSearch(t, tr, objs, 0.10, true)
+					testSearch(t, tr, objs, 0.50, true)
+					testKNN(t, tr, objs, 100, true)
+				}
+
+				// the packed tree is a regular tree
+				for i := 0; i < 100; i++ {
+					obj := makeRandom("rect", dims)
+					objs = append(objs, obj)
+					tr.Insert(obj)
+				}
+				testSearch(t, tr, objs, 0.50, true)
+				for _, i := range rand.Perm(len(objs)) {
+					tr.Remove(objs[i])
+				}
+				assert.Equal(t, 0, tr.Count())
+			}
+		}
+	}
+}
+
+func TestLoadPacked(t *testing.T) {
+	for dims := 1; dims <= 3; dims++ {
+		for _, opts := range []*rbush.Options{nil, {Insertion: rbush.InsertRStar}, {Aggregates: true}} {
+			rand.Seed(time.Now().UnixNano())
+			tr := rbush.NewOptions(dims, opts)
+			var objs []rbush.Item
+			// batches that are smaller, as tall as and taller than the tree
+			for _, n := range []int{0, 500, 3, 20, 2000, 700, 12000} {
+				batch := make([]rbush.Item, n)
+				for i := range batch {
+					batch[i] = makeRandom("rect", dims)
+				}
+				tr.Load(batch)
+				objs = append(objs, batch...)
+				assert.NoError(t, tr.Validate())
+				assert.Equal(t, len(objs), tr.Count())
+			}
+			testSearch(t, tr, objs, 0.10, true)
+			testKNN(t, tr, objs, 100, true)
+			if dims == 2 && opts == nil {
+				def := rbush.New(dims)
+				for _, obj := range objs {
+					def.Insert(obj)
+				}
+				assert.True(t, leafFill(tr) > leafFill(def))
+			}
+			for _, i := range rand.Perm(len(objs)) {
+				tr.Remove(objs[i])
+			}
+			assert.Equal(t, 0, tr.Count())
+		}
+	}
+}
+
+func BenchmarkPacked(b *testing.B) {
+	rand.Seed(1)
+	objs := make([]rbush.Item, 1000000)
+	for i := range objs {
+		objs[i] = makeRandom("rect", 2)
+	}
+	queries := make([]rbush.Item, 1000)
+	for i := range queries {
+		queries[i] = makeRandom("rect", 2)
+	}
+	for _, bench := range []struct {
+		name    string
+		packing rbush.Packing
+	}{{"fast", rbush.PackFast}, {"tight", rbush.PackTight}} {
+		b.Run(bench.name+"/build", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				rbush.NewPacked(2, objs, &rbush.Options{Packing: bench.packing})
+			}
+		})
+		b.Run(bench.name+"/search", func(b *testing.B) {
+			tr := rbush.NewPacked(2, objs, &rbush.Options{Packing: bench.packing})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Search(queries[i%len(queries)], func(item rbush.Item) bool {
+					return true
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package rbush
+
+import "sync/atomic"
+
+// generation is the last generation handed out to a tree. Trees that share
+// nodes have different generations, and a tree only changes the nodes of
+// its own generation. Other nodes are copied first.
+var generation uint64
+
+func nextGeneration() uint64 {
+	return atomic.AddUint64(&generation, 1)
+}
+
+// own returns the node if it belongs to the tree, otherwise a copy that
+// does.
+func (tr *RBush) own(node *treeNode) *treeNode {
+	if node.gen == tr.gen {
+		return node
+	}
+	n := *node
+	n.min = append([]float64(nil), node.min...)
+	n.max = append([]float64(nil), node.max...)
+	size := len(node.children)
+	if size < tr.maxEntries {
+		size = tr.maxEntries
+	}
+	n.children = make([]interface{}, len(node.children), size+1)
+	copy(n.children, node.children)
+	n.gen = tr.gen
+	return &n
+}
+
+// ownChild returns an owned child, replacing the child in the owned parent
+// when it had to be copied.
+func (tr *RBush) ownChild(parent, child *treeNode) *treeNode {
+	if child.gen == tr.gen {
+		return child
+	}
+	owned := tr.own(child)
+	for i, ptr := range parent.children {
+		if ptr == child {
+			parent.children[i] = owned
+			break
+		}
+	}
+	return owned
+}
+
+// ownPath makes every node from the root down the path owned.
+func (tr *RBush) ownPath(path []*treeNode) {
+	path[0] = tr.own(path[0])
+	tr.data = path[0]
+	for i := 1; i < len(path); i++ {
+		path[i] = tr.ownChild(path[i-1], path[i])
+	}
+}
+
+// Tx is a batch of changes to a tree, which are staged on a private copy
+// and become visible all at once on Commit. Only the nodes that the batch
+// changes are copied.
+type Tx struct {
+	tr     *RBush
+	shadow *RBush
+	base   *treeNode
+	ops    []txOp
+	done   bool
+}
+
+type txOp struct {
+	remove  Item
+	insert  Item
+	removed bool // the removed item was in the tree
+}
+
+// Begin starts a transaction on the tree. The tree may still be changed
+// directly while the transaction is open.
+func (tr *RBush) Begin() *Tx {
+	// the tree and the transaction now share nodes
+	tr.gen = nextGeneration()
+	return &Tx{tr: tr, shadow: tr.shadow(), base: tr.data}
+}
+
+func (tr *RBush) shadow() *RBush {
+	shadow := *tr
+	shadow.reusePath = nil
+	shadow.observers = nil
+	shadow.gen = nextGeneration()
+	return &shadow
+}
+
+func (tx *Tx) check() {
+	if tx.done {
+		panic("transaction is done")
+	}
+}
+
+// Insert stages the insertion of an item.
+func (tx *Tx) Insert(item Item) {
+	tx.check()
+	tx.shadow.Insert(item)
+	tx.ops = append(tx.ops, txOp{insert: item})
+}
+
+// Remove stages the removal of an item.
+func (tx *Tx) Remove(item Item) {
+	tx.check()
+	removed := tx.shadow.remove(item)
+	tx.ops = append(tx.ops, txOp{remove: item, removed: removed})
+}
+
+// Update stages the replacement of the old item with a new item.
+func (tx *Tx) Update(old, item Item) {
+	tx.check()
+	removed := tx.shadow.update(old, item)
+	tx.ops = append(tx.ops, txOp{remove: old, insert: item, removed: removed})
+}
+
+// Search the tree as it looks with the staged changes.
+func (tx *Tx) Search(bbox Item, iter func(item Item) bool) bool {
+	tx.check()
+	return tx.shadow.Search(bbox, iter)
+}
+
+// KNN the tree as it looks with the staged changes.
+func (tx *Tx) KNN(point []float64, iter func(item Item, dist float64) bool) bool {
+	tx.check()
+	return tx.shadow.KNN(point, iter)
+}
+
+// Scan the tree as it looks with the staged changes.
+func (tx *Tx) Scan(iter func(item Item) bool) bool {
+	tx.check()
+	return tx.shadow.Scan(iter)
+}
+
+// Count the items of the tree as it looks with the staged changes.
+func (tx *Tx) Count() int {
+	tx.check()
+	return tx.shadow.Count()
+}
+
+// Commit makes the staged changes visible in the tree. When the tree was
+// changed directly after Begin, the staged changes are applied again on top
+// of the current tree.
+func (tx *Tx) Commit() {
+	tx.check()
+	tx.done = true
+	tr, shadow := tx.tr, tx.shadow
+	if tr.data != tx.base {
+		shadow = tr.shadow()
+		for i, op := range tx.ops {
+			if op.insert == nil {
+				tx.ops[i].removed = shadow.remove(op.remove)
+			} else if op.remove == nil {
+				shadow.Insert(op.insert)
+			} else {
+				tx.ops[i].removed = shadow.update(op.remove, op.insert)
+			}
+		}
+	}
+	tr.data = shadow.data
+	tr.gen = shadow.gen
+	tr.reusePath = nil
+	for _, op := range tx.ops {
+		tr.notify(op.remove, op.insert, op.removed)
+	}
+}
+
+// Rollback discards the staged changes.
+func (tx *Tx) Rollback() {
+	tx.check()
+	tx.done = true
+	tx.shadow = nil
+	tx.ops = nil
+}
@@ -0,0 +1,129 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func itemSet(tr interface {
+	Scan(iter func(item rbush.Item) bool) bool
+}) map[rbush.Item]bool {
+	set := make(map[rbush.Item]bool)
+	tr.Scan(func(item rbush.Item) bool {
+		set[item] = true
+		return true
+	})
+	return set
+}
+
+func setItems(set map[rbush.Item]bool) []rbush.Item {
+	var items []rbush.Item
+	for item := range set {
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestTx(t *testing.T) {
+	trees := map[string]func() *rbush.RBush{
+		"default": func() *rbush.RBush { return rbush.New(2) },
+		"rstar": func() *rbush.RBush {
+			return rbush.NewOptions(2, &rbush.Options{Insertion: rbush.InsertRStar})
+		},
+		"hilbert": func() *rbush.RBush { return newHilbert(2) },
+	}
+	for name, newTree := range trees {
+		t.Run(name, func(t *testing.T) {
+			rand.Seed(time.Now().UnixNano())
+			tr := newTree()
+			var objs []rbush.Item
+			for i := 0; i < 2000; i++ {
+				objs = append(objs, makeRandom("rect", 2))
+				tr.Insert(objs[i])
+			}
+			before := itemSet(tr)
+
+			// stage inserts, removes and updates
+			expect := itemSet(tr)
+			tx := tr.Begin()
+			for i := 0; i < 1000; i++ {
+				obj := makeRandom("rect", 2)
+				tx.Insert(obj)
+				expect[obj] = true
+			}
+			for i := 0; i < 500; i++ {
+				tx.Remove(objs[i])
+				delete(expect, objs[i])
+			}
+			for i := 500; i < 700; i++ {
+				obj := makeRandom("rect", 2)
+				tx.Update(objs[i], obj)
+				delete(expect, objs[i])
+				expect[obj] = true
+			}
+			assert.Equal(t, expect, itemSet(tx))
+			assert.Equal(t, len(expect), tx.Count())
+			assert.Equal(t, before, itemSet(tr))
+			testSearch(t, tr, setItems(before), 0.10, true)
+
+			tx.Commit()
+			assert.Equal(t, expect, itemSet(tr))
+			testSearch(t, tr, setItems(expect), 0.10, true)
+			testSearch(t, tr, setItems(expect), 0.50, true)
+
+			// rolled back changes are never seen
+			before = itemSet(tr)
+			tx = tr.Begin()
+			for obj := range before {
+				tx.Remove(obj)
+			}
+			assert.Equal(t, 0, tx.Count())
+			tx.Rollback()
+			assert.Equal(t, before, itemSet(tr))
+			testSearch(t, tr, setItems(before), 0.25, true)
+			assert.Panics(t, func() { tx.Commit() })
+
+			// the tree is changed directly while the transaction is open
+			tx = tr.Begin()
+			expect = itemSet(tr)
+			staged := make(map[rbush.Item]bool)
+			for i := 0; i < 300; i++ {
+				obj := makeRandom("rect", 2)
+				tx.Insert(obj)
+				staged[obj] = true
+				expect[obj] = true
+			}
+			for i := 0; i < 300; i++ {
+				obj := makeRandom("rect", 2)
+				tr.Insert(obj)
+				expect[obj] = true
+				assert.False(t, itemSet(tx)[obj])
+			}
+			for obj := range staged {
+				assert.False(t, itemSet(tr)[obj])
+				break
+			}
+			n := tr.RemoveWhere([]float64{-50, -50}, []float64{0, 0}, nil)
+			for obj := range expect {
+				if !staged[obj] && testIntersects(obj, makeRect(-50, -50, 0, 0)) {
+					delete(expect, obj)
+					n--
+				}
+			}
+			assert.Equal(t, 0, n)
+			tx.Commit()
+			assert.Equal(t, expect, itemSet(tr))
+			testSearch(t, tr, setItems(expect), 0.10, true)
+			_, counts := nodeOccupancy(tr)
+			for _, n := range counts {
+				if n > 9 {
+					t.Fatalf("node has %d children", n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package rbush
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Validate checks the structure of the tree. It returns an error describing
+// the first broken invariant:
+//   - the box of every node is the union of the boxes of its children
+//   - every leaf is at the same depth, and heights count up from the leaves
+//   - every node except the root has between the minimum and maximum number
+//     of entries, and the root of a taller tree has at least two
+//   - there are no nil children, and items have the tree's dimensions
+//
+// On Hilbert trees the children must be in Hilbert order, and on trees with
+// aggregates the item counts must be correct.
+func (tr *RBush) Validate() error {
+	root := tr.data
+	if len(root.children) == 0 {
+		if !root.leaf {
+			return fmt.Errorf("empty root is not a leaf")
+		}
+		return nil
+	}
+	if !root.leaf && len(root.children) < 2 {
+		return fmt.Errorf("root has %d children, minimum is 2", len(root.children))
+	}
+	_, err := tr.validate(root, true)
+	return err
+}
+
+// validate checks a node and returns the number of items below it.
+func (tr *RBush) validate(node *treeNode, isRoot bool) (int, error) {
+	n := len(node.children)
+	if n > tr.maxEntries || (!isRoot && n < tr.minEntries) {
+		return 0, fmt.Errorf("node at height %d has %d children, expected %d to %d",
+			node.height, n, tr.minEntries, tr.maxEntries)
+	}
+	if node.leaf != (node.height == 1) {
+		return 0, fmt.Errorf("node at height %d has leaf set to %t", node.height, node.leaf)
+	}
+	bbox := createNode(nil, tr.dims)
+	var count int
+	var prev uint64
+	for i, ptr := range node.children {
+		var value uint64
+		if node.leaf {
+			item, ok := ptr.(Item)
+			if !ok || item == nil {
+				return 0, fmt.Errorf("leaf has a nil item")
+			}
+			min, max := item.Rect()
+			if len(min) != tr.dims || len(max) != tr.dims {
+				return 0, fmt.Errorf("item dimensions does not match tree dimensions")
+			}
+			bbox.extend(&treeNode{min: min, max: max})
+			if tr.hilbert != nil {
+				value = tr.hilbert.itemValue(item)
+			}
+			count++
+		} else {
+			child, ok := ptr.(*treeNode)
+			if !ok || child == nil {
+				return 0, fmt.Errorf("node at height %d has a nil child", node.height)
+			}
+			if child.height != node.height-1 {
+				return 0, fmt.Errorf("node at height %d has a child at height %d",
+					node.height, child.height)
+			}
+			c, err := tr.validate(child, false)
+			if err != nil {
+				return 0, err
+			}
+			bbox.extend(child)
+			value = child.lhv
+			count += c
+		}
+		if tr.hilbert != nil && i > 0 && value < prev {
+			return 0, fmt.Errorf("node at height %d is not in hilbert order", node.height)
+		}
+		prev = value
+	}
+	for i := 0; i < tr.dims; i++ {
+		if bbox.min[i] != node.min[i] || bbox.max[i] != node.max[i] {
+			return 0, fmt.Errorf("node at height %d has box %v %v, expected %v %v",
+				node.height, node.min, node.max, bbox.min, bbox.max)
+		}
+	}
+	if tr.hilbert != nil && node.lhv != prev {
+		return 0, fmt.Errorf("node at height %d has a stale hilbert value", node.height)
+	}
+	if tr.aggregates && node.count != count {
+		return 0, fmt.Errorf("node at height %d counts %d items, expected %d",
+			node.height, node.count, count)
+	}
+	return count, nil
+}
+
+// Stats describes the shape of a tree.
+type Stats struct {
+	Height int
+	Nodes  int
+	Items  int
+	// LeafFill counts the leaves by their number of items, LeafFill[n] is
+	// the number of leaves with n items.
+	LeafFill []int
+	// Levels from the root down to the leaves.
+	Levels []LevelStats
+}
+
+// LevelStats describes the nodes at one height of a tree.
+type LevelStats struct {
+	Height  int
+	Nodes   int
+	Entries int
+	// Area is the total area of the nodes.
+	Area float64
+	// Overlap is the total area shared by pairs of sibling nodes.
+	Overlap float64
+	// DeadSpace is the total area of the nodes that is not covered by any
+	// of their children.
+	DeadSpace float64
+}
+
+// Stats returns statistics about the structure of the tree. It visits every
+// node, so it is meant for debugging and tuning.
+func (tr *RBush) Stats() Stats {
+	var stats Stats
+	stats.Height = tr.data.height
+	stats.LeafFill = make([]int, tr.maxEntries+1)
+	stats.Levels = make([]LevelStats, tr.data.height)
+	for i := range stats.Levels {
+		stats.Levels[i].Height = tr.data.height - i
+	}
+	if len(tr.data.children) == 0 {
+		stats.Nodes = 1
+		stats.Levels[0].Nodes = 1
+		stats.LeafFill[0] = 1
+		return stats
+	}
+	var visit func(node *treeNode)
+	visit = func(node *treeNode) {
+		level := &stats.Levels[tr.data.height-node.height]
+		level.Nodes++
+		level.Entries += len(node.children)
+		level.Area += node.area()
+		stats.Nodes++
+		boxes := make([]treeNode, len(node.children))
+		for i, ptr := range node.children {
+			boxes[i] = entryBBox(ptr, node.leaf)
+		}
+		level.DeadSpace += node.area() - unionArea(boxes, 0)
+		if node.leaf {
+			stats.Items += len(node.children)
+			if len(node.children) >= len(stats.LeafFill) {
+				fill := make([]int, len(node.children)+1)
+				copy(fill, stats.LeafFill)
+				stats.LeafFill = fill
+			}
+			stats.LeafFill[len(node.children)]++
+			return
+		}
+		child := &stats.Levels[tr.data.height-node.height+1]
+		for i := range boxes {
+			for j := i + 1; j < len(boxes); j++ {
+				child.Overlap += boxes[i].intersectionArea(&boxes[j])
+			}
+		}
+		for _, ptr := range node.children {
+			visit(ptr.(*treeNode))
+		}
+	}
+	visit(tr.data)
+	return stats
+}
+
+// unionArea returns the area covered by the boxes, by sweeping over the
+// edges of the boxes along each axis in turn.
+func unionArea(boxes []treeNode, axis int) float64 {
+	if len(boxes) == 0 {
+		return 0
+	}
+	if len(boxes) == 1 {
+		var box treeNode
+		box.min, box.max = boxes[0].min[axis:], boxes[0].max[axis:]
+		return box.area()
+	}
+	edges := make([]float64, 0, len(boxes)*2)
+	for _, box := range boxes {
+		edges = append(edges, box.min[axis], box.max[axis])
+	}
+	sort.Float64s(edges)
+	var area float64
+	var slab []treeNode
+	for i := 1; i < len(edges); i++ {
+		a, b := edges[i-1], edges[i]
+		if !(b > a) {
+			continue
+		}
+		slab = slab[:0]
+		for _, box := range boxes {
+			if box.min[axis] <= a && box.max[axis] >= b {
+				slab = append(slab, box)
+			}
+		}
+		if axis == len(boxes[0].min)-1 {
+			if len(slab) > 0 {
+				area += b - a
+			}
+		} else {
+			area += (b - a) * unionArea(slab, axis+1)
+		}
+	}
+	return area
+}
@@ -0,0 +1,101 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func TestValidate(t *testing.T) {
+	trees := map[string]*rbush.Options{
+		"default":   nil,
+		"rstar":     {Insertion: rbush.InsertRStar},
+		"quadratic": {Split: rbush.SplitQuadratic},
+		"linear":    {Split: rbush.SplitLinear, MaxEntries: 16},
+		"hilbert": {
+			Hilbert:    true,
+			HilbertMin: []float64{-50, -50, -50},
+			HilbertMax: []float64{50, 50, 50},
+		},
+		"tight":      {Packing: rbush.PackTight},
+		"aggregates": {Aggregates: true},
+	}
+	for name, opts := range trees {
+		t.Run(name, func(t *testing.T) {
+			rand.Seed(time.Now().UnixNano())
+			for _, which := range []string{"point", "rect"} {
+				var objs []rbush.Item
+				for i := 0; i < 5000; i++ {
+					objs = append(objs, makeRandom(which, 3))
+				}
+				tr := rbush.NewPacked(3, objs[:2500], opts)
+				assert.NoError(t, tr.Validate())
+				for _, obj := range objs[2500:] {
+					tr.Insert(obj)
+				}
+				assert.NoError(t, tr.Validate())
+				for _, i := range rand.Perm(len(objs))[:4000] {
+					tr.Remove(objs[i])
+					if i%100 == 0 {
+						assert.NoError(t, tr.Validate())
+					}
+				}
+				assert.NoError(t, tr.Validate())
+				tr.RemoveWhere([]float64{-20, -20, -20}, []float64{20, 20, 20}, nil)
+				assert.NoError(t, tr.Validate())
+				tx := tr.Begin()
+				for i := 0; i < 1000; i++ {
+					tx.Insert(makeRandom(which, 3))
+				}
+				tx.Commit()
+				assert.NoError(t, tr.Validate())
+
+				stats := tr.Stats()
+				assert.Equal(t, tr.Count(), stats.Items)
+				assert.Equal(t, 1, stats.Levels[0].Nodes)
+				assert.Equal(t, stats.Height, len(stats.Levels))
+				var leaves, nodes, entries int
+				for n, c := range stats.LeafFill {
+					leaves += c
+					entries += n * c
+				}
+				for _, level := range stats.Levels {
+					nodes += level.Nodes
+					assert.True(t, level.DeadSpace >= 0)
+					assert.True(t, level.DeadSpace <= level.Area)
+					assert.True(t, level.Overlap >= 0)
+				}
+				assert.Equal(t, stats.Levels[len(stats.Levels)-1].Nodes, leaves)
+				assert.Equal(t, stats.Items, entries)
+				assert.Equal(t, stats.Nodes, nodes)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	tr := rbush.New(2)
+	stats := tr.Stats()
+	assert.NoError(t, tr.Validate())
+	assert.Equal(t, 1, stats.Height)
+	assert.Equal(t, 1, stats.LeafFill[0])
+
+	tr.Insert(makeRect(0, 0, 1, 1))
+	tr.Insert(makeRect(2, 2, 3, 3))
+	tr.Insert(makeRect(0, 0, 2, 2))
+	stats = tr.Stats()
+	assert.Equal(t, 1, stats.LeafFill[3])
+	assert.Equal(t, 9.0, stats.Levels[0].Area)
+	// 1 + 1 + 4 minus the overlap of 1
+	assert.Equal(t, 9.0-5, stats.Levels[0].DeadSpace)
+
+	for i := 0; i < 100; i++ {
+		tr.Insert(makeRandom("rect", 2))
+	}
+	stats = tr.Stats()
+	assert.True(t, stats.Levels[1].Overlap > 0)
+	assert.NoError(t, tr.Validate())
+}
@@ -0,0 +1,193 @@
+package rbush
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrVersionNotFound is returned when querying a version that does not
+// exist, or that was removed by the retention policy.
+var ErrVersionNotFound = errors.New("version not found")
+
+// VersionedOptions for NewVersioned.
+type VersionedOptions struct {
+	// Options for the tree.
+	Options *Options
+	// Retain is the number of versions that are kept, including the current
+	// version. Zero keeps every version.
+	Retain int
+	// RetainFor is how long a version is kept after it was replaced by a
+	// newer version. Zero keeps versions regardless of their age.
+	RetainFor time.Duration
+}
+
+// Versioned is an RBush that keeps its past versions. Every change copies
+// only the nodes on the path to the changed entries, and the untouched
+// nodes are shared by all versions. Versions that fall outside of the
+// retention policy are released to the garbage collector.
+type Versioned struct {
+	tr       *RBush
+	opts     VersionedOptions
+	versions []version // oldest first, the last is the current version
+}
+
+type version struct {
+	num      uint64
+	root     *treeNode
+	replaced time.Time // when the next version was made
+}
+
+// NewVersioned returns an empty tree at version zero.
+func NewVersioned(dims int, opts *VersionedOptions) *Versioned {
+	v := &Versioned{}
+	if opts != nil {
+		v.opts = *opts
+	}
+	v.tr = NewOptions(dims, v.opts.Options)
+	v.versions = []version{{root: v.tr.data}}
+	return v
+}
+
+// write runs a change, and makes a new version when the tree changed.
+func (v *Versioned) write(change func()) uint64 {
+	// nodes of the current version are copied before they are changed
+	v.tr.gen = nextGeneration()
+	change()
+	cur := &v.versions[len(v.versions)-1]
+	if v.tr.data == cur.root {
+		return cur.num
+	}
+	cur.replaced = time.Now()
+	v.versions = append(v.versions, version{num: cur.num + 1, root: v.tr.data})
+	v.prune()
+	return cur.num + 1
+}
+
+func (v *Versioned) prune() {
+	var n int
+	if v.opts.Retain > 0 && len(v.versions) > v.opts.Retain {
+		n = len(v.versions) - v.opts.Retain
+	}
+	if v.opts.RetainFor > 0 {
+		now := time.Now()
+		for n < len(v.versions)-1 && now.Sub(v.versions[n].replaced) > v.opts.RetainFor {
+			n++
+		}
+	}
+	for i := 0; i < n; i++ {
+		v.versions[i] = version{}
+	}
+	v.versions = v.versions[n:]
+}
+
+// Insert adds an item and returns the new version.
+func (v *Versioned) Insert(item Item) uint64 {
+	return v.write(func() { v.tr.Insert(item) })
+}
+
+// Remove removes an item and returns the new version. The version does not
+// change when the item is not in the tree.
+func (v *Versioned) Remove(item Item) uint64 {
+	return v.write(func() { v.tr.Remove(item) })
+}
+
+// Update replaces the old item with a new item in a single version.
+func (v *Versioned) Update(old, item Item) uint64 {
+	return v.write(func() { v.tr.Update(old, item) })
+}
+
+// Commit applies the changes staged by fn in a single version, unless fn
+// rolls the transaction back. The transaction must not be kept after fn
+// returns.
+func (v *Versioned) Commit(fn func(tx *Tx)) uint64 {
+	return v.write(func() {
+		tx := v.tr.Begin()
+		fn(tx)
+		if !tx.done {
+			tx.Commit()
+		}
+	})
+}
+
+// Version returns the current version.
+func (v *Versioned) Version() uint64 {
+	return v.versions[len(v.versions)-1].num
+}
+
+// Oldest returns the oldest version that is retained.
+func (v *Versioned) Oldest() uint64 {
+	v.prune()
+	return v.versions[0].num
+}
+
+// Prune removes every version older than the provided version. The current
+// version is always kept.
+func (v *Versioned) Prune(before uint64) {
+	var n int
+	for n < len(v.versions)-1 && v.versions[n].num < before {
+		v.versions[n] = version{}
+		n++
+	}
+	v.versions = v.versions[n:]
+}
+
+// find returns the index of a version. The versions are consecutive.
+func (v *Versioned) find(num uint64) int {
+	if num < v.versions[0].num || num > v.versions[len(v.versions)-1].num {
+		return -1
+	}
+	return int(num - v.versions[0].num)
+}
+
+// At returns the tree as it was at version. The returned tree can be
+// changed without affecting the versioned tree.
+func (v *Versioned) At(num uint64) (*RBush, error) {
+	v.prune()
+	i := v.find(num)
+	if i < 0 {
+		return nil, ErrVersionNotFound
+	}
+	tr := v.tr.shadow()
+	tr.data = v.versions[i].root
+	return tr, nil
+}
+
+// SearchAt searches the tree as it was at version.
+func (v *Versioned) SearchAt(num uint64, bbox Item, iter func(item Item) bool) error {
+	tr, err := v.At(num)
+	if err != nil {
+		return err
+	}
+	tr.Search(bbox, iter)
+	return nil
+}
+
+// KNNAt finds the nearest items in the tree as it was at version.
+func (v *Versioned) KNNAt(num uint64, point []float64, iter func(item Item, dist float64) bool) error {
+	tr, err := v.At(num)
+	if err != nil {
+		return err
+	}
+	tr.KNN(point, iter)
+	return nil
+}
+
+func (v *Versioned) Search(bbox Item, iter func(item Item) bool) bool {
+	return v.tr.Search(bbox, iter)
+}
+
+func (v *Versioned) KNN(point []float64, iter func(item Item, dist float64) bool) bool {
+	return v.tr.KNN(point, iter)
+}
+
+func (v *Versioned) Scan(iter func(item Item) bool) bool {
+	return v.tr.Scan(iter)
+}
+
+func (v *Versioned) Count() int {
+	return v.tr.Count()
+}
+
+func (v *Versioned) Bounds() (min, max []float64) {
+	return v.tr.Bounds()
+}
@@ -0,0 +1,110 @@
+package rbush_test
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+)
+
+func TestVersioned(t *testing.T) {
+	rand.Seed(time.Now().UnixNano())
+	v := rbush.NewVersioned(2, nil)
+	history := [][]rbush.Item{nil}
+	var live []rbush.Item
+	for i := 0; i < 3000; i++ {
+		var ver uint64
+		if len(live) > 0 && rand.Intn(3) == 0 {
+			j := rand.Intn(len(live))
+			ver = v.Remove(live[j])
+			live[j] = live[len(live)-1]
+			live = live[:len(live)-1]
+		} else {
+			obj := makeRandom("rect", 2)
+			ver = v.Insert(obj)
+			live = append(live, obj)
+		}
+		assert.Equal(t, uint64(len(history)), ver)
+		history = append(history, append([]rbush.Item(nil), live...))
+	}
+	assert.Equal(t, uint64(len(history)-1), v.Version())
+	assert.Equal(t, v.Version(), v.Remove(makeRandom("rect", 2)))
+
+	box := makeRect(-25, -25, 25, 25)
+	for i := 0; i < 100; i++ {
+		ver := rand.Intn(len(history))
+		var found []rbush.Item
+		assert.NoError(t, v.SearchAt(uint64(ver), box, func(item rbush.Item) bool {
+			found = append(found, item)
+			return true
+		}))
+		var expect []rbush.Item
+		for _, obj := range history[ver] {
+			if testIntersects(obj, box) {
+				expect = append(expect, obj)
+			}
+		}
+		assert.True(t, testHasSameItems(expect, found))
+
+		var n int
+		last := -1.0
+		assert.NoError(t, v.KNNAt(uint64(ver), []float64{0, 0}, func(item rbush.Item, dist float64) bool {
+			assert.True(t, dist >= last)
+			last = dist
+			n++
+			return true
+		}))
+		assert.Equal(t, len(history[ver]), n)
+	}
+
+	// a past version can be changed without affecting the history
+	old, err := v.At(100)
+	assert.NoError(t, err)
+	old.Insert(makeRandom("rect", 2))
+	tr, err := v.At(100)
+	assert.NoError(t, err)
+	assert.Equal(t, len(history[100]), tr.Count())
+	assert.Equal(t, len(live), v.Count())
+
+	v.Prune(1000)
+	assert.Equal(t, uint64(1000), v.Oldest())
+	assert.Equal(t, rbush.ErrVersionNotFound, v.SearchAt(999, box, nil))
+	_, err = v.At(v.Version() + 1)
+	assert.Equal(t, rbush.ErrVersionNotFound, err)
+	_, err = v.At(1000)
+	assert.NoError(t, err)
+}
+
+func TestVersionedRetain(t *testing.T) {
+	v := rbush.NewVersioned(2, &rbush.VersionedOptions{Retain: 10})
+	for i := 0; i < 100; i++ {
+		v.Insert(makeRandom("point", 2))
+	}
+	assert.Equal(t, uint64(91), v.Oldest())
+	tr, err := v.At(91)
+	assert.NoError(t, err)
+	assert.Equal(t, 91, tr.Count())
+
+	// a batch makes a single version
+	objs := []rbush.Item{makeRandom("point", 2), makeRandom("point", 2)}
+	ver := v.Commit(func(tx *rbush.Tx) {
+		tx.Insert(objs[0])
+		tx.Insert(objs[1])
+	})
+	assert.Equal(t, uint64(101), ver)
+	assert.Equal(t, ver, v.Commit(func(tx *rbush.Tx) {
+		tx.Remove(objs[0])
+		tx.Rollback()
+	}))
+	assert.Equal(t, 102, v.Count())
+
+	v = rbush.NewVersioned(2, &rbush.VersionedOptions{RetainFor: time.Second / 2})
+	v.Insert(makeRandom("point", 2))
+	v.Insert(makeRandom("point", 2))
+	assert.Equal(t, uint64(0), v.Oldest())
+	time.Sleep(time.Second)
+	assert.Equal(t, uint64(2), v.Oldest())
+	assert.Equal(t, rbush.ErrVersionNotFound, v.KNNAt(1, []float64{0, 0}, nil))
+}
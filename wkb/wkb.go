@@ -0,0 +1,420 @@
+// Package wkb reads and writes geometries as Well-Known Binary.
+//
+// Both ISO binary, where a Z, M or ZM geometry adds 1000, 2000 or 3000 to
+// its type, and the extended binary of PostGIS, where flags in the type
+// mark Z, M and an SRID, are read. An empty point is a point with NaN
+// coordinates.
+package wkb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tidwall/rbush/geom"
+)
+
+const (
+	wkbPoint = iota + 1
+	wkbLineString
+	wkbPolygon
+	wkbMultiPoint
+	wkbMultiLineString
+	wkbMultiPolygon
+	wkbGeometryCollection
+)
+
+// flags of extended WKB
+const (
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+var errShort = errors.New("wkb: data is too short")
+
+type reader struct {
+	data   []byte
+	order  binary.ByteOrder
+	layout geom.Layout
+}
+
+// Parse parses a geometry from WKB or EWKB.
+func Parse(data []byte) (*geom.Shape, error) {
+	r := &reader{data: data}
+	g, layout, srid, err := r.geometry(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("wkb: %d bytes after geometry", len(r.data))
+	}
+	return geom.NewShape(g, layout, srid), nil
+}
+
+// ParseHex parses a geometry from hex encoded WKB or EWKB, which is how
+// PostGIS writes geometries as text.
+func ParseHex(s string) (*geom.Shape, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("wkb: %v", err)
+	}
+	return Parse(data)
+}
+
+func (r *reader) uint32() (uint32, error) {
+	if len(r.data) < 4 {
+		return 0, errShort
+	}
+	v := r.order.Uint32(r.data)
+	r.data = r.data[4:]
+	return v, nil
+}
+
+// count reads the number of elements that follow, each of which is at
+// least size bytes.
+func (r *reader) count(size int) (int, error) {
+	n, err := r.uint32()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(n)*uint64(size) > uint64(len(r.data)) {
+		return 0, errShort
+	}
+	return int(n), nil
+}
+
+// geometry reads a geometry with its header. The kind is the expected
+// kind of geometry, or zero for any. The layout of a nested geometry must
+// match the layout of its parent.
+func (r *reader) geometry(kind uint32) (g geom.Geometry, layout geom.Layout, srid int, err error) {
+	if len(r.data) < 1 {
+		return nil, 0, 0, errShort
+	}
+	switch r.data[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return nil, 0, 0, fmt.Errorf("wkb: invalid byte order %d", r.data[0])
+	}
+	r.data = r.data[1:]
+	typ, err := r.uint32()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	z, m := typ&ewkbZ != 0, typ&ewkbM != 0
+	if typ&ewkbSRID != 0 {
+		v, err := r.uint32()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		srid = int(int32(v))
+	}
+	typ &^= ewkbZ | ewkbM | ewkbSRID
+	switch typ / 1000 {
+	case 1:
+		z = true
+	case 2:
+		m = true
+	case 3:
+		z, m = true, true
+	case 0:
+	default:
+		return nil, 0, 0, fmt.Errorf("wkb: unknown geometry type %d", typ)
+	}
+	switch {
+	case z && m:
+		layout = geom.XYZM
+	case z:
+		layout = geom.XYZ
+	case m:
+		layout = geom.XYM
+	}
+	typ %= 1000
+	if kind != 0 {
+		if typ != kind {
+			return nil, 0, 0, fmt.Errorf("wkb: geometry type %d in a collection of %d", typ, kind)
+		}
+		if layout != r.layout {
+			return nil, 0, 0, fmt.Errorf("wkb: %s geometry in a %s collection", layout, r.layout)
+		}
+	}
+	r.layout = layout
+	switch typ {
+	case wkbPoint:
+		g, err = r.point()
+	case wkbLineString:
+		g, err = r.lineString()
+	case wkbPolygon:
+		g, err = r.polygon()
+	case wkbMultiPoint:
+		var mp geom.MultiPoint
+		err = r.list(func() error {
+			g, _, _, err := r.geometry(wkbPoint)
+			if err == nil {
+				mp = append(mp, g.(geom.Point))
+			}
+			return err
+		})
+		g = mp
+	case wkbMultiLineString:
+		var mls geom.MultiLineString
+		err = r.list(func() error {
+			g, _, _, err := r.geometry(wkbLineString)
+			if err == nil {
+				mls = append(mls, g.(geom.LineString))
+			}
+			return err
+		})
+		g = mls
+	case wkbMultiPolygon:
+		var mp geom.MultiPolygon
+		err = r.list(func() error {
+			g, _, _, err := r.geometry(wkbPolygon)
+			if err == nil {
+				mp = append(mp, g.(geom.Polygon))
+			}
+			return err
+		})
+		g = mp
+	case wkbGeometryCollection:
+		var gc geom.GeometryCollection
+		err = r.list(func() error {
+			g, _, _, err := r.geometry(0)
+			if err == nil {
+				if r.layout != layout {
+					err = fmt.Errorf("wkb: %s geometry in a %s collection", r.layout, layout)
+				}
+				gc = append(gc, g)
+			}
+			return err
+		})
+		g = gc
+	default:
+		return nil, 0, 0, fmt.Errorf("wkb: unknown geometry type %d", typ)
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return g, layout, srid, nil
+}
+
+// list reads a count followed by that many geometries, which are at least
+// a header and a count each. The byte order of each geometry is its own.
+func (r *reader) list(elem func() error) error {
+	n, err := r.count(9)
+	if err != nil {
+		return err
+	}
+	order, layout := r.order, r.layout
+	for i := 0; i < n; i++ {
+		if err := elem(); err != nil {
+			return err
+		}
+		r.order, r.layout = order, layout
+	}
+	return nil
+}
+
+func (r *reader) point() (geom.Point, error) {
+	pt, err := r.position()
+	if err != nil {
+		return nil, err
+	}
+	nan := 0
+	for _, f := range pt {
+		if math.IsNaN(f) {
+			nan++
+		} else if math.IsInf(f, 0) {
+			return nil, errors.New("wkb: point has infinite coordinates")
+		}
+	}
+	switch nan {
+	case 0:
+		return pt, nil
+	case len(pt):
+		return geom.Point(nil), nil
+	}
+	return nil, errors.New("wkb: point has NaN coordinates")
+}
+
+func (r *reader) position() (geom.Point, error) {
+	dims := r.layout.Dims()
+	if len(r.data) < dims*8 {
+		return nil, errShort
+	}
+	pt := make(geom.Point, dims)
+	for i := range pt {
+		pt[i] = math.Float64frombits(r.order.Uint64(r.data[i*8:]))
+	}
+	r.data = r.data[dims*8:]
+	return pt, nil
+}
+
+func (r *reader) lineString() (geom.LineString, error) {
+	n, err := r.count(r.layout.Dims() * 8)
+	if err != nil {
+		return nil, err
+	}
+	var ls geom.LineString
+	if n > 0 {
+		ls = make(geom.LineString, n)
+	}
+	for i := range ls {
+		if ls[i], err = r.position(); err != nil {
+			return nil, err
+		}
+		for _, f := range ls[i] {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, errors.New("wkb: position has coordinates that are not finite")
+			}
+		}
+	}
+	return ls, nil
+}
+
+func (r *reader) polygon() (geom.Polygon, error) {
+	n, err := r.count(4)
+	if err != nil {
+		return nil, err
+	}
+	var poly geom.Polygon
+	if n > 0 {
+		poly = make(geom.Polygon, n)
+	}
+	for i := range poly {
+		if poly[i], err = r.lineString(); err != nil {
+			return nil, err
+		}
+	}
+	return poly, nil
+}
+
+// Marshal returns a shape as little endian ISO WKB. The SRID is not
+// written.
+func Marshal(s *geom.Shape) []byte {
+	return appendGeometry(nil, s.Geometry, s.Layout, false, 0)
+}
+
+// MarshalEWKB returns a shape as little endian extended WKB, with the SRID
+// when the shape has one.
+func MarshalEWKB(s *geom.Shape) []byte {
+	return appendGeometry(nil, s.Geometry, s.Layout, true, s.SRID)
+}
+
+func appendGeometry(dst []byte, g geom.Geometry, layout geom.Layout, ewkb bool, srid int) []byte {
+	var typ uint32
+	switch g.(type) {
+	case geom.Point:
+		typ = wkbPoint
+	case geom.LineString:
+		typ = wkbLineString
+	case geom.Polygon:
+		typ = wkbPolygon
+	case geom.MultiPoint:
+		typ = wkbMultiPoint
+	case geom.MultiLineString:
+		typ = wkbMultiLineString
+	case geom.MultiPolygon:
+		typ = wkbMultiPolygon
+	case geom.GeometryCollection:
+		typ = wkbGeometryCollection
+	default:
+		panic(fmt.Sprintf("wkb: unsupported geometry %T", g))
+	}
+	if ewkb {
+		switch layout {
+		case geom.XYZ:
+			typ |= ewkbZ
+		case geom.XYM:
+			typ |= ewkbM
+		case geom.XYZM:
+			typ |= ewkbZ | ewkbM
+		}
+		if srid != 0 {
+			typ |= ewkbSRID
+		}
+	} else {
+		// the layouts are in the order of the ISO type offsets
+		typ += uint32(layout) * 1000
+	}
+	dst = append(dst, 1)
+	dst = appendUint32(dst, typ)
+	if ewkb && srid != 0 {
+		dst = appendUint32(dst, uint32(srid))
+	}
+	switch g := g.(type) {
+	case geom.Point:
+		if len(g) == 0 {
+			g = make(geom.Point, layout.Dims())
+			for i := range g {
+				g[i] = math.NaN()
+			}
+		}
+		return appendPosition(dst, g)
+	case geom.LineString:
+		return appendPoints(dst, g)
+	case geom.Polygon:
+		return appendRings(dst, g)
+	case geom.MultiPoint:
+		dst = appendUint32(dst, uint32(len(g)))
+		for _, pt := range g {
+			dst = appendGeometry(dst, pt, layout, ewkb, 0)
+		}
+	case geom.MultiLineString:
+		dst = appendUint32(dst, uint32(len(g)))
+		for _, ls := range g {
+			dst = appendGeometry(dst, ls, layout, ewkb, 0)
+		}
+	case geom.MultiPolygon:
+		dst = appendUint32(dst, uint32(len(g)))
+		for _, poly := range g {
+			dst = appendGeometry(dst, poly, layout, ewkb, 0)
+		}
+	case geom.GeometryCollection:
+		dst = appendUint32(dst, uint32(len(g)))
+		for _, g := range g {
+			dst = appendGeometry(dst, g, layout, ewkb, 0)
+		}
+	}
+	return dst
+}
+
+func appendPosition(dst []byte, pt geom.Point) []byte {
+	for _, f := range pt {
+		dst = appendUint64(dst, math.Float64bits(f))
+	}
+	return dst
+}
+
+func appendPoints(dst []byte, pts []geom.Point) []byte {
+	dst = appendUint32(dst, uint32(len(pts)))
+	for _, pt := range pts {
+		dst = appendPosition(dst, pt)
+	}
+	return dst
+}
+
+func appendRings(dst []byte, rings []geom.LineString) []byte {
+	dst = appendUint32(dst, uint32(len(rings)))
+	for _, ring := range rings {
+		dst = appendPoints(dst, ring)
+	}
+	return dst
+}
+
+func appendUint32(dst []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+func appendUint64(dst []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
@@ -0,0 +1,134 @@
+package wkb_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geom"
+	"github.com/tidwall/rbush/wkb"
+	"github.com/tidwall/rbush/wkt"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		hex  string
+		text string
+	}{
+		// ST_AsBinary and ST_AsEWKB from PostGIS
+		{"0101000000000000000000F03F0000000000000040", "POINT (1 2)"},
+		{"0101000020E6100000000000000000F03F0000000000000040", "SRID=4326;POINT (1 2)"},
+		{"01E9030000000000000000F03F00000000000000400000000000000840", "POINT Z (1 2 3)"},
+		{"0101000080000000000000F03F00000000000000400000000000000840", "POINT Z (1 2 3)"},
+		{"01D1070000000000000000F03F00000000000000400000000000000840", "POINT M (1 2 3)"},
+		{"0101000040000000000000F03F00000000000000400000000000000840", "POINT M (1 2 3)"},
+		{"01B90B0000000000000000F03F000000000000004000000000000008400000000000001040", "POINT ZM (1 2 3 4)"},
+		{"01010000E0110F0000000000000000F03F000000000000004000000000000008400000000000001040", "SRID=3857;POINT ZM (1 2 3 4)"},
+		{"0101000000000000000000F87F000000000000F87F", "POINT EMPTY"},
+		// big endian
+		{"00000000013FF00000000000004000000000000000", "POINT (1 2)"},
+		{"0000000002000000023FF0000000000000400000000000000040080000000000004010000000000000", "LINESTRING (1 2, 3 4)"},
+		// a multipoint with points of both byte orders
+		{"0104000000020000000101000000000000000000F03F0000000000000040000000000140080000000000004010000000000000", "MULTIPOINT ((1 2), (3 4))"},
+		{"010700000000000000", "GEOMETRYCOLLECTION EMPTY"},
+	}
+	for _, test := range tests {
+		s, err := wkb.ParseHex(test.hex)
+		if !assert.NoError(t, err, test.text) {
+			continue
+		}
+		assert.Equal(t, test.text, wkt.Format(s))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	texts := []string{
+		"POINT (1 2)",
+		"SRID=4326;POINT (-71.06 42.35)",
+		"POINT ZM EMPTY",
+		"LINESTRING Z (0 0 0, 1 1 1, 2 0 5)",
+		"LINESTRING EMPTY",
+		"POLYGON ((0 0, 4 0, 4 4, 0 0), (1 1, 2 1, 2 2, 1 1))",
+		"SRID=2154;MULTIPOINT M ((1 2 3), EMPTY, (3 4 5))",
+		"MULTILINESTRING ((0 0, 1 1), (2 2, 3 3))",
+		"MULTIPOLYGON ZM (((0 0 0 0, 1 0 0 0, 1 1 0 0, 0 0 0 0)), EMPTY)",
+		"GEOMETRYCOLLECTION Z (POINT Z (1 2 3), GEOMETRYCOLLECTION Z (LINESTRING Z (0 0 0, 1 1 1)))",
+	}
+	for _, text := range texts {
+		s, err := wkt.Parse(text)
+		assert.NoError(t, err)
+
+		again, err := wkb.Parse(wkb.MarshalEWKB(s))
+		if assert.NoError(t, err, text) {
+			assert.Equal(t, s, again, text)
+		}
+
+		// ISO WKB drops the SRID
+		again, err = wkb.Parse(wkb.Marshal(s))
+		if assert.NoError(t, err, text) {
+			assert.Equal(t, 0, again.SRID)
+			again.SRID = s.SRID
+			assert.Equal(t, s, again, text)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	point := "0101000000000000000000F03F0000000000000040"
+	bad := []string{
+		"",
+		"01",
+		"0201000000",
+		"0108000000",
+		"01A10F0000",
+		point[:len(point)-2],
+		point + "00",
+		// NaN x with a finite y
+		"0101000000000000000000F87F0000000000000040",
+		// +Inf x, and a linestring with a -Inf y
+		"0101000000000000000000F07F0000000000000040",
+		"010200000002000000" + "00000000000000000000000000000000" + "000000000000F03F000000000000F0FF",
+		// a linestring that claims a billion points
+		"0102000000FFFFFF3F",
+		// a multipoint holding a linestring
+		"0104000000010000000102000000" + "00000000",
+		// a Z multipoint holding an XY point
+		"01EC03000001000000" + point,
+		// a Z collection holding an XY point
+		"01EF03000001000000" + point,
+	}
+	for _, s := range bad {
+		_, err := wkb.ParseHex(s)
+		assert.Error(t, err, s)
+	}
+	_, err := wkb.ParseHex("zz")
+	assert.Error(t, err)
+}
+
+func TestShapes(t *testing.T) {
+	tr := rbush.New(4)
+	var want []string
+	for i, text := range []string{
+		"POINT ZM (1 2 3 4)",
+		"LINESTRING ZM (0 0 0 0, 10 10 10 10)",
+		"POINT ZM (50 50 50 50)",
+	} {
+		s, err := wkt.Parse(text)
+		assert.NoError(t, err)
+		data := wkb.Marshal(s)
+		s, err = wkb.Parse(data)
+		assert.NoError(t, err)
+		tr.Insert(s)
+		if i < 2 {
+			want = append(want, strings.ToUpper(hex.EncodeToString(data)))
+		}
+	}
+	var found []string
+	tr.Search(geom.LineString{{0, 0, 0, 0}, {5, 5, 5, 5}}, func(item rbush.Item) bool {
+		found = append(found, strings.ToUpper(hex.EncodeToString(wkb.Marshal(item.(*geom.Shape)))))
+		return true
+	})
+	assert.ElementsMatch(t, want, found)
+}
@@ -0,0 +1,395 @@
+// Package wkt reads and writes geometries as Well-Known Text.
+//
+// Both ISO text, such as "POINT Z (1 2 3)", and the extended text of
+// PostGIS, such as "SRID=4326;POINTM(1 2 3)", are read. Positions without
+// a Z or M tag have a layout that follows their number of coordinates, so
+// three are XYZ and four are XYZM.
+package wkt
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/rbush/geom"
+)
+
+var types = []string{
+	"GEOMETRYCOLLECTION", "MULTILINESTRING", "MULTIPOLYGON", "MULTIPOINT",
+	"LINESTRING", "POLYGON", "POINT",
+}
+
+type parser struct {
+	s      string
+	pos    int
+	layout geom.Layout
+	known  bool // the layout is known
+}
+
+// Parse parses a geometry from WKT or EWKT.
+func Parse(s string) (*geom.Shape, error) {
+	p := &parser{s: s}
+	var srid int
+	p.skip()
+	if rest := p.s[p.pos:]; len(rest) > 5 && strings.EqualFold(rest[:5], "SRID=") {
+		end := strings.IndexByte(rest, ';')
+		if end == -1 {
+			return nil, errors.New("wkt: missing ; after SRID")
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(rest[5:end]))
+		if err != nil {
+			return nil, fmt.Errorf("wkt: invalid SRID %q", rest[5:end])
+		}
+		srid = n
+		p.pos += end + 1
+	}
+	g, err := p.geometry()
+	if err == nil {
+		p.skip()
+		if p.pos < len(p.s) {
+			err = p.errorf("unexpected %q", p.s[p.pos:])
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return geom.NewShape(g, p.layout, srid), nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("wkt: at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skip() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+// peek returns the next character, or zero at the end.
+func (p *parser) peek() byte {
+	p.skip()
+	if p.pos == len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		if p.pos == len(p.s) {
+			return p.errorf("expected %q, got end of text", c)
+		}
+		return p.errorf("expected %q, got %q", c, p.s[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+// word returns the next word in upper case.
+func (p *parser) word() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos] | 0x20
+		if c < 'a' || c > 'z' {
+			break
+		}
+		p.pos++
+	}
+	return strings.ToUpper(p.s[start:p.pos])
+}
+
+// empty reads the EMPTY keyword when it is next.
+func (p *parser) empty() bool {
+	pos := p.pos
+	if p.word() == "EMPTY" {
+		return true
+	}
+	p.pos = pos
+	return false
+}
+
+// setLayout sets the layout of the geometry, which must match the layout
+// of the positions that are already read.
+func (p *parser) setLayout(layout geom.Layout) error {
+	if p.known && layout != p.layout {
+		return p.errorf("%s does not match %s", layout, p.layout)
+	}
+	p.layout, p.known = layout, true
+	return nil
+}
+
+func (p *parser) geometry() (geom.Geometry, error) {
+	word := p.word()
+	var typ string
+	for _, t := range types {
+		if strings.HasPrefix(word, t) {
+			typ = t
+			break
+		}
+	}
+	if typ == "" {
+		return nil, p.errorf("unknown geometry type %q", word)
+	}
+	tag := word[len(typ):]
+	if tag == "" {
+		pos := p.pos
+		if tag = p.word(); tag != "Z" && tag != "M" && tag != "ZM" {
+			tag, p.pos = "", pos
+		}
+	}
+	switch tag {
+	case "":
+	case "Z":
+		if err := p.setLayout(geom.XYZ); err != nil {
+			return nil, err
+		}
+	case "M":
+		if err := p.setLayout(geom.XYM); err != nil {
+			return nil, err
+		}
+	case "ZM":
+		if err := p.setLayout(geom.XYZM); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf("unknown geometry type %q", word)
+	}
+	switch typ {
+	case "POINT":
+		if p.empty() {
+			return geom.Point(nil), nil
+		}
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		pt, err := p.position()
+		if err != nil {
+			return nil, err
+		}
+		return pt, p.expect(')')
+	case "LINESTRING":
+		return p.lineString()
+	case "POLYGON":
+		return p.polygon()
+	case "MULTIPOINT":
+		var mp geom.MultiPoint
+		err := p.list(func() error {
+			// the points may or may not be in parentheses
+			var pt geom.Point
+			var err error
+			switch {
+			case p.empty():
+			case p.peek() == '(':
+				p.pos++
+				if pt, err = p.position(); err == nil {
+					err = p.expect(')')
+				}
+			default:
+				pt, err = p.position()
+			}
+			mp = append(mp, pt)
+			return err
+		})
+		return mp, err
+	case "MULTILINESTRING":
+		var mls geom.MultiLineString
+		err := p.list(func() error {
+			ls, err := p.lineString()
+			mls = append(mls, ls)
+			return err
+		})
+		return mls, err
+	case "MULTIPOLYGON":
+		var mp geom.MultiPolygon
+		err := p.list(func() error {
+			poly, err := p.polygon()
+			mp = append(mp, poly)
+			return err
+		})
+		return mp, err
+	default:
+		var gc geom.GeometryCollection
+		err := p.list(func() error {
+			g, err := p.geometry()
+			gc = append(gc, g)
+			return err
+		})
+		return gc, err
+	}
+}
+
+// list reads EMPTY, or a list of elements in parentheses.
+func (p *parser) list(elem func() error) error {
+	if p.empty() {
+		return nil
+	}
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	for {
+		if err := elem(); err != nil {
+			return err
+		}
+		if p.peek() != ',' {
+			return p.expect(')')
+		}
+		p.pos++
+	}
+}
+
+func (p *parser) lineString() (geom.LineString, error) {
+	var ls geom.LineString
+	err := p.list(func() error {
+		pt, err := p.position()
+		ls = append(ls, pt)
+		return err
+	})
+	return ls, err
+}
+
+func (p *parser) polygon() (geom.Polygon, error) {
+	var poly geom.Polygon
+	err := p.list(func() error {
+		ls, err := p.lineString()
+		poly = append(poly, ls)
+		return err
+	})
+	return poly, err
+}
+
+func (p *parser) position() (geom.Point, error) {
+	var pt geom.Point
+	for len(pt) < 4 {
+		p.skip()
+		start := p.pos
+		for p.pos < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.pos]) != -1 {
+			p.pos++
+		}
+		if start == p.pos {
+			break
+		}
+		text := p.s[start:p.pos]
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", text)
+		}
+		pt = append(pt, f)
+	}
+	layout := geom.XY
+	switch len(pt) {
+	case 0, 1:
+		return nil, p.errorf("position has %d coordinates", len(pt))
+	case 3:
+		layout = geom.XYZ
+	case 4:
+		layout = geom.XYZM
+	}
+	if !p.known {
+		p.setLayout(layout)
+	} else if len(pt) != p.layout.Dims() {
+		return nil, p.errorf("position has %d coordinates, expected %d", len(pt),
+			p.layout.Dims())
+	}
+	return pt, nil
+}
+
+// Format returns a shape as WKT, with an SRID prefix when it has an SRID.
+func Format(s *geom.Shape) string {
+	var dst []byte
+	if s.SRID != 0 {
+		dst = append(dst, "SRID="...)
+		dst = strconv.AppendInt(dst, int64(s.SRID), 10)
+		dst = append(dst, ';')
+	}
+	return string(Append(dst, s.Geometry, s.Layout))
+}
+
+// Append appends a geometry as ISO WKT to dst.
+func Append(dst []byte, g geom.Geometry, layout geom.Layout) []byte {
+	dst = append(dst, strings.ToUpper(g.Type())...)
+	switch layout {
+	case geom.XYZ:
+		dst = append(dst, " Z"...)
+	case geom.XYM:
+		dst = append(dst, " M"...)
+	case geom.XYZM:
+		dst = append(dst, " ZM"...)
+	}
+	dst = append(dst, ' ')
+	switch g := g.(type) {
+	case geom.Point:
+		if len(g) == 0 {
+			return append(dst, "EMPTY"...)
+		}
+		return appendPoints(dst, []geom.Point{g})
+	case geom.LineString:
+		return appendPoints(dst, g)
+	case geom.Polygon:
+		return appendRings(dst, g)
+	case geom.MultiPoint:
+		return appendList(dst, len(g), func(dst []byte, i int) []byte {
+			if len(g[i]) == 0 {
+				return append(dst, "EMPTY"...)
+			}
+			return appendPoints(dst, g[i:i+1])
+		})
+	case geom.MultiLineString:
+		return appendRings(dst, g)
+	case geom.MultiPolygon:
+		return appendList(dst, len(g), func(dst []byte, i int) []byte {
+			return appendRings(dst, g[i])
+		})
+	case geom.GeometryCollection:
+		return appendList(dst, len(g), func(dst []byte, i int) []byte {
+			return Append(dst, g[i], layout)
+		})
+	}
+	panic(fmt.Sprintf("wkt: unsupported geometry %T", g))
+}
+
+// appendList appends EMPTY, or n elements in parentheses.
+func appendList(dst []byte, n int, elem func(dst []byte, i int) []byte) []byte {
+	if n == 0 {
+		return append(dst, "EMPTY"...)
+	}
+	dst = append(dst, '(')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		dst = elem(dst, i)
+	}
+	return append(dst, ')')
+}
+
+func appendPoints(dst []byte, pts []geom.Point) []byte {
+	return appendList(dst, len(pts), func(dst []byte, i int) []byte {
+		for j, f := range pts[i] {
+			if j > 0 {
+				dst = append(dst, ' ')
+			}
+			dst = appendFloat(dst, f)
+		}
+		return dst
+	})
+}
+
+func appendRings(dst []byte, rings []geom.LineString) []byte {
+	return appendList(dst, len(rings), func(dst []byte, i int) []byte {
+		return appendPoints(dst, rings[i])
+	})
+}
+
+// appendFloat appends the shortest text that reads back as f, with an
+// exponent only for very large and very small values.
+func appendFloat(dst []byte, f float64) []byte {
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.AppendFloat(dst, f, 'e', -1, 64)
+	}
+	return strconv.AppendFloat(dst, f, 'f', -1, 64)
+}
@@ -0,0 +1,130 @@
+package wkt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/rbush"
+	"github.com/tidwall/rbush/geom"
+	"github.com/tidwall/rbush/wkt"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text   string
+		g      geom.Geometry
+		layout geom.Layout
+		srid   int
+		format string // when it is not written back as the text
+	}{
+		{"POINT (1 2)", geom.Point{1, 2}, geom.XY, 0, ""},
+		{"point(1.5 -2e3)", geom.Point{1.5, -2000}, geom.XY, 0, "POINT (1.5 -2000)"},
+		{"POINT Z (1 2 3)", geom.Point{1, 2, 3}, geom.XYZ, 0, ""},
+		{"POINT(1 2 3)", geom.Point{1, 2, 3}, geom.XYZ, 0, "POINT Z (1 2 3)"},
+		{"POINT M (1 2 3)", geom.Point{1, 2, 3}, geom.XYM, 0, ""},
+		{"POINTM(1 2 3)", geom.Point{1, 2, 3}, geom.XYM, 0, "POINT M (1 2 3)"},
+		{"POINT ZM (1 2 3 4)", geom.Point{1, 2, 3, 4}, geom.XYZM, 0, ""},
+		{"POINT (1 2 3 4)", geom.Point{1, 2, 3, 4}, geom.XYZM, 0, "POINT ZM (1 2 3 4)"},
+		{"SRID=4326;POINT (-71.06 42.35)", geom.Point{-71.06, 42.35}, geom.XY, 4326, ""},
+		{"srid=3857; POINT EMPTY", geom.Point(nil), geom.XY, 3857, "SRID=3857;POINT EMPTY"},
+		{"POINT Z EMPTY", geom.Point(nil), geom.XYZ, 0, ""},
+		{"LINESTRING (0 0, 1 1, 2 0)", geom.LineString{{0, 0}, {1, 1}, {2, 0}}, geom.XY, 0, ""},
+		{"LINESTRING EMPTY", geom.LineString(nil), geom.XY, 0, ""},
+		{
+			"POLYGON ((0 0, 4 0, 4 4, 0 0), (1 1, 2 1, 2 2, 1 1))",
+			geom.Polygon{{{0, 0}, {4, 0}, {4, 4}, {0, 0}}, {{1, 1}, {2, 1}, {2, 2}, {1, 1}}},
+			geom.XY, 0, "",
+		},
+		{"MULTIPOINT ((1 2), (3 4))", geom.MultiPoint{{1, 2}, {3, 4}}, geom.XY, 0, ""},
+		{"MULTIPOINT (1 2, 3 4)", geom.MultiPoint{{1, 2}, {3, 4}}, geom.XY, 0, "MULTIPOINT ((1 2), (3 4))"},
+		{"MULTIPOINT (EMPTY, (3 4))", geom.MultiPoint{nil, {3, 4}}, geom.XY, 0, ""},
+		{
+			"MULTILINESTRING Z ((0 0 0, 1 1 1), (2 2 2, 3 3 3))",
+			geom.MultiLineString{{{0, 0, 0}, {1, 1, 1}}, {{2, 2, 2}, {3, 3, 3}}},
+			geom.XYZ, 0, "",
+		},
+		{
+			"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), ((5 5, 6 5, 6 6, 5 5)), EMPTY)",
+			geom.MultiPolygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, {{{5, 5}, {6, 5}, {6, 6}, {5, 5}}}, nil},
+			geom.XY, 0, "",
+		},
+		{
+			"GEOMETRYCOLLECTION M (POINT M (1 2 3), LINESTRING M (0 0 1, 1 1 2))",
+			geom.GeometryCollection{geom.Point{1, 2, 3}, geom.LineString{{0, 0, 1}, {1, 1, 2}}},
+			geom.XYM, 0, "",
+		},
+		{
+			"GEOMETRYCOLLECTION (POINT (1 2), GEOMETRYCOLLECTION EMPTY)",
+			geom.GeometryCollection{geom.Point{1, 2}, geom.GeometryCollection(nil)},
+			geom.XY, 0, "",
+		},
+		{"POINT (1e-9 1e+30)", geom.Point{1e-9, 1e30}, geom.XY, 0, "POINT (1e-09 1e+30)"},
+	}
+	for _, test := range tests {
+		s, err := wkt.Parse(test.text)
+		if !assert.NoError(t, err, test.text) {
+			continue
+		}
+		assert.Equal(t, test.g, s.Geometry, test.text)
+		assert.Equal(t, test.layout, s.Layout, test.text)
+		assert.Equal(t, test.srid, s.SRID, test.text)
+		format := test.format
+		if format == "" {
+			format = test.text
+		}
+		assert.Equal(t, format, wkt.Format(s))
+		again, err := wkt.Parse(wkt.Format(s))
+		assert.NoError(t, err)
+		assert.Equal(t, s, again)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	bad := []string{
+		"",
+		"CIRCLE (1 2)",
+		"POINTS (1 2)",
+		"POINT",
+		"POINT (1)",
+		"POINT (1 2 3 4 5)",
+		"POINT (1 2",
+		"POINT (1 2) x",
+		"POINT (1 NaN)",
+		"POINT (1 1e400)",
+		"POINT (1 2-3)",
+		"POINT Z (1 2)",
+		"POINT M (1 2 3 4)",
+		"LINESTRING (0 0, 1 1 1)",
+		"LINESTRING (0 0,)",
+		"POLYGON (0 0, 1 1)",
+		"GEOMETRYCOLLECTION (POINT (1 2 3), POINT M (1 2 3))",
+		"GEOMETRYCOLLECTION Z (POINT M (1 2 3))",
+		"SRID=x;POINT (1 2)",
+		"SRID=4326 POINT (1 2)",
+	}
+	for _, text := range bad {
+		_, err := wkt.Parse(text)
+		assert.Error(t, err, text)
+	}
+}
+
+func TestShapes(t *testing.T) {
+	// the layout is the number of dimensions of the tree
+	texts := []string{
+		"POINT M (1 2 30)",
+		"LINESTRING M (0 0 10, 5 5 20)",
+		"POLYGON M ((2 2 0, 3 2 0, 3 3 0, 2 2 0))",
+	}
+	tr := rbush.New(3)
+	for _, text := range texts {
+		s, err := wkt.Parse(text)
+		assert.NoError(t, err)
+		tr.Insert(s)
+	}
+	var found []string
+	tr.Search(geom.LineString{{0, 0, 15}, {10, 10, 35}}, func(item rbush.Item) bool {
+		found = append(found, wkt.Format(item.(*geom.Shape)))
+		return true
+	})
+	assert.ElementsMatch(t, texts[:2], found)
+}